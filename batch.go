@@ -36,10 +36,6 @@ type WriteBatch struct {
 
 // NewWriteBatch initialize a new WriteBatch
 func (db *Database) NewWriteBatch(options WriteBatchOptions) *WriteBatch {
-	if db.options.IndexType == BPlusTree && !db.seqNoFilesExists && !db.isInitial {
-		panic("cannot use write batch, seqNo file does not exist")
-	}
-
 	return &WriteBatch{
 		options:       options,
 		mu:            new(sync.Mutex),
@@ -113,17 +109,32 @@ func (wb *WriteBatch) Commit() error {
 	wb.db.mu.Lock()
 	defer wb.db.mu.Unlock()
 
+	if err := wb.db.writeTransaction(wb.pendingWrites, wb.options.SyncWrites); err != nil {
+		return err
+	}
+
+	// clear the temporary data
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+
+	return nil
+}
+
+// writeTransaction writes the records with a new transaction sequence number and updates the memory index
+// the records become visible only after the transaction finished record has been written
+// must hold a mutex lock before accessing this method
+func (db *Database) writeTransaction(records map[string]*data.LogRecord, syncWrites bool) error {
 	// get the current newest transaction sequence number
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
 
 	// start writing data to the data file
 	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
+	for _, record := range records {
 		// no need to add lock for appendLogRecord since we already have it
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
+		logRecordPos, err := db.appendLogRecord(&data.LogRecord{
+			Key:    logRecordKeyWithSeq(record.Key, seqNo),
+			Value:  record.Value,
+			Type:   record.Type,
+			Expire: record.Expire,
 		})
 
 		if err != nil {
@@ -138,37 +149,38 @@ func (wb *WriteBatch) Commit() error {
 		Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
 		Type: data.LogRecordTxnFinished, // special type representing transaction finished
 	}
-	if _, err := wb.db.appendLogRecord(finishedRecord); err != nil {
+	if _, err := db.appendLogRecord(finishedRecord); err != nil {
 		return err
 	}
 
 	// determine whether to sync based on user configuration
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
+	if syncWrites && db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
 			return err
 		}
 	}
 
 	// update memory index
-	for _, record := range wb.pendingWrites {
+	for _, record := range records {
 		pos := positions[string(record.Key)]
 
 		var oldPos *data.LogRecordPos
 		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
+			oldPos = db.index.Put(record.Key, pos)
 		}
 
 		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+			oldPos, _ = db.index.Delete(record.Key)
+			// the tombstone itself can be reclaimed as well
+			db.addReclaimable(pos)
 		}
 
 		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
+			db.addReclaimable(oldPos)
 		}
-	}
 
-	// clear the temporary data
-	wb.pendingWrites = make(map[string]*data.LogRecord)
+		db.trackWrite(record.Key)
+	}
 
 	return nil
 }
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package benchmark
+
+import (
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/fileio"
+	"github.com/LiuShuoJiang/betadb/utils"
+	"os"
+	"testing"
+)
+
+// the checksum matters the most for large values
+const checksumValueSize = 64 * 1024
+
+func benchmarkEncodeLogRecord(b *testing.B, checksum data.ChecksumType) {
+	record := &data.LogRecord{Key: utils.GetTestKey(1), Value: utils.RandomValue(checksumValueSize)}
+
+	b.SetBytes(checksumValueSize)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		data.EncodeLogRecordWithChecksum(record, checksum)
+	}
+}
+
+func benchmarkReadLogRecord(b *testing.B, flags uint16) {
+	directory, _ := os.MkdirTemp("", "betadb-benchmark-checksum")
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	// write a data file with the given format flags
+	dataFile, err := data.OpenLegacyDataFile(directory, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	header := data.NewDataFileHeader(0, flags)
+	if err := dataFile.Write(data.EncodeDataFileHeader(header)); err != nil {
+		b.Fatal(err)
+	}
+	record := &data.LogRecord{Key: utils.GetTestKey(1), Value: utils.RandomValue(checksumValueSize)}
+	encRecord, _ := data.EncodeLogRecordWithChecksum(record, header.Checksum())
+	if err := dataFile.Write(encRecord); err != nil {
+		b.Fatal(err)
+	}
+	_ = dataFile.Close()
+
+	dataFile, err = data.OpenDataFile(directory, 0, fileio.StandardFileIO)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		_ = dataFile.Close()
+	}()
+
+	b.SetBytes(checksumValueSize)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := dataFile.ReadLogRecord(data.DataFileHeaderSize); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_EncodeLogRecordIEEE(b *testing.B) {
+	benchmarkEncodeLogRecord(b, data.ChecksumIEEE)
+}
+
+func Benchmark_EncodeLogRecordCRC32C(b *testing.B) {
+	benchmarkEncodeLogRecord(b, data.ChecksumCRC32C)
+}
+
+func Benchmark_ReadLogRecordIEEE(b *testing.B) {
+	benchmarkReadLogRecord(b, 0)
+}
+
+func Benchmark_ReadLogRecordCRC32C(b *testing.B) {
+	benchmarkReadLogRecord(b, data.DataFileFlagCRC32C)
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package benchmark
+
+import (
+	"github.com/LiuShuoJiang/betadb/fileio"
+	"github.com/LiuShuoJiang/betadb/utils"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ioValueSize is about the size of an encoded record with a small value
+const ioValueSize = 4 * 1024
+
+// ioFileValues is the number of values in the file read by the read benchmarks
+const ioFileValues = 4096
+
+func openIOManager(b *testing.B, ioType fileio.FileIOType) (fileio.IOManager, func()) {
+	directory, _ := os.MkdirTemp("", "betadb-benchmark-io")
+	ioManager, err := fileio.NewIOManager(filepath.Join(directory, "data"), ioType)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return ioManager, func() {
+		_ = ioManager.Close()
+		_ = os.RemoveAll(directory)
+	}
+}
+
+func benchmarkAppend(b *testing.B, ioType fileio.FileIOType) {
+	ioManager, cleanup := openIOManager(b, ioType)
+	defer cleanup()
+	value := utils.RandomValue(ioValueSize)
+
+	b.SetBytes(ioValueSize)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := ioManager.Write(value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkAppendSync(b *testing.B, ioType fileio.FileIOType) {
+	ioManager, cleanup := openIOManager(b, ioType)
+	defer cleanup()
+	value := utils.RandomValue(ioValueSize)
+
+	b.SetBytes(ioValueSize)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		// the io_uring manager writes and syncs in a single round trip
+		if syncWriter, ok := ioManager.(fileio.SyncWriter); ok {
+			if _, err := syncWriter.WriteSync(value); err != nil {
+				b.Fatal(err)
+			}
+			continue
+		}
+		if _, err := ioManager.Write(value); err != nil {
+			b.Fatal(err)
+		}
+		if err := ioManager.Sync(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkParallelRead(b *testing.B, ioType fileio.FileIOType) {
+	ioManager, cleanup := openIOManager(b, ioType)
+	defer cleanup()
+	value := utils.RandomValue(ioValueSize)
+	for i := 0; i < ioFileValues; i++ {
+		if _, err := ioManager.Write(value); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := ioManager.Sync(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(ioValueSize)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	// the reads of concurrent Get calls, which io_uring submits together
+	b.RunParallel(func(pb *testing.PB) {
+		buffer := make([]byte, ioValueSize)
+		for pb.Next() {
+			offset := int64(rand.Intn(ioFileValues)) * ioValueSize
+			if _, err := ioManager.Read(buffer, offset); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func Benchmark_AppendStandardFileIO(b *testing.B) {
+	benchmarkAppend(b, fileio.StandardFileIO)
+}
+
+func Benchmark_AppendMemoryMap(b *testing.B) {
+	benchmarkAppend(b, fileio.MemoryMap)
+}
+
+func Benchmark_AppendIOUring(b *testing.B) {
+	benchmarkAppend(b, fileio.IOUring)
+}
+
+func Benchmark_AppendSyncStandardFileIO(b *testing.B) {
+	benchmarkAppendSync(b, fileio.StandardFileIO)
+}
+
+func Benchmark_AppendSyncMemoryMap(b *testing.B) {
+	benchmarkAppendSync(b, fileio.MemoryMap)
+}
+
+func Benchmark_AppendSyncIOUring(b *testing.B) {
+	benchmarkAppendSync(b, fileio.IOUring)
+}
+
+func Benchmark_ParallelReadStandardFileIO(b *testing.B) {
+	benchmarkParallelRead(b, fileio.StandardFileIO)
+}
+
+func Benchmark_ParallelReadMemoryMap(b *testing.B) {
+	benchmarkParallelRead(b, fileio.MemoryMap)
+}
+
+func Benchmark_ParallelReadIOUring(b *testing.B) {
+	benchmarkParallelRead(b, fileio.IOUring)
+}
+
+func Benchmark_AppendDirectIO(b *testing.B) {
+	benchmarkAppend(b, fileio.DirectIO)
+}
+
+func Benchmark_AppendSyncDirectIO(b *testing.B) {
+	benchmarkAppendSync(b, fileio.DirectIO)
+}
+
+func Benchmark_ParallelReadDirectIO(b *testing.B) {
+	benchmarkParallelRead(b, fileio.DirectIO)
+}
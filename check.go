@@ -0,0 +1,571 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/fileio"
+	"github.com/LiuShuoJiang/betadb/index"
+	"github.com/gofrs/flock"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CheckIssueType is the type of the problems found by Check
+type CheckIssueType = int8
+
+const (
+	// CorruptedRecord is a record that cannot be decoded or fails its CRC check
+	CorruptedRecord CheckIssueType = iota + 1
+
+	// TornRecord is a record torn by an interrupted write at the tail of the active file, discarded by the next Open
+	TornRecord
+
+	// UncommittedTransaction is a transaction without its finish record, ignored by Open
+	UncommittedTransaction
+
+	// InvalidIndexEntry is an index entry that points to a missing data file or past the end of a data file
+	InvalidIndexEntry
+
+	// InvalidMetadata is a metadata file whose content cannot be parsed
+	InvalidMetadata
+
+	// DanglingMergeDirectory is a merge or compaction directory left by an interrupted process
+	DanglingMergeDirectory
+
+	// UnsupportedFormat is a data file without a header, or written in a newer format
+	UnsupportedFormat
+)
+
+var checkIssueTypeNames = map[CheckIssueType]string{
+	CorruptedRecord:        "corrupted record",
+	TornRecord:             "torn record",
+	UncommittedTransaction: "uncommitted transaction",
+	InvalidIndexEntry:      "invalid index entry",
+	InvalidMetadata:        "invalid metadata",
+	DanglingMergeDirectory: "dangling merge directory",
+	UnsupportedFormat:      "unsupported format",
+}
+
+// CheckIssue is a problem found by Check
+type CheckIssue struct {
+	// Type is the type of the problem
+	Type CheckIssueType
+
+	// FileName is the path of the file or the directory with the problem
+	FileName string
+
+	// Offset is where the problem is in the file
+	Offset int64
+
+	// Message describes the problem
+	Message string
+}
+
+func (issue CheckIssue) String() string {
+	return fmt.Sprintf("%s: %s at offset %d: %s",
+		checkIssueTypeNames[issue.Type], issue.FileName, issue.Offset, issue.Message)
+}
+
+// CheckReport reports the result of Check
+type CheckReport struct {
+	// FileNum is the number of files checked
+	FileNum int
+
+	// RecordNum is the number of records decoded from the data files
+	RecordNum int64
+
+	// Issues are the problems found in the directory
+	Issues []CheckIssue
+}
+
+// Healthy indicates whether Check has found no problem at all
+func (report *CheckReport) Healthy() bool {
+	return len(report.Issues) == 0
+}
+
+// checker walks the files of a data directory for Check
+type checker struct {
+	directoryPath string
+	report        *CheckReport
+
+	// fileSizes are the sizes of the data files
+	fileSizes map[uint32]int64
+}
+
+// Check validates every file of a closed data directory without modifying it
+// it decodes every record of the data files, the hint files and the metadata files,
+// and reports the uncommitted transactions, the dangling merge directories and the index entries out of the data files
+func Check(directoryPath string) (*CheckReport, error) {
+	fileLock, err := lockDirectory(directoryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = fileLock.Unlock()
+	}()
+
+	c := &checker{
+		directoryPath: directoryPath,
+		report:        &CheckReport{},
+		fileSizes:     make(map[uint32]int64),
+	}
+
+	fileIDs, err := listDataFileIDs(directoryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.checkDataFiles(fileIDs); err != nil {
+		return nil, err
+	}
+	if err := c.checkHintFiles(); err != nil {
+		return nil, err
+	}
+	if err := c.checkMetadataFiles(); err != nil {
+		return nil, err
+	}
+	if err := c.checkManifest(fileIDs); err != nil {
+		return nil, err
+	}
+	if err := c.checkBPlusTreeIndex(); err != nil {
+		return nil, err
+	}
+	if err := c.checkMergeDirectories(); err != nil {
+		return nil, err
+	}
+
+	return c.report, nil
+}
+
+// lockDirectory makes sure that the data directory is not used by an open database
+func lockDirectory(directoryPath string) (*flock.Flock, error) {
+	if _, err := os.Stat(directoryPath); err != nil {
+		return nil, err
+	}
+
+	fileLock := flock.New(filepath.Join(directoryPath, fileLockName))
+	hold, err := fileLock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !hold {
+		return nil, ErrDatabaseIsUsing
+	}
+
+	return fileLock, nil
+}
+
+// listDataFileIDs returns the ids of the data files in the directory in ascending order
+func listDataFileIDs(directoryPath string) ([]uint32, error) {
+	directoryEntries, err := os.ReadDir(directoryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIDs []uint32
+	for _, entry := range directoryEntries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+
+		fileID, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix))
+		if err != nil {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		fileIDs = append(fileIDs, uint32(fileID))
+	}
+
+	sort.Slice(fileIDs, func(i, j int) bool {
+		return fileIDs[i] < fileIDs[j]
+	})
+	return fileIDs, nil
+}
+
+func (c *checker) addIssue(issueType CheckIssueType, fileName string, offset int64, format string, args ...any) {
+	c.report.Issues = append(c.report.Issues, CheckIssue{
+		Type:     issueType,
+		FileName: fileName,
+		Offset:   offset,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// checkDataFiles decodes every record of the data files, and tracks the transactions across them
+func (c *checker) checkDataFiles(fileIDs []uint32) error {
+	// pendingTxns are the first record and the number of records of each transaction without its finish record
+	type pendingTxn struct {
+		fileName string
+		offset   int64
+		count    int
+	}
+	pendingTxns := make(map[uint64]*pendingTxn)
+
+	for i, fileID := range fileIDs {
+		fileName := data.GetDataFileName(c.directoryPath, fileID)
+		dataFile, offset, err := c.openDataFile(fileName, fileID)
+		if err != nil {
+			return err
+		}
+		if dataFile == nil {
+			continue
+		}
+
+		fileSize, err := dataFile.IoManager.Size()
+		if err != nil {
+			_ = dataFile.Close()
+			return err
+		}
+		c.fileSizes[fileID] = fileSize
+
+		for offset < fileSize {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err != nil {
+				if err := c.checkDataFileTail(dataFile, fileName, offset, i == len(fileIDs)-1, err); err != nil {
+					_ = dataFile.Close()
+					return err
+				}
+				break
+			}
+			c.report.RecordNum++
+
+			if _, seqNo := parseLogRecordKey(logRecord.Key); seqNo != nonTransactionSeqNo {
+				if logRecord.Type == data.LogRecordTxnFinished {
+					delete(pendingTxns, seqNo)
+				} else if txn, ok := pendingTxns[seqNo]; ok {
+					txn.count++
+				} else {
+					pendingTxns[seqNo] = &pendingTxn{fileName: fileName, offset: offset, count: 1}
+				}
+			}
+
+			offset += size
+		}
+
+		if err := dataFile.Close(); err != nil {
+			return err
+		}
+	}
+
+	seqNos := make([]uint64, 0, len(pendingTxns))
+	for seqNo := range pendingTxns {
+		seqNos = append(seqNos, seqNo)
+	}
+	sort.Slice(seqNos, func(i, j int) bool {
+		return seqNos[i] < seqNos[j]
+	})
+
+	for _, seqNo := range seqNos {
+		txn := pendingTxns[seqNo]
+		c.addIssue(UncommittedTransaction, txn.fileName, txn.offset,
+			"%d records of transaction %d have no finish record", txn.count, seqNo)
+	}
+
+	return nil
+}
+
+// openDataFile opens a data file and returns the offset of its first record
+// a data file with a missing or corrupted header is reported, and its records are decoded as far as possible,
+// while a data file of a newer format is skipped, in which case null is returned
+func (c *checker) openDataFile(fileName string, fileID uint32) (*data.DataFile, int64, error) {
+	fileInfo, err := os.Stat(fileName)
+	if err != nil {
+		return nil, 0, err
+	}
+	c.report.FileNum++
+
+	// a data file created right before a crash may have no header yet, which is written by the next Open
+	if fileInfo.Size() == 0 {
+		c.fileSizes[fileID] = 0
+		return nil, 0, nil
+	}
+
+	dataFile, err := data.OpenDataFile(c.directoryPath, fileID, fileio.StandardFileIO)
+	switch {
+	case err == nil:
+		return dataFile, data.DataFileHeaderSize, nil
+	case errors.Is(err, data.ErrDataFileHeaderMissing):
+		c.addIssue(UnsupportedFormat, fileName, 0, "%v", err)
+		dataFile, err = data.OpenLegacyDataFile(c.directoryPath, fileID)
+		return dataFile, 0, err
+	case errors.Is(err, data.ErrInvalidDataFileHeader):
+		c.addIssue(CorruptedRecord, fileName, 0, "%v", err)
+		dataFile, err = data.OpenLegacyDataFile(c.directoryPath, fileID)
+		return dataFile, data.DataFileHeaderSize, err
+	case errors.Is(err, data.ErrUnsupportedDataFileVersion):
+		c.addIssue(UnsupportedFormat, fileName, 0, "%v", err)
+		c.fileSizes[fileID] = fileInfo.Size()
+		return nil, 0, nil
+	default:
+		return nil, 0, err
+	}
+}
+
+// checkDataFileTail reports the unreadable bytes from offset to the end of a data file
+func (c *checker) checkDataFileTail(dataFile *data.DataFile, fileName string, offset int64, isActive bool,
+	readErr error) error {
+	if readErr == io.EOF {
+		readErr = ErrDataFileCorrupted
+	}
+
+	if isActive {
+		torn, err := dataFile.IsTornTail(offset)
+		if err != nil {
+			return err
+		}
+		if torn {
+			c.addIssue(TornRecord, fileName, offset, "the tail is torn by an interrupted write: %v", readErr)
+			return nil
+		}
+	}
+
+	c.addIssue(CorruptedRecord, fileName, offset, "%v", readErr)
+	return nil
+}
+
+// checkHintFiles checks that every hint record can be decoded and points into its data file
+func (c *checker) checkHintFiles() error {
+	directoryEntries, err := os.ReadDir(c.directoryPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range directoryEntries {
+		if !strings.HasSuffix(entry.Name(), data.HintFileNameSuffix) {
+			continue
+		}
+
+		fileName := filepath.Join(c.directoryPath, entry.Name())
+		fileID, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.HintFileNameSuffix))
+		if err != nil {
+			c.addIssue(InvalidMetadata, fileName, 0, "invalid hint file name")
+			continue
+		}
+		if _, ok := c.fileSizes[uint32(fileID)]; !ok {
+			c.addIssue(InvalidIndexEntry, fileName, 0, "the hint file has no data file")
+			continue
+		}
+
+		err = c.checkRecordFile(fileName, func(record *data.LogRecord, offset int64) error {
+			// the position of a hint record sealed with its key cannot be checked without the encryption key
+			if record.Encrypted {
+				return nil
+			}
+
+			pos := data.DecodeLogRecordPos(record.Value)
+			if pos.Fid != uint32(fileID) {
+				return fmt.Errorf("the hint record points to data file %d", pos.Fid)
+			}
+			c.checkLogRecordPos(fileName, offset, pos)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	fileName := filepath.Join(c.directoryPath, data.HintFileName)
+	return c.checkRecordFile(fileName, func(record *data.LogRecord, offset int64) error {
+		if !record.Encrypted {
+			c.checkLogRecordPos(fileName, offset, data.DecodeLogRecordPos(record.Value))
+		}
+		return nil
+	})
+}
+
+// checkLogRecordPos reports an index entry that points to a missing data file or past the end of a data file
+func (c *checker) checkLogRecordPos(fileName string, offset int64, pos *data.LogRecordPos) {
+	fileSize, ok := c.fileSizes[pos.Fid]
+	if !ok {
+		c.addIssue(InvalidIndexEntry, fileName, offset, "the entry points to missing data file %d", pos.Fid)
+		return
+	}
+
+	if pos.Offset+int64(pos.Size) > fileSize {
+		c.addIssue(InvalidIndexEntry, fileName, offset,
+			"the entry points past the end of data file %d at offset %d", pos.Fid, pos.Offset)
+	}
+}
+
+// checkMetadataFiles checks that the seq-no, merge-finished and reclaim-size files can be parsed
+// the seq-no and merge-finished files are only left in the data directories written by the earlier versions
+func (c *checker) checkMetadataFiles() error {
+	parseUint := func(value []byte) error {
+		_, err := strconv.ParseUint(string(value), 10, 64)
+		return err
+	}
+
+	seqNoFileName := filepath.Join(c.directoryPath, data.SeqNoFileName)
+	err := c.checkRecordFile(seqNoFileName, func(record *data.LogRecord, offset int64) error {
+		if string(record.Key) != seqNoKey {
+			return fmt.Errorf("unexpected key %q", record.Key)
+		}
+		return parseUint(record.Value)
+	})
+	if err != nil {
+		return err
+	}
+
+	mergeFinishedFileName := filepath.Join(c.directoryPath, data.MergeFinishedFileName)
+	err = c.checkRecordFile(mergeFinishedFileName, func(record *data.LogRecord, offset int64) error {
+		if key := string(record.Key); key != mergeFinishedKey && key != mergedFileNumKey {
+			return fmt.Errorf("unexpected key %q", record.Key)
+		}
+		return parseUint(record.Value)
+	})
+	if err != nil {
+		return err
+	}
+
+	reclaimFileName := filepath.Join(c.directoryPath, data.ReclaimFileName)
+	return c.checkRecordFile(reclaimFileName, func(record *data.LogRecord, offset int64) error {
+		if err := parseUint(record.Key); err != nil {
+			return err
+		}
+		return parseUint(record.Value)
+	})
+}
+
+// checkManifest checks that every edit of the manifest can be decoded,
+// and that the data files in the directory are the live ones it records
+func (c *checker) checkManifest(fileIDs []uint32) error {
+	fileName := filepath.Join(c.directoryPath, data.ManifestFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	m := &manifest{fileIDs: make(map[uint32]struct{})}
+	err := c.checkRecordFile(fileName, func(record *data.LogRecord, offset int64) error {
+		if string(record.Key) != manifestEditKey {
+			return fmt.Errorf("unexpected key %q", record.Key)
+		}
+
+		fields, err := decodeManifestEdit(record.Value)
+		if err != nil {
+			return err
+		}
+		m.apply(fields)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the latest data file may not have been created yet
+	liveFileIDs := m.sortedFileIDs()
+	for i, fileID := range liveFileIDs {
+		if _, ok := c.fileSizes[fileID]; !ok && i < len(liveFileIDs)-1 {
+			c.addIssue(InvalidMetadata, fileName, 0, "live data file %d is missing", fileID)
+		}
+	}
+	for _, fileID := range fileIDs {
+		if _, ok := m.fileIDs[fileID]; !ok {
+			c.addIssue(InvalidMetadata, fileName, 0, "data file %d is not recorded", fileID)
+		}
+	}
+
+	return nil
+}
+
+// checkRecordFile decodes every record of a hint or metadata file if it exists, and passes them to fn
+// a record that cannot be decoded or is rejected by fn is reported
+func (c *checker) checkRecordFile(fileName string, fn func(record *data.LogRecord, offset int64) error) error {
+	fileInfo, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.report.FileNum++
+
+	recordFile, err := openRecordFile(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = recordFile.Close()
+	}()
+
+	issueType := InvalidMetadata
+	if strings.HasSuffix(fileName, data.HintFileNameSuffix) || filepath.Base(fileName) == data.HintFileName {
+		issueType = InvalidIndexEntry
+	}
+
+	var offset int64 = 0
+	for offset < fileInfo.Size() {
+		record, size, err := recordFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				err = ErrDataFileCorrupted
+			}
+			c.addIssue(CorruptedRecord, fileName, offset, "%v", err)
+			return nil
+		}
+
+		if err := fn(record, offset); err != nil {
+			c.addIssue(issueType, fileName, offset, "%v", err)
+		}
+		offset += size
+	}
+
+	return nil
+}
+
+// checkBPlusTreeIndex checks that every entry of the B+ tree index points into the data files
+func (c *checker) checkBPlusTreeIndex() error {
+	fileName := filepath.Join(c.directoryPath, index.BPlusTreeIndexFileName)
+	err := index.ForEachBPlusTreeEntry(c.directoryPath, func(key []byte, pos *data.LogRecordPos) bool {
+		c.checkLogRecordPos(fileName, 0, pos)
+		return true
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		c.addIssue(CorruptedRecord, fileName, 0, "%v", err)
+		return nil
+	}
+
+	c.report.FileNum++
+	return nil
+}
+
+// checkMergeDirectories reports the merge and compaction directories left by an interrupted process
+func (c *checker) checkMergeDirectories() error {
+	mergePath := siblingDirectoryPath(c.directoryPath, mergeDirectoryName)
+	if _, err := os.Stat(mergePath); err == nil {
+		if _, err := os.Stat(filepath.Join(mergePath, data.MergeFinishedFileName)); err == nil {
+			c.addIssue(DanglingMergeDirectory, mergePath, 0, "the merge has finished, it is installed by the next Open")
+		} else {
+			c.addIssue(DanglingMergeDirectory, mergePath, 0, "the merge is incomplete, it is removed by the next Open")
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	compactPath := siblingDirectoryPath(c.directoryPath, compactDirectoryName)
+	if _, err := os.Stat(compactPath); err == nil {
+		c.addIssue(DanglingMergeDirectory, compactPath, 0, "the compaction is incomplete, it is removed by the next Open")
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
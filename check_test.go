@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// TestCheck tests for checking a healthy and a damaged data directory
+func TestCheck(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+	options.DataFileSize = 32 * 1024
+	options.DataFileMergeRatio = 0
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+
+	// the directory cannot be checked while the database is open
+	_, err = Check(directory)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	// an interrupted transaction
+	db.mu.Lock()
+	_, err = db.appendLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("txn"), 114),
+		Value: []byte("uncommitted"),
+	})
+	db.mu.Unlock()
+	assert.Nil(t, err)
+
+	activeFileID := db.activeFile.FileID
+	assert.Nil(t, db.Close())
+
+	report, err := Check(directory)
+	assert.Nil(t, err)
+	assert.True(t, report.FileNum > 3)
+	assert.True(t, report.RecordNum > 500)
+	assert.Equal(t, 1, len(report.Issues))
+	assert.Equal(t, UncommittedTransaction, report.Issues[0].Type)
+
+	// a torn write at the tail of the active file
+	file, err := os.OpenFile(data.GetDataFileName(directory, activeFileID), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write([]byte{1, 2, 3, 4, 5, 6})
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	// a corrupted record in the middle of the first data file
+	file, err = os.OpenFile(data.GetDataFileName(directory, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte("corrupted"), 100)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	// an index entry pointing past the end of a data file
+	hintFile, err := data.OpenHintFile(directory)
+	assert.Nil(t, err)
+	assert.Nil(t, hintFile.WriteHintRecord([]byte("missing"), &data.LogRecordPos{Fid: 0, Offset: 1 << 30, Size: 10}))
+	assert.Nil(t, hintFile.Close())
+
+	// a merge interrupted by a crash
+	assert.Nil(t, os.MkdirAll(db.getMergePath(), os.ModePerm))
+	defer func() {
+		_ = os.RemoveAll(db.getMergePath())
+	}()
+
+	report, err = Check(directory)
+	assert.Nil(t, err)
+	assert.False(t, report.Healthy())
+
+	issueTypes := make(map[CheckIssueType]int)
+	for _, issue := range report.Issues {
+		issueTypes[issue.Type]++
+		assert.NotEmpty(t, issue.String())
+	}
+	assert.Equal(t, map[CheckIssueType]int{
+		UncommittedTransaction: 1,
+		TornRecord:             1,
+		CorruptedRecord:        1,
+		InvalidIndexEntry:      1,
+		DanglingMergeDirectory: 1,
+	}, issueTypes)
+}
+
+// TestCheck_Healthy tests for checking a data directory without any problem
+func TestCheck_Healthy(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+	options.DataFileSize = 32 * 1024
+	options.IndexType = BPlusTree
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	report, err := Check(directory)
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy(), "%v", report.Issues)
+	assert.Equal(t, int64(500+100), report.RecordNum)
+}
@@ -0,0 +1,343 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"github.com/LiuShuoJiang/betadb/data"
+	"io"
+	"os"
+	"sort"
+)
+
+const compactDirectoryName = "-compact"
+
+// compactedRecord is a live record moved by the compaction
+type compactedRecord struct {
+	key    []byte
+	oldPos *data.LogRecordPos
+	// newPos is null if the record has expired, which is dropped from the memory index
+	newPos *data.LogRecordPos
+}
+
+// Compact rewrites only the immutable data files whose reclaimable ratio reaches garbageRatio
+//
+// unlike Merge, each rewritten file keeps its file id, so the files that are not rewritten stay untouched.
+// the live records are kept together with the tombstones that may still hide the records in older files,
+// a hint file is written for each rewritten file, and the files are installed while the database stays open
+func (db *Database) Compact(garbageRatio float32) error {
+	if garbageRatio < 0 || garbageRatio > 1 {
+		return ErrInvalidCompactRatio
+	}
+
+	// the positions in a B+ tree index are persisted, so they cannot be replaced together with the files
+	if db.options.IndexType == BPlusTree {
+		return ErrCompactUnsupported
+	}
+
+	db.mu.Lock()
+
+	if db.activeFile == nil {
+		db.mu.Unlock()
+		return nil
+	}
+
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsInProgress
+	}
+
+	// pick the files to be compacted
+	oldestFileID := db.activeFile.FileID
+	var fileIDs []uint32
+	for fileID, dataFile := range db.olderFiles {
+		if fileID < oldestFileID {
+			oldestFileID = fileID
+		}
+
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			db.mu.Unlock()
+			return err
+		}
+
+		reclaimSize := db.fileReclaimSize[fileID]
+		if size > 0 && reclaimSize > 0 && float32(reclaimSize)/float32(size) >= garbageRatio {
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+
+	if len(fileIDs) == 0 {
+		db.mu.Unlock()
+		return nil
+	}
+
+	db.isMerging = true
+	db.mu.Unlock()
+
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
+
+	sort.Slice(fileIDs, func(i, j int) bool {
+		return fileIDs[i] < fileIDs[j]
+	})
+
+	compactPath := db.getCompactPath()
+	if err := os.RemoveAll(compactPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(compactPath, os.ModePerm); err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(compactPath)
+	}()
+
+	for _, fileID := range fileIDs {
+		// the tombstones are only needed if there are older files that they may hide records in
+		if err := db.compactDataFile(fileID, fileID > oldestFileID, compactPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compactDataFile rewrites a data file into the compaction directory and installs it
+func (db *Database) compactDataFile(fileID uint32, keepTombstones bool, compactPath string) error {
+	db.mu.RLock()
+	dataFile := db.olderFiles[fileID]
+	db.mu.RUnlock()
+
+	newFile, err := data.OpenDataFile(compactPath, fileID, db.options.IOType)
+	if err != nil {
+		return err
+	}
+	newFile.Keyring = db.keyring
+	newFile.BlockCache = db.blockCache
+
+	hintFile, err := data.OpenDataHintFile(compactPath, fileID)
+	if err != nil {
+		_ = newFile.Close()
+		return err
+	}
+	hintFile.Keyring = db.keyring
+	defer func() {
+		_ = hintFile.Close()
+	}()
+
+	var movedRecords []*compactedRecord
+	var tombstoneSize int64
+	deletedKeys := make(map[string]struct{})
+
+	writeRecord := func(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+		encRecord, size, err := newFile.EncodeLogRecord(logRecord)
+		if err != nil {
+			return nil, err
+		}
+		pos := &data.LogRecordPos{
+			Fid:    fileID,
+			Offset: newFile.WriteOffset,
+			Size:   uint32(size),
+			Expire: logRecord.Expire,
+		}
+
+		if err := newFile.Write(encRecord); err != nil {
+			return nil, err
+		}
+		if err := hintFile.WriteDataHintRecord(logRecord, pos); err != nil {
+			return nil, err
+		}
+
+		return pos, nil
+	}
+
+	// the records of a transaction are written together right before its marker,
+	// so only the transaction of the first record may have records in the previous files
+	firstSeqNo := nonTransactionSeqNo
+
+	var offset int64 = data.DataFileHeaderSize
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			_ = newFile.Close()
+			return err
+		}
+
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		if offset == data.DataFileHeaderSize {
+			firstSeqNo = seqNo
+		}
+
+		switch logRecord.Type {
+		case data.LogRecordTxnFinished:
+			// the committed records in the file are rewritten as non-transactional ones,
+			// the marker is only needed by the records of the transaction left in the previous files
+			if seqNo == firstSeqNo {
+				if _, err := writeRecord(logRecord); err != nil {
+					_ = newFile.Close()
+					return err
+				}
+			}
+		case data.LogRecordRangeDeleted:
+			if keepTombstones {
+				pos, err := writeRecord(logRecord)
+				if err != nil {
+					_ = newFile.Close()
+					return err
+				}
+				tombstoneSize += int64(pos.Size)
+			}
+		default:
+			logRecordPos := db.index.Get(realKey)
+
+			isLive := logRecord.Type == data.LogRecordNormal && logRecordPos != nil &&
+				logRecordPos.Fid == fileID && logRecordPos.Offset == offset
+
+			if isLive && isExpired(logRecord.Expire) {
+				// the expired record is dropped, and deleted in case an older file still has a record of the key
+				movedRecords = append(movedRecords, &compactedRecord{
+					key:    realKey,
+					oldPos: logRecordPos,
+				})
+
+				if _, ok := deletedKeys[string(realKey)]; keepTombstones && !ok {
+					deletedKeys[string(realKey)] = struct{}{}
+
+					pos, err := writeRecord(&data.LogRecord{
+						Key:  logRecordKeyWithSeq(realKey, nonTransactionSeqNo),
+						Type: data.LogRecordDeleted,
+					})
+					if err != nil {
+						_ = newFile.Close()
+						return err
+					}
+					tombstoneSize += int64(pos.Size)
+				}
+			} else if isLive {
+				// the live record is moved, clearing the transaction marking
+				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+				pos, err := writeRecord(logRecord)
+				if err != nil {
+					_ = newFile.Close()
+					return err
+				}
+
+				movedRecords = append(movedRecords, &compactedRecord{
+					key:    realKey,
+					oldPos: logRecordPos,
+					newPos: pos,
+				})
+			} else if _, ok := deletedKeys[string(realKey)]; logRecordPos == nil && keepTombstones && !ok {
+				// the key is missing, keep it deleted in case an older file still has a record of it
+				deletedKeys[string(realKey)] = struct{}{}
+
+				pos, err := writeRecord(&data.LogRecord{
+					Key:  logRecordKeyWithSeq(realKey, nonTransactionSeqNo),
+					Type: data.LogRecordDeleted,
+				})
+				if err != nil {
+					_ = newFile.Close()
+					return err
+				}
+				tombstoneSize += int64(pos.Size)
+			}
+		}
+
+		offset += size
+	}
+
+	if err := newFile.Sync(); err != nil {
+		_ = newFile.Close()
+		return err
+	}
+	if err := hintFile.Sync(); err != nil {
+		_ = newFile.Close()
+		return err
+	}
+
+	return db.installCompactedFile(dataFile, newFile, movedRecords, tombstoneSize, compactPath)
+}
+
+// installCompactedFile replaces the data file with its compacted version and updates the memory index
+func (db *Database) installCompactedFile(oldFile, newFile *data.DataFile, movedRecords []*compactedRecord,
+	tombstoneSize int64, compactPath string) error {
+	fileID := oldFile.FileID
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// remove the outdated hint first, so that a crash never pairs it with the new data file
+	hintFileName := data.GetHintFileName(db.options.DirectoryPath, fileID)
+	if err := os.RemoveAll(hintFileName); err != nil {
+		_ = newFile.Close()
+		return err
+	}
+
+	// the old file stays readable for the pinned readers, since they keep its descriptor open
+	if err := db.holdPinnedFile(oldFile); err != nil {
+		_ = newFile.Close()
+		return err
+	}
+	if err := os.Rename(data.GetDataFileName(compactPath, fileID),
+		data.GetDataFileName(db.options.DirectoryPath, fileID)); err != nil {
+		_ = newFile.Close()
+		return err
+	}
+
+	// the records overwritten or deleted during the compaction are already invalid in the new file
+	reclaimSize := tombstoneSize
+	for _, record := range movedRecords {
+		pos := db.index.Get(record.key)
+		switch {
+		case pos == nil || pos.Fid != record.oldPos.Fid || pos.Offset != record.oldPos.Offset:
+			if record.newPos != nil {
+				reclaimSize += int64(record.newPos.Size)
+			}
+		case record.newPos == nil:
+			db.index.Delete(record.key)
+		default:
+			db.index.Put(record.key, record.newPos)
+		}
+	}
+
+	db.reclaimSize += reclaimSize - db.fileReclaimSize[fileID]
+	db.fileReclaimSize[fileID] = reclaimSize
+
+	if err := db.cacheDataFile(newFile, db.options.IOType, false); err != nil {
+		_ = newFile.Close()
+		return err
+	}
+	db.olderFiles[fileID] = newFile
+	if err := db.retireDataFile(oldFile); err != nil {
+		return err
+	}
+
+	// the cached values of the old file are found at other positions in the new one
+	if db.valueCache != nil {
+		db.valueCache.invalidate(func(fid uint32) bool {
+			return fid == fileID
+		})
+	}
+
+	return os.Rename(data.GetHintFileName(compactPath, fileID), hintFileName)
+}
+
+func (db *Database) getCompactPath() string {
+	return siblingDirectoryPath(db.options.DirectoryPath, compactDirectoryName)
+}
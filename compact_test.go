@@ -0,0 +1,307 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"fmt"
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDatabase_Compact(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-compact")
+	options.DataFileSize = 64 * 1024
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 5000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	// overwrite most of the keys in the first file
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("new-value"))
+		assert.Nil(t, err)
+	}
+	for i := 1000; i < 1500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	stat := db.Stat()
+	assert.Greater(t, stat.FileReclaimableSize[0], int64(0))
+	oldSize, err := db.olderFiles[0].IoManager.Size()
+	assert.Nil(t, err)
+
+	// an iterator created before the compaction keeps reading the old file
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	defer iterator.Close()
+
+	err = db.Compact(0.5)
+	assert.Nil(t, err)
+
+	newSize, err := db.olderFiles[0].IoManager.Size()
+	assert.Nil(t, err)
+	assert.Less(t, newSize, oldSize)
+	assert.Less(t, db.Stat().ReclaimableSize, stat.ReclaimableSize)
+
+	_, err = os.Stat(data.GetHintFileName(directory, 0))
+	assert.Nil(t, err)
+
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		_, err := iterator.Value()
+		assert.Nil(t, err)
+	}
+
+	check := func(db *Database) {
+		assert.Equal(t, 4500, len(db.ListKeys()))
+
+		for i := 0; i < 5000; i++ {
+			value, err := db.Get(utils.GetTestKey(i))
+			switch {
+			case i < 1000:
+				assert.Nil(t, err)
+				assert.Equal(t, []byte("new-value"), value)
+			case i < 1500:
+				assert.Equal(t, ErrKeyNotFound, err)
+			default:
+				assert.Nil(t, err)
+				assert.Equal(t, utils.GetTestKey(i), value)
+			}
+		}
+	}
+	check(db)
+
+	// restart database, the compacted files are loaded from their hint files
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	check(db2)
+
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDatabase_CompactKeepsTombstones(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-compact")
+	options.DataFileSize = 256 * 1024
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// the first file holds live data only
+	for i := 0; i < 8000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	assert.Greater(t, len(db.olderFiles), 0)
+
+	// the following files are mostly garbage, with the tombstones of the keys in the first file
+	for i := 0; i < 5000; i++ {
+		err := db.Put([]byte("garbage"), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	err = db.DeleteRange(utils.GetTestKey(1), utils.GetTestKey(10))
+	assert.Nil(t, err)
+	for i := 0; i < 5000; i++ {
+		err := db.Put([]byte("garbage"), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Delete([]byte("garbage"))
+	assert.Nil(t, err)
+
+	reclaimSize := db.Stat().ReclaimableSize
+	err = db.Compact(0.9)
+	assert.Nil(t, err)
+	assert.Less(t, db.Stat().ReclaimableSize, reclaimSize/2)
+
+	// restart database, the deleted keys must not come back
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(options)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		_, err = db2.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	_, err = db2.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, 7990, len(db2.ListKeys()))
+
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDatabase_CompactExpiredRecord(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-compact")
+	options.DataFileSize = 64 * 1024
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// the first file holds the old value without TTL
+	err = db.Put([]byte("key"), []byte("old"))
+	assert.Nil(t, err)
+	for i := 0; db.activeFile.FileID == 0; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// the second file holds the value with TTL, and is mostly garbage
+	err = db.PutWithTTL([]byte("key"), []byte("new"), 100*time.Millisecond)
+	assert.Nil(t, err)
+	for db.activeFile.FileID == 1 {
+		err := db.Put([]byte("garbage"), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	err = db.Compact(0.3)
+	assert.Nil(t, err)
+	assert.Nil(t, db.index.Get([]byte("key")))
+	_, err = db.Get([]byte("key"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// restart database, the old value must not come back
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	_, err = db2.Get([]byte("key"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDatabase_CompactTxnFinished(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-compact")
+	options.DataFileSize = 64 * 1024
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// the first file holds live data only, with room for the first records of the transaction
+	err = db.Put(utils.GetTestKey(0), utils.GetTestKey(0))
+	assert.Nil(t, err)
+	for i := 1; db.activeFile.WriteOffset < options.DataFileSize-3*1024; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// the transaction spans both files, and its marker is written in the second one
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 10; i++ {
+		err := wb.Put([]byte(fmt.Sprintf("txn-key-%d", i)), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), db.activeFile.FileID)
+
+	// the second file is mostly garbage, so it is the only file to be compacted
+	for db.activeFile.FileID == 1 {
+		err := db.Put([]byte("garbage"), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	size, err := db.olderFiles[0].IoManager.Size()
+	assert.Nil(t, err)
+
+	err = db.Compact(0.5)
+	assert.Nil(t, err)
+	newSize, err := db.olderFiles[0].IoManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, size, newSize)
+
+	// restart database, the records of the transaction left in the first file must be loaded
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := db2.Get([]byte(fmt.Sprintf("txn-key-%d", i)))
+		assert.Nil(t, err)
+	}
+
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDatabase_CompactBPlusTree(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-compact")
+	options.DirectoryPath = directory
+	options.IndexType = BPlusTree
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(1), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+
+	err = db.Compact(0)
+	assert.Equal(t, ErrCompactUnsupported, err)
+
+	// the reclaimable sizes survive a restart without loading the data files
+	reclaimSize := db.Stat().FileReclaimableSize[0]
+	assert.Greater(t, reclaimSize, int64(0))
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, reclaimSize, db2.Stat().FileReclaimableSize[0])
+	assert.Equal(t, reclaimSize, db2.Stat().ReclaimableSize)
+
+	err = db2.Close()
+	assert.Nil(t, err)
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"bytes"
+	"compress/flate"
+	"github.com/LiuShuoJiang/betadb/data"
+	"io"
+	"sync"
+)
+
+// Compressor compresses the values stored in the data files
+type Compressor interface {
+	// ID identifies the compressor in the values it has compressed, so it must never change
+	// the ids below 16 are reserved for the built-in compressors
+	ID() byte
+
+	// Compress returns the compressed value
+	Compress(value []byte) ([]byte, error)
+
+	// Decompress returns the original value of a compressed one
+	Decompress(compressed []byte) ([]byte, error)
+}
+
+const flateCompressorID byte = 1
+
+// FlateCompressor compresses the values with DEFLATE at the default compression level
+var FlateCompressor = NewFlateCompressor(flate.DefaultCompression)
+
+// builtinCompressors are the compressors whose values can be read whatever the options are
+var builtinCompressors = map[byte]Compressor{
+	flateCompressorID: FlateCompressor,
+}
+
+// flateCompressor compresses the values with DEFLATE from the standard library
+type flateCompressor struct {
+	level int
+
+	// writers reuses the compressors, which allocate large buffers
+	writers sync.Pool
+}
+
+// NewFlateCompressor creates a compressor using DEFLATE at the given level of compress/flate
+func NewFlateCompressor(level int) Compressor {
+	return &flateCompressor{level: level}
+}
+
+func (fc *flateCompressor) ID() byte {
+	return flateCompressorID
+}
+
+func (fc *flateCompressor) Compress(value []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	writer, ok := fc.writers.Get().(*flate.Writer)
+	if ok {
+		writer.Reset(&buffer)
+	} else {
+		var err error
+		if writer, err = flate.NewWriter(&buffer, fc.level); err != nil {
+			return nil, err
+		}
+	}
+	defer fc.writers.Put(writer)
+
+	if _, err := writer.Write(value); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (fc *flateCompressor) Decompress(compressed []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(compressed))
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	return io.ReadAll(reader)
+}
+
+// compressLogRecord returns the record with its value compressed by the configured compressor
+// the value stays uncompressed if it is below the min size or does not get any smaller,
+// otherwise it is prefixed with the id of the compressor
+func (db *Database) compressLogRecord(logRecord *data.LogRecord) (*data.LogRecord, error) {
+	compressor := db.options.Compression
+	if compressor == nil || logRecord.Type != data.LogRecordNormal || logRecord.Compressed || logRecord.Encrypted ||
+		len(logRecord.Value) < db.options.CompressionMinSize {
+		return logRecord, nil
+	}
+
+	compressed, err := compressor.Compress(logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed)+1 >= len(logRecord.Value) {
+		return logRecord, nil
+	}
+
+	return &data.LogRecord{
+		Key:        logRecord.Key,
+		Value:      append([]byte{compressor.ID()}, compressed...),
+		Type:       logRecord.Type,
+		Expire:     logRecord.Expire,
+		Compressed: true,
+	}, nil
+}
+
+// decompressValue returns the original value of a record
+func (db *Database) decompressValue(logRecord *data.LogRecord) ([]byte, error) {
+	if !logRecord.Compressed {
+		return logRecord.Value, nil
+	}
+	if len(logRecord.Value) == 0 {
+		return nil, data.ErrInvalidCRC
+	}
+
+	id := logRecord.Value[0]
+	compressor := builtinCompressors[id]
+	if db.options.Compression != nil && db.options.Compression.ID() == id {
+		compressor = db.options.Compression
+	}
+	if compressor == nil {
+		return nil, ErrUnknownCompressor
+	}
+
+	return compressor.Decompress(logRecord.Value[1:])
+}
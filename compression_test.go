@@ -0,0 +1,237 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"bytes"
+	"compress/flate"
+	"github.com/LiuShuoJiang/betadb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// compressibleValue returns a value of the given size that compresses well
+func compressibleValue(i, n int) []byte {
+	return bytes.Repeat(utils.GetTestKey(i), n/len(utils.GetTestKey(i))+1)[:n]
+}
+
+func TestFlateCompressor(t *testing.T) {
+	compressor := NewFlateCompressor(flate.BestSpeed)
+	assert.Equal(t, FlateCompressor.ID(), compressor.ID())
+
+	for _, value := range [][]byte{nil, []byte("betadb"), compressibleValue(1, 64*1024), utils.RandomValue(4096)} {
+		compressed, err := compressor.Compress(value)
+		assert.Nil(t, err)
+
+		decompressed, err := compressor.Decompress(compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, len(value), len(decompressed))
+		assert.True(t, bytes.Equal(value, decompressed))
+	}
+
+	_, err := compressor.Decompress([]byte("not deflate"))
+	assert.NotNil(t, err)
+}
+
+func TestDatabase_Compression(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-compression")
+	options.DataFileSize = 64 * 1024
+	options.DirectoryPath = directory
+	options.Compression = FlateCompressor
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, db.Stat().CompressionRatio)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), compressibleValue(i, 1024))
+		assert.Nil(t, err)
+	}
+	// the values below the min size are stored as they are
+	err = db.Put([]byte("small"), compressibleValue(0, options.CompressionMinSize-1))
+	assert.Nil(t, err)
+	logRecord, _, err := db.activeFile.ReadLogRecord(db.index.Get([]byte("small")).Offset)
+	assert.Nil(t, err)
+	assert.False(t, logRecord.Compressed)
+
+	logRecord, _, err = db.activeFile.ReadLogRecord(db.index.Get(utils.GetTestKey(999)).Offset)
+	assert.Nil(t, err)
+	assert.True(t, logRecord.Compressed)
+	assert.Equal(t, FlateCompressor.ID(), logRecord.Value[0])
+
+	stat := db.Stat()
+	assert.Greater(t, stat.CompressionRatio, 5.0)
+	assert.Less(t, stat.DiskSize, int64(1000*1024/5))
+
+	check := func(db *Database) {
+		for i := 0; i < 1000; i++ {
+			value, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, compressibleValue(i, 1024), value)
+		}
+		value, err := db.Get([]byte("small"))
+		assert.Nil(t, err)
+		assert.Equal(t, compressibleValue(0, options.CompressionMinSize-1), value)
+
+		iterator := db.NewIterator(DefaultIteratorOptions)
+		defer iterator.Close()
+		iterator.Seek(utils.GetTestKey(10))
+		value, err = iterator.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, compressibleValue(10, 1024), value)
+	}
+	check(db)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check(db)
+}
+
+func TestDatabase_CompressionMixedFiles(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-compression")
+	options.DataFileSize = 64 * 1024
+	options.DataFileMergeRatio = 0
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// the first half is written uncompressed, the second half compressed
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), compressibleValue(i, 1024))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	options.Compression = FlateCompressor
+	db, err = Open(options)
+	assert.Nil(t, err)
+	for i := 500; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), compressibleValue(i, 1024))
+		assert.Nil(t, err)
+	}
+
+	check := func(db *Database) {
+		assert.Equal(t, 1000, len(db.ListKeys()))
+		for i := 0; i < 1000; i++ {
+			value, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, compressibleValue(i, 1024), value)
+		}
+	}
+	check(db)
+
+	// the compressed values can still be read once the compression is turned off
+	err = db.Close()
+	assert.Nil(t, err)
+	options.Compression = nil
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check(db)
+
+	// a merge with the compression turned on compresses the remaining values
+	err = db.Close()
+	assert.Nil(t, err)
+	options.Compression = FlateCompressor
+	db, err = Open(options)
+	assert.Nil(t, err)
+	sizeBefore := db.Stat().DiskSize
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.Less(t, db.Stat().DiskSize, sizeBefore)
+	check(db)
+}
+
+// unknownCompressor is a custom compressor that strips the padding of 1KB values
+type unknownCompressor struct{}
+
+func (unknownCompressor) ID() byte {
+	return 100
+}
+
+func (unknownCompressor) Compress(value []byte) ([]byte, error) {
+	return bytes.TrimRight(value, "x"), nil
+}
+
+func (unknownCompressor) Decompress(compressed []byte) ([]byte, error) {
+	return append(compressed, bytes.Repeat([]byte("x"), 1024-len(compressed))...), nil
+}
+
+func TestDatabase_CompressionUnknownCompressor(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-compression")
+	options.DirectoryPath = directory
+	options.Compression = unknownCompressor{}
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	value := append([]byte("betadb"), bytes.Repeat([]byte("x"), 1018)...)
+	err = db.Put([]byte("key"), value)
+	assert.Nil(t, err)
+	got, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, value, got)
+
+	// without the custom compressor the value cannot be read
+	err = db.Close()
+	assert.Nil(t, err)
+	options.Compression = FlateCompressor
+	db, err = Open(options)
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("key"))
+	assert.Equal(t, ErrUnknownCompressor, err)
+}
+
+func TestDatabase_CompressionCompactionFilter(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-compression")
+	options.DataFileSize = 64 * 1024
+	options.DataFileMergeRatio = 0
+	options.DirectoryPath = directory
+	options.Compression = FlateCompressor
+	options.CompactionFilter = func(key, value []byte) (bool, []byte) {
+		if !bytes.Equal(value, compressibleValue(0, 1024)) {
+			return false, nil
+		}
+		return true, []byte("rewritten")
+	}
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), compressibleValue(i%2, 1024))
+		assert.Nil(t, err)
+	}
+
+	// the filter sees the original values
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.Equal(t, 50, len(db.ListKeys()))
+	value, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("rewritten"), value)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
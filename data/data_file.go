@@ -19,6 +19,7 @@ import (
 	"hash/crc32"
 	"io"
 	"path/filepath"
+	"sync/atomic"
 )
 
 var (
@@ -26,12 +27,20 @@ var (
 )
 
 const (
-	DataFileNameSuffix    = ".data"
-	HintFileName          = "hint-index"
-	MergeFinishedFileName = "merge-finished"
-	SeqNoFileName         = "seq-no"
+	DataFileNameSuffix     = ".data"
+	HintFileNameSuffix     = ".hint"
+	HintTempFileNameSuffix = ".hint.tmp"
+	HintFileName           = "hint-index"
+	MergeFinishedFileName  = "merge-finished"
+	SeqNoFileName          = "seq-no"
+	ReclaimFileName        = "reclaim-size"
+	ManifestFileName       = "MANIFEST"
+	ManifestTempFileName   = "MANIFEST.tmp"
 )
 
+// tornTailChunkSize is the number of bytes read at a time when checking the tail of a file
+const tornTailChunkSize = 64 * 1024
+
 // DataFile defines the IO format of data file
 type DataFile struct {
 	// FileID is the unique identifier of the data file
@@ -42,8 +51,27 @@ type DataFile struct {
 
 	// FileIOManager is the file IO manager
 	IoManager fileio.IOManager
+
+	// Header is the header of the data file, null for the other files and the data files of the earlier format
+	Header *DataFileHeader
+
+	// Checksum is the checksum algorithm of the records in the file
+	Checksum ChecksumType
+
+	// Keyring encrypts the records written and decrypts the records read,
+	// null leaves the encrypted records sealed when they are read
+	Keyring *Keyring
+
+	// BlockCache caches the blocks read by an IO manager reading whole blocks, such as direct IO
+	BlockCache *fileio.BlockCache
+
+	// cacheID identifies the blocks of the file in the block cache
+	cacheID uint64
 }
 
+// nextCacheID gives each data file opened, or truncated, its own blocks in the block cache
+var nextCacheID atomic.Uint64
+
 // newDataFile creates a new data file
 func newDataFile(fileName string, fileID uint32, ioType fileio.FileIOType) (*DataFile, error) {
 	// initialize IOManager interface
@@ -56,6 +84,7 @@ func newDataFile(fileName string, fileID uint32, ioType fileio.FileIOType) (*Dat
 		FileID:      fileID,
 		WriteOffset: 0,
 		IoManager:   ioManager,
+		cacheID:     nextCacheID.Add(1),
 	}, nil
 }
 
@@ -65,9 +94,59 @@ func GetDataFileName(directoryPath string, fileID uint32) string {
 }
 
 // OpenDataFile opens a new data file
+// a new data file starts with its header, and the header of an existing one is validated
 func OpenDataFile(directoryPath string, fileID uint32, ioType fileio.FileIOType) (*DataFile, error) {
 	fileName := GetDataFileName(directoryPath, fileID)
-	return newDataFile(fileName, fileID, ioType)
+	if err := initDataFileHeader(fileName, fileID); err != nil {
+		return nil, err
+	}
+
+	dataFile, err := newDataFile(fileName, fileID, ioType)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := dataFile.ReadHeader()
+	if err == nil && header.FileID != fileID {
+		err = ErrInvalidDataFileHeader
+	}
+	if err != nil {
+		_ = dataFile.Close()
+		return nil, err
+	}
+
+	dataFile.Header = header
+	dataFile.Checksum = header.Checksum()
+	dataFile.WriteOffset = DataFileHeaderSize
+	return dataFile, nil
+}
+
+// OpenLegacyDataFile opens a data file of the earlier format, whose records start at the beginning of the file
+func OpenLegacyDataFile(directoryPath string, fileID uint32) (*DataFile, error) {
+	fileName := GetDataFileName(directoryPath, fileID)
+	return newDataFile(fileName, fileID, fileio.StandardFileIO)
+}
+
+// GetHintFileName is a utility function to return the name of the hint file of a data file
+func GetHintFileName(directoryPath string, fileID uint32) string {
+	return filepath.Join(directoryPath, fmt.Sprintf("%09d", fileID)+HintFileNameSuffix)
+}
+
+// OpenDataHintFile opens the hint file of a data file, which indexes every record of the data file in order
+func OpenDataHintFile(directoryPath string, fileID uint32) (*DataFile, error) {
+	fileName := GetHintFileName(directoryPath, fileID)
+	return newDataFile(fileName, fileID, fileio.StandardFileIO)
+}
+
+// GetHintTempFileName is a utility function to return the name of the hint file of a data file being written
+func GetHintTempFileName(directoryPath string, fileID uint32) string {
+	return filepath.Join(directoryPath, fmt.Sprintf("%09d", fileID)+HintTempFileNameSuffix)
+}
+
+// OpenDataHintTempFile opens the hint file of a data file being written, it is renamed to the hint file once complete
+func OpenDataHintTempFile(directoryPath string, fileID uint32) (*DataFile, error) {
+	fileName := GetHintTempFileName(directoryPath, fileID)
+	return newDataFile(fileName, fileID, fileio.StandardFileIO)
 }
 
 // OpenHintFile opens the hint index file
@@ -82,6 +161,24 @@ func OpenMergeFinishedFile(directoryPath string) (*DataFile, error) {
 	return newDataFile(fileName, 0, fileio.StandardFileIO)
 }
 
+// OpenReclaimFile opens the file that stores the reclaimable size of each data file
+func OpenReclaimFile(directoryPath string) (*DataFile, error) {
+	fileName := filepath.Join(directoryPath, ReclaimFileName)
+	return newDataFile(fileName, 0, fileio.StandardFileIO)
+}
+
+// OpenManifestFile opens the append-only file that records the changes of the data directory
+func OpenManifestFile(directoryPath string) (*DataFile, error) {
+	fileName := filepath.Join(directoryPath, ManifestFileName)
+	return newDataFile(fileName, 0, fileio.StandardFileIO)
+}
+
+// OpenManifestTempFile opens the file that a new manifest is written to, it is renamed to the manifest once complete
+func OpenManifestTempFile(directoryPath string) (*DataFile, error) {
+	fileName := filepath.Join(directoryPath, ManifestTempFileName)
+	return newDataFile(fileName, 0, fileio.StandardFileIO)
+}
+
 // OpenSeqNoFile opens the file that stores the transaction sequence number
 func OpenSeqNoFile(directoryPath string) (*DataFile, error) {
 	fileName := filepath.Join(directoryPath, SeqNoFileName)
@@ -123,8 +220,16 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// get the LogRecord length
 	var recordSize = headerSize + keySize + valueSize
 
+	// the record is cut off by the end of the file, which is checked before allocating the buffer
+	// since a corrupted header may claim any size
+	if offset+recordSize > fileSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
 	logRecord := &LogRecord{
-		Type: header.recordType,
+		Type:       header.recordType,
+		Expire:     header.expire,
+		Compressed: header.compressed,
 	}
 
 	// start reading the key/value data actually stored by the user
@@ -140,14 +245,96 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	}
 
 	// verify the validity of data
-	crc := getLogRecordCRC(logRecord, headerBuffer[crc32.Size:headerSize])
+	crc := getLogRecordCRC(logRecord, headerBuffer[crc32.Size:headerSize], df.Checksum)
 	if crc != header.crc {
 		return nil, 0, ErrInvalidCRC
 	}
 
+	// the checksum covers the sealed bytes, so a corrupted record is told apart from a wrong key
+	logRecord.Encrypted = header.encrypted
+	if logRecord.Encrypted && df.Keyring != nil {
+		if err := df.Keyring.open(logRecord); err != nil {
+			return nil, 0, err
+		}
+	}
+
 	return logRecord, recordSize, nil
 }
 
+// IsTornTail checks whether the bytes from offset to the end of the file are a record torn by an interrupted write
+// it should be called where reading a log record has failed, and the torn record may only be followed by zeros
+// a complete record followed by other data means that the file is corrupted in the middle
+func (df *DataFile) IsTornTail(offset int64) (bool, error) {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return false, err
+	}
+
+	remaining := fileSize - offset
+	if remaining < maxLogRecordHeaderSize {
+		return true, nil
+	}
+
+	headerBuffer, err := df.readNBytes(maxLogRecordHeaderSize, offset)
+	if err != nil {
+		return false, err
+	}
+
+	recordEnd := offset
+	if header, headerSize := decodeLogRecordHeader(headerBuffer); header != nil {
+		recordEnd += headerSize + int64(header.keySize) + int64(header.valueSize)
+	}
+
+	// the record is cut off by the end of the file
+	if recordEnd >= fileSize {
+		return true, nil
+	}
+
+	// the file system may leave zeros after the last record written before a crash
+	for recordEnd < fileSize {
+		numBytes := min(fileSize-recordEnd, tornTailChunkSize)
+		buffer, err := df.readNBytes(numBytes, recordEnd)
+		if err != nil {
+			return false, err
+		}
+
+		for _, b := range buffer {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		recordEnd += numBytes
+	}
+
+	return true, nil
+}
+
+// EncodeLogRecord encodes a LogRecord with the checksum algorithm of the data file,
+// after sealing it with the Keyring of the data file
+func (df *DataFile) EncodeLogRecord(logRecord *LogRecord) ([]byte, int64, error) {
+	logRecord, err := df.Keyring.seal(logRecord)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	encRecord, size := EncodeLogRecordWithChecksum(logRecord, df.Checksum)
+	return encRecord, size, nil
+}
+
+// encodeHintRecord encodes a hint record, which is only sealed if the Keyring of the file encrypts the keys
+// since the positions it holds are not confidential
+func (df *DataFile) encodeHintRecord(record *LogRecord) ([]byte, error) {
+	if df.Keyring.EncryptsKeys() {
+		var err error
+		if record, err = df.Keyring.seal(record); err != nil {
+			return nil, err
+		}
+	}
+
+	encRecord, _ := EncodeLogRecord(record)
+	return encRecord, nil
+}
+
 // Write writes the given byte array to the data file
 func (df *DataFile) Write(buffer []byte) error {
 	numBytes, err := df.IoManager.Write(buffer)
@@ -160,6 +347,23 @@ func (df *DataFile) Write(buffer []byte) error {
 	return nil
 }
 
+// WriteSync writes the byte array to the data file and syncs it,
+// in a single operation if the IO manager supports it
+func (df *DataFile) WriteSync(buffer []byte) error {
+	syncWriter, ok := df.IoManager.(fileio.SyncWriter)
+	if !ok {
+		if err := df.Write(buffer); err != nil {
+			return err
+		}
+		return df.Sync()
+	}
+
+	numBytes, err := syncWriter.WriteSync(buffer)
+	df.WriteOffset += int64(numBytes)
+
+	return err
+}
+
 // WriteHintRecord writes the hint record to the hint index file
 func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 	record := &LogRecord{
@@ -167,7 +371,26 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 		Value: EncodeLogRecordPos(pos),
 	}
 
-	encRecord, _ := EncodeLogRecord(record)
+	encRecord, err := df.encodeHintRecord(record)
+	if err != nil {
+		return err
+	}
+	return df.Write(encRecord)
+}
+
+// WriteDataHintRecord writes the hint of a record in a data file to the hint file of the data file
+// the hint keeps the key and the type of the record, and stores the position as the value
+func (df *DataFile) WriteDataHintRecord(logRecord *LogRecord, pos *LogRecordPos) error {
+	record := &LogRecord{
+		Key:   logRecord.Key,
+		Value: EncodeLogRecordPos(pos),
+		Type:  logRecord.Type,
+	}
+
+	encRecord, err := df.encodeHintRecord(record)
+	if err != nil {
+		return err
+	}
 	return df.Write(encRecord)
 }
 
@@ -176,6 +399,16 @@ func (df *DataFile) Sync() error {
 	return df.IoManager.Sync()
 }
 
+// Truncate discards the data after the given size, along with the blocks of the file cached
+func (df *DataFile) Truncate(size int64) error {
+	if err := df.IoManager.Truncate(size); err != nil {
+		return err
+	}
+	df.cacheID = nextCacheID.Add(1)
+
+	return nil
+}
+
 // Close closes the data file
 func (df *DataFile) Close() error {
 	return df.IoManager.Close()
@@ -198,7 +431,66 @@ func (df *DataFile) SetIOManager(directoryPath string, ioType fileio.FileIOType)
 
 // readNBytes is a utility function that reads n bytes from the data file
 func (df *DataFile) readNBytes(numBytes int64, offset int64) (b []byte, err error) {
+	if blockReader, ok := df.IoManager.(fileio.BlockReader); ok && blockReader.BlockSize() > 0 && numBytes > 0 {
+		return df.readBlocks(numBytes, offset, int64(blockReader.BlockSize()))
+	}
+
 	b = make([]byte, numBytes)
 	_, err = df.IoManager.Read(b, offset)
 	return
 }
+
+// readBlocks reads n bytes from the data file with a single read of the aligned blocks covering them,
+// unless all the blocks are found in the block cache
+func (df *DataFile) readBlocks(numBytes int64, offset int64, blockSize int64) ([]byte, error) {
+	first, last := offset/blockSize, (offset+numBytes-1)/blockSize
+	b := make([]byte, numBytes)
+	if df.BlockCache != nil && df.readCachedBlocks(b, offset, first, last, blockSize) {
+		return b, nil
+	}
+
+	start := first * blockSize
+	buffer := fileio.AlignedBuffer(int((last - first + 1) * blockSize))
+	readBytes, err := df.IoManager.Read(buffer, start)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if start+int64(readBytes) < offset+numBytes {
+		copy(b, buffer[min(offset-start, int64(readBytes)):readBytes])
+		return b, io.EOF
+	}
+	copy(b, buffer[offset-start:])
+
+	// only the whole blocks are cached, since the last block of the active file is still being written
+	if df.BlockCache != nil {
+		for block := first; (block-first+1)*blockSize <= int64(readBytes); block++ {
+			data := make([]byte, blockSize)
+			copy(data, buffer[(block-first)*blockSize:])
+			df.BlockCache.Put(df.cacheID, block, data)
+		}
+	}
+
+	return b, nil
+}
+
+// readCachedBlocks copies the bytes from the cached blocks, and reports whether all the blocks were cached
+func (df *DataFile) readCachedBlocks(b []byte, offset, first, last, blockSize int64) bool {
+	blocks := make([][]byte, 0, last-first+1)
+	for block := first; block <= last; block++ {
+		data, ok := df.BlockCache.Get(df.cacheID, block)
+		if !ok {
+			return false
+		}
+		blocks = append(blocks, data)
+	}
+
+	numBytes := 0
+	for i, data := range blocks {
+		if i == 0 {
+			data = data[offset-first*blockSize:]
+		}
+		numBytes += copy(b[numBytes:], data)
+	}
+
+	return true
+}
@@ -20,23 +20,33 @@ import (
 )
 
 func TestOpenDataFile(t *testing.T) {
-	dataFile1, err := OpenDataFile(os.TempDir(), 0, fileio.StandardFileIO)
+	directory, _ := os.MkdirTemp("", "betadb-data")
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	dataFile1, err := OpenDataFile(directory, 0, fileio.StandardFileIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
 	// t.Log(os.TempDir())
 
-	dataFile2, err := OpenDataFile(os.TempDir(), 114, fileio.StandardFileIO)
+	dataFile2, err := OpenDataFile(directory, 114, fileio.StandardFileIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile2)
 
-	dataFile3, err := OpenDataFile(os.TempDir(), 114, fileio.StandardFileIO)
+	dataFile3, err := OpenDataFile(directory, 114, fileio.StandardFileIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile3)
 }
 
 func TestDataFile_Write(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 0, fileio.StandardFileIO)
+	directory, _ := os.MkdirTemp("", "betadb-data")
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	dataFile, err := OpenDataFile(directory, 0, fileio.StandardFileIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -51,7 +61,12 @@ func TestDataFile_Write(t *testing.T) {
 }
 
 func TestDataFile_Close(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 115, fileio.StandardFileIO)
+	directory, _ := os.MkdirTemp("", "betadb-data")
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	dataFile, err := OpenDataFile(directory, 115, fileio.StandardFileIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -63,7 +78,12 @@ func TestDataFile_Close(t *testing.T) {
 }
 
 func TestDataFile_Sync(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 116, fileio.StandardFileIO)
+	directory, _ := os.MkdirTemp("", "betadb-data")
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	dataFile, err := OpenDataFile(directory, 116, fileio.StandardFileIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -75,7 +95,12 @@ func TestDataFile_Sync(t *testing.T) {
 }
 
 func TestDataFile_ReadLogRecord(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 1145, fileio.StandardFileIO)
+	directory, _ := os.MkdirTemp("", "betadb-data")
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	dataFile, err := OpenDataFile(directory, 1145, fileio.StandardFileIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -84,11 +109,12 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 		Key:   []byte("engine"),
 		Value: []byte("betadb"),
 	}
-	result1, size1 := EncodeLogRecord(record1)
+	result1, size1, err := dataFile.EncodeLogRecord(record1)
+	assert.Nil(t, err)
 	err = dataFile.Write(result1)
 	assert.Nil(t, err)
 
-	readRecord1, readSize1, err := dataFile.ReadLogRecord(0)
+	readRecord1, readSize1, err := dataFile.ReadLogRecord(DataFileHeaderSize)
 	assert.Nil(t, err)
 	assert.Equal(t, record1, readRecord1)
 	assert.Equal(t, size1, readSize1)
@@ -98,12 +124,13 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 		Key:   []byte("engine"),
 		Value: []byte("betadb new"),
 	}
-	result2, size2 := EncodeLogRecord(record2)
+	result2, size2, err := dataFile.EncodeLogRecord(record2)
+	assert.Nil(t, err)
 	err = dataFile.Write(result2)
 	assert.Nil(t, err)
 
 	// remember to read from the new offset
-	readRecord2, readSize2, err := dataFile.ReadLogRecord(size1)
+	readRecord2, readSize2, err := dataFile.ReadLogRecord(DataFileHeaderSize + size1)
 	assert.Nil(t, err)
 	assert.Equal(t, record2, readRecord2)
 	assert.Equal(t, size2, readSize2)
@@ -114,12 +141,79 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 		Value: []byte(""),
 		Type:  LogRecordDeleted,
 	}
-	result3, size3 := EncodeLogRecord(record3)
+	result3, size3, err := dataFile.EncodeLogRecord(record3)
+	assert.Nil(t, err)
 	err = dataFile.Write(result3)
 	assert.Nil(t, err)
 
-	readRecord3, readSize3, err := dataFile.ReadLogRecord(size1 + size2)
+	readRecord3, readSize3, err := dataFile.ReadLogRecord(DataFileHeaderSize + size1 + size2)
 	assert.Nil(t, err)
 	assert.Equal(t, record3, readRecord3)
 	assert.Equal(t, size3, readSize3)
 }
+
+func TestDataFile_WriteDataHintRecord(t *testing.T) {
+	directory, _ := os.MkdirTemp("", "betadb-hint")
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	hintFile, err := OpenDataHintFile(directory, 7)
+	assert.Nil(t, err)
+	assert.NotNil(t, hintFile)
+
+	pos := &LogRecordPos{Fid: 7, Offset: 114, Size: 514, Expire: 1919}
+	err = hintFile.WriteDataHintRecord(&LogRecord{Key: []byte("name"), Value: []byte("value"), Type: LogRecordDeleted}, pos)
+	assert.Nil(t, err)
+
+	record, _, err := hintFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("name"), record.Key)
+	assert.Equal(t, LogRecordDeleted, record.Type)
+	assert.Equal(t, pos, DecodeLogRecordPos(record.Value))
+
+	err = hintFile.Close()
+	assert.Nil(t, err)
+}
+
+func TestDataFile_IsTornTail(t *testing.T) {
+	directory, _ := os.MkdirTemp("", "betadb-torn")
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	record1, size1 := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("betadb")})
+	record2, size2 := EncodeLogRecord(&LogRecord{Key: []byte("name2"), Value: make([]byte, 100)})
+
+	// a record cut off by the end of the file
+	dataFile, err := OpenDataFile(directory, 1, fileio.StandardFileIO)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Write(record1))
+	assert.Nil(t, dataFile.Write(record2[:size2/2]))
+	torn, err := dataFile.IsTornTail(DataFileHeaderSize + size1)
+	assert.Nil(t, err)
+	assert.True(t, torn)
+
+	// a complete record with a broken CRC, followed by zeros
+	dataFile, err = OpenDataFile(directory, 2, fileio.StandardFileIO)
+	assert.Nil(t, err)
+	corrupted := append([]byte(nil), record2...)
+	corrupted[size2-1] = 1
+	assert.Nil(t, dataFile.Write(record1))
+	assert.Nil(t, dataFile.Write(corrupted))
+	assert.Nil(t, dataFile.Write(make([]byte, 4096)))
+	_, _, err = dataFile.ReadLogRecord(DataFileHeaderSize + size1)
+	assert.Equal(t, ErrInvalidCRC, err)
+	torn, err = dataFile.IsTornTail(DataFileHeaderSize + size1)
+	assert.Nil(t, err)
+	assert.True(t, torn)
+
+	// a complete record with a broken CRC, followed by another record
+	dataFile, err = OpenDataFile(directory, 3, fileio.StandardFileIO)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Write(corrupted))
+	assert.Nil(t, dataFile.Write(record1))
+	torn, err = dataFile.IsTornTail(DataFileHeaderSize)
+	assert.Nil(t, err)
+	assert.False(t, torn)
+}
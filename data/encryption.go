@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	ErrEncryptionKeyNotFound = errors.New("the log record is encrypted with a key that is not configured")
+	ErrDecryptionFailed      = errors.New("failed to decrypt the log record, it might be tampered with")
+)
+
+// "key id" "nonce" "sealed (inner key size, inner key, value)" "tag"
+//
+//	4    +  12   +          variable                       +  16 bytes
+const (
+	keyIDSize    = 4
+	gcmNonceSize = 12
+	sealOverhead = keyIDSize + gcmNonceSize + 16
+)
+
+// keyIDContext separates the hash of the key id from any other use of the key
+const keyIDContext = "betadb encryption key id"
+
+// Keyring encrypts the log records with AES-GCM under its current key,
+// and decrypts them with any of its keys, identified by the key id stored in every encrypted record
+// a null or empty Keyring leaves the records in plaintext
+type Keyring struct {
+	// currentID is the id of the current key
+	currentID uint32
+
+	// current seals the records written, null if they are not encrypted
+	current cipher.AEAD
+
+	// ciphers are the ciphers of every key by their id
+	ciphers map[uint32]cipher.AEAD
+
+	// encryptKeys indicates whether the keys are sealed as well as the values
+	encryptKeys bool
+}
+
+// EncryptionKeyID returns the id of a key stored in the records it encrypts
+// it is derived from the key, so that the keys do not have to be numbered
+func EncryptionKeyID(key []byte) uint32 {
+	hash := sha256.New()
+	hash.Write([]byte(keyIDContext))
+	hash.Write(key)
+	return binary.BigEndian.Uint32(hash.Sum(nil))
+}
+
+// NewKeyring creates a Keyring encrypting with the current key, which may be null to write plaintext,
+// and decrypting with the current and the old keys
+// the keys must be 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256
+func NewKeyring(currentKey []byte, oldKeys [][]byte, encryptKeys bool) (*Keyring, error) {
+	keyring := &Keyring{ciphers: make(map[uint32]cipher.AEAD), encryptKeys: encryptKeys}
+
+	for _, key := range append([][]byte{currentKey}, oldKeys...) {
+		if key == nil {
+			continue
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		keyring.ciphers[EncryptionKeyID(key)] = aead
+	}
+
+	if currentKey != nil {
+		keyring.currentID = EncryptionKeyID(currentKey)
+		keyring.current = keyring.ciphers[keyring.currentID]
+	}
+
+	return keyring, nil
+}
+
+// EncryptsKeys returns whether the keys are sealed as well as the values
+func (k *Keyring) EncryptsKeys() bool {
+	return k != nil && k.current != nil && k.encryptKeys
+}
+
+// seal returns the log record encrypted under the current key, or the log record itself if it is not encrypted
+// only the values of the normal records are sealed unless the keys are, since the others are keys or empty,
+// and an already encrypted record is kept as it is
+func (k *Keyring) seal(logRecord *LogRecord) (*LogRecord, error) {
+	if k == nil || k.current == nil || logRecord.Encrypted || (logRecord.Type != LogRecordNormal && !k.encryptKeys) {
+		return logRecord, nil
+	}
+
+	var key, innerKey = logRecord.Key, []byte(nil)
+	if k.encryptKeys {
+		key, innerKey = nil, logRecord.Key
+	}
+
+	plaintext := make([]byte, binary.MaxVarintLen32+len(innerKey)+len(logRecord.Value))
+	index := binary.PutUvarint(plaintext, uint64(len(innerKey)))
+	index += copy(plaintext[index:], innerKey)
+	index += copy(plaintext[index:], logRecord.Value)
+
+	sealed := make([]byte, keyIDSize+gcmNonceSize, sealOverhead+index)
+	binary.BigEndian.PutUint32(sealed, k.currentID)
+	if _, err := io.ReadFull(rand.Reader, sealed[keyIDSize:]); err != nil {
+		return nil, err
+	}
+	sealed = k.current.Seal(sealed, sealed[keyIDSize:], plaintext[:index],
+		associatedData(key, logRecord.Type, logRecord.Expire))
+
+	return &LogRecord{
+		Key:        key,
+		Value:      sealed,
+		Type:       logRecord.Type,
+		Expire:     logRecord.Expire,
+		Compressed: logRecord.Compressed,
+		Encrypted:  true,
+	}, nil
+}
+
+// open decrypts a log record in place with the key it was sealed by
+func (k *Keyring) open(logRecord *LogRecord) error {
+	if len(logRecord.Value) < sealOverhead {
+		return ErrDecryptionFailed
+	}
+
+	aead, ok := k.ciphers[binary.BigEndian.Uint32(logRecord.Value)]
+	if !ok {
+		return ErrEncryptionKeyNotFound
+	}
+
+	nonce := logRecord.Value[keyIDSize : keyIDSize+gcmNonceSize]
+	plaintext, err := aead.Open(nil, nonce, logRecord.Value[keyIDSize+gcmNonceSize:],
+		associatedData(logRecord.Key, logRecord.Type, logRecord.Expire))
+	if err != nil {
+		return ErrDecryptionFailed
+	}
+
+	innerKeySize, index := binary.Uvarint(plaintext)
+	if index <= 0 || uint64(len(plaintext)-index) < innerKeySize {
+		return ErrDecryptionFailed
+	}
+	if innerKeySize > 0 {
+		logRecord.Key = plaintext[index : index+int(innerKeySize)]
+	}
+	logRecord.Value = plaintext[index+int(innerKeySize):]
+	logRecord.Encrypted = false
+
+	return nil
+}
+
+// associatedData returns the fields of a record authenticated along with its sealed value,
+// so that the value cannot be moved to another key, or given another type or expiration time
+// the transaction sequence number that starts every key in a data file is left out,
+// since it is cleared when a committed record is rewritten by a merge, a compaction or a repair
+func associatedData(key []byte, recordType LogRecordType, expire int64) []byte {
+	if _, n := binary.Uvarint(key); n > 0 {
+		key = key[n:]
+	}
+
+	ad := make([]byte, 1+binary.MaxVarintLen64+len(key))
+	ad[0] = recordType
+	index := 1 + binary.PutVarint(ad[1:], expire)
+	index += copy(ad[index:], key)
+
+	return ad[:index]
+}
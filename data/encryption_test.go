@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"bytes"
+	"github.com/LiuShuoJiang/betadb/fileio"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestNewKeyring(t *testing.T) {
+	for _, size := range []int{16, 24, 32} {
+		keyring, err := NewKeyring(bytes.Repeat([]byte{1}, size), nil, false)
+		assert.Nil(t, err)
+		assert.NotNil(t, keyring)
+	}
+
+	_, err := NewKeyring([]byte("short"), nil, false)
+	assert.NotNil(t, err)
+	_, err = NewKeyring(nil, [][]byte{[]byte("short")}, false)
+	assert.NotNil(t, err)
+
+	assert.NotEqual(t, EncryptionKeyID(bytes.Repeat([]byte{1}, 32)), EncryptionKeyID(bytes.Repeat([]byte{2}, 32)))
+}
+
+func TestKeyring_Seal(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+	oldKeyring, err := NewKeyring(oldKey, nil, false)
+	assert.Nil(t, err)
+	newKeyring, err := NewKeyring(newKey, [][]byte{oldKey}, true)
+	assert.Nil(t, err)
+
+	record := &LogRecord{Key: []byte("name"), Value: []byte("betadb"), Expire: 42, Compressed: true}
+	sealed, err := oldKeyring.seal(record)
+	assert.Nil(t, err)
+	assert.True(t, sealed.Encrypted)
+	assert.Equal(t, record.Key, sealed.Key)
+	assert.False(t, bytes.Contains(sealed.Value, record.Value))
+	assert.Equal(t, len(record.Value)+sealOverhead+1, len(sealed.Value))
+
+	// the nonce is random, so the same record is never sealed the same way
+	sealedAgain, err := oldKeyring.seal(record)
+	assert.Nil(t, err)
+	assert.NotEqual(t, sealed.Value, sealedAgain.Value)
+
+	// the record is decrypted with the key it was sealed by, even if it is no longer the current one
+	opened := *sealed
+	assert.Nil(t, newKeyring.open(&opened))
+	assert.Equal(t, *record, opened)
+
+	// the keys are sealed with the values, and only the values of normal records are sealed otherwise
+	sealed, err = newKeyring.seal(record)
+	assert.Nil(t, err)
+	assert.Empty(t, sealed.Key)
+	opened = *sealed
+	assert.Nil(t, newKeyring.open(&opened))
+	assert.Equal(t, *record, opened)
+
+	tombstone := &LogRecord{Key: []byte("name"), Type: LogRecordDeleted}
+	sealed, err = oldKeyring.seal(tombstone)
+	assert.Nil(t, err)
+	assert.Equal(t, tombstone, sealed)
+	sealed, err = newKeyring.seal(tombstone)
+	assert.Nil(t, err)
+	assert.True(t, sealed.Encrypted)
+
+	// a record sealed by a key that is not configured
+	otherKeyring, err := NewKeyring(nil, nil, false)
+	assert.Nil(t, err)
+	opened = *sealed
+	assert.Equal(t, ErrEncryptionKeyNotFound, otherKeyring.open(&opened))
+
+	// a record tampered with
+	sealed, err = newKeyring.seal(record)
+	assert.Nil(t, err)
+	sealed.Value[len(sealed.Value)-1]++
+	assert.Equal(t, ErrDecryptionFailed, newKeyring.open(sealed))
+}
+
+func TestKeyring_SealAssociatedData(t *testing.T) {
+	keyring, err := NewKeyring(bytes.Repeat([]byte{1}, 32), nil, false)
+	assert.Nil(t, err)
+
+	// the keys in the data files start with the transaction sequence number
+	record := &LogRecord{Key: append([]byte{0}, "name"...), Value: []byte("betadb"), Expire: 42}
+	sealed, err := keyring.seal(record)
+	assert.Nil(t, err)
+
+	// the value moved to another key
+	moved := *sealed
+	moved.Key = append([]byte{0}, "other"...)
+	assert.Equal(t, ErrDecryptionFailed, keyring.open(&moved))
+
+	// the expiration time or the type changed
+	moved = *sealed
+	moved.Expire = 0
+	assert.Equal(t, ErrDecryptionFailed, keyring.open(&moved))
+	moved = *sealed
+	moved.Type = LogRecordDeleted
+	assert.Equal(t, ErrDecryptionFailed, keyring.open(&moved))
+
+	// the sequence number is cleared when a committed record is rewritten
+	rewritten := *sealed
+	rewritten.Key = append([]byte{7}, "name"...)
+	assert.Nil(t, keyring.open(&rewritten))
+	assert.Equal(t, []byte("betadb"), rewritten.Value)
+}
+
+func TestDataFile_Encryption(t *testing.T) {
+	directory, _ := os.MkdirTemp("", "betadb-encryption")
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	keyring, err := NewKeyring(bytes.Repeat([]byte{1}, 32), nil, true)
+	assert.Nil(t, err)
+
+	dataFile, err := OpenDataFile(directory, 0, fileio.StandardFileIO)
+	assert.Nil(t, err)
+	dataFile.Keyring = keyring
+
+	record := &LogRecord{Key: []byte("name"), Value: []byte("betadb")}
+	encRecord, size, err := dataFile.EncodeLogRecord(record)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Write(encRecord))
+	assert.False(t, bytes.Contains(encRecord, record.Key))
+	assert.False(t, bytes.Contains(encRecord, record.Value))
+
+	readRecord, readSize, err := dataFile.ReadLogRecord(DataFileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, size, readSize)
+	assert.Equal(t, record, readRecord)
+
+	// a data file without a keyring reads the records as stored
+	dataFile.Keyring = nil
+	readRecord, _, err = dataFile.ReadLogRecord(DataFileHeaderSize)
+	assert.Nil(t, err)
+	assert.True(t, readRecord.Encrypted)
+	assert.Empty(t, readRecord.Key)
+	assert.Nil(t, dataFile.Close())
+
+	// the hint records are sealed along with the keys
+	hintFile, err := OpenHintFile(directory)
+	assert.Nil(t, err)
+	hintFile.Keyring = keyring
+	pos := &LogRecordPos{Fid: 0, Offset: DataFileHeaderSize, Size: uint32(size)}
+	assert.Nil(t, hintFile.WriteHintRecord(record.Key, pos))
+
+	hintRecord, _, err := hintFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, record.Key, hintRecord.Key)
+	assert.Equal(t, pos, DecodeLogRecordPos(hintRecord.Value))
+	assert.Nil(t, hintFile.Close())
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/LiuShuoJiang/betadb/fileio"
+	"hash/crc32"
+	"os"
+	"time"
+)
+
+var (
+	ErrDataFileHeaderMissing      = errors.New("data file has no header, it must be upgraded from the earlier format")
+	ErrInvalidDataFileHeader      = errors.New("invalid data file header, data file might be corrupted")
+	ErrUnsupportedDataFileVersion = errors.New("data file is written in a newer format that is not supported")
+)
+
+// "magic" "version" "flags" "fileID" "createdAt" "reserved" "crc"
+//
+//	4   +    2    +   2   +   4    +    8     +    8     +  4   bytes
+const DataFileHeaderSize = 32
+
+const (
+	// dataFileMagic identifies a data file, it is "BTDB" in little endian
+	dataFileMagic uint32 = 0x42445442
+
+	// DataFileVersion is the format version of the data files written
+	DataFileVersion uint16 = 1
+)
+
+// the format flags of a data file, each of them changes how its records are encoded
+const (
+	// DataFileFlagCRC32C indicates that the records are checksummed with CRC32C instead of IEEE CRC32
+	DataFileFlagCRC32C uint16 = 1 << 0
+
+	// knownDataFileFlags are the flags understood by this version, a data file with any other one cannot be read
+	knownDataFileFlags = DataFileFlagCRC32C
+
+	// DefaultDataFileFlags are the flags of the data files created
+	DefaultDataFileFlags = DataFileFlagCRC32C
+)
+
+// DataFileHeader is the header at the beginning of every data file, the records follow it
+type DataFileHeader struct {
+	// Version is the format version of the data file
+	Version uint16
+
+	// Flags are the format flags of the data file
+	Flags uint16
+
+	// FileID is the id of the data file when it was created
+	FileID uint32
+
+	// CreatedAt is the unix timestamp in nanoseconds when the data file was created
+	CreatedAt int64
+}
+
+// EncodeDataFileHeader encodes the header of a data file, ending with the CRC checksum of the fields before it
+func EncodeDataFileHeader(header *DataFileHeader) []byte {
+	buffer := make([]byte, DataFileHeaderSize)
+	binary.LittleEndian.PutUint32(buffer[0:4], dataFileMagic)
+	binary.LittleEndian.PutUint16(buffer[4:6], header.Version)
+	binary.LittleEndian.PutUint16(buffer[6:8], header.Flags)
+	binary.LittleEndian.PutUint32(buffer[8:12], header.FileID)
+	binary.LittleEndian.PutUint64(buffer[12:20], uint64(header.CreatedAt))
+	binary.LittleEndian.PutUint32(buffer[28:32], crc32.ChecksumIEEE(buffer[:28]))
+
+	return buffer
+}
+
+// DecodeDataFileHeader decodes and validates the header of a data file
+func DecodeDataFileHeader(buffer []byte) (*DataFileHeader, error) {
+	if len(buffer) < 4 || binary.LittleEndian.Uint32(buffer[0:4]) != dataFileMagic {
+		return nil, ErrDataFileHeaderMissing
+	}
+	if len(buffer) < DataFileHeaderSize ||
+		binary.LittleEndian.Uint32(buffer[28:32]) != crc32.ChecksumIEEE(buffer[:28]) {
+		return nil, ErrInvalidDataFileHeader
+	}
+
+	header := &DataFileHeader{
+		Version:   binary.LittleEndian.Uint16(buffer[4:6]),
+		Flags:     binary.LittleEndian.Uint16(buffer[6:8]),
+		FileID:    binary.LittleEndian.Uint32(buffer[8:12]),
+		CreatedAt: int64(binary.LittleEndian.Uint64(buffer[12:20])),
+	}
+	if header.Version > DataFileVersion || header.Flags&^knownDataFileFlags != 0 {
+		return nil, ErrUnsupportedDataFileVersion
+	}
+
+	return header, nil
+}
+
+// NewDataFileHeader returns the header of a data file created now in the current format with the given flags
+func NewDataFileHeader(fileID uint32, flags uint16) *DataFileHeader {
+	return &DataFileHeader{
+		Version:   DataFileVersion,
+		Flags:     flags,
+		FileID:    fileID,
+		CreatedAt: time.Now().UnixNano(),
+	}
+}
+
+// initDataFileHeader writes the header of a data file that has just been created
+// the header is synced, so that a data file is never left with a partial one
+func initDataFileHeader(fileName string, fileID uint32) error {
+	fileInfo, err := os.Stat(fileName)
+	if err == nil && fileInfo.Size() > 0 {
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileio.DataFilePermission)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(EncodeDataFileHeader(NewDataFileHeader(fileID, DefaultDataFileFlags))); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Checksum returns the checksum algorithm of the records following the header
+func (header *DataFileHeader) Checksum() ChecksumType {
+	if header.Flags&DataFileFlagCRC32C != 0 {
+		return ChecksumCRC32C
+	}
+	return ChecksumIEEE
+}
+
+// ReadHeader reads and validates the header at the beginning of the data file
+func (df *DataFile) ReadHeader() (*DataFileHeader, error) {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	buffer, err := df.readNBytes(min(fileSize, DataFileHeaderSize), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeDataFileHeader(buffer)
+}
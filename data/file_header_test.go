@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data
+
+import (
+	"github.com/LiuShuoJiang/betadb/fileio"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDecodeDataFileHeader(t *testing.T) {
+	header := NewDataFileHeader(42, DefaultDataFileFlags)
+	buffer := EncodeDataFileHeader(header)
+	assert.Equal(t, DataFileHeaderSize, len(buffer))
+
+	decoded, err := DecodeDataFileHeader(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, header, decoded)
+
+	// the records of the earlier format start at the beginning of the file
+	record, _ := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("betadb")})
+	_, err = DecodeDataFileHeader(record)
+	assert.Equal(t, ErrDataFileHeaderMissing, err)
+
+	corrupted := append([]byte(nil), buffer...)
+	corrupted[10]++
+	_, err = DecodeDataFileHeader(corrupted)
+	assert.Equal(t, ErrInvalidDataFileHeader, err)
+
+	_, err = DecodeDataFileHeader(buffer[:DataFileHeaderSize/2])
+	assert.Equal(t, ErrInvalidDataFileHeader, err)
+
+	header.Version = DataFileVersion + 1
+	_, err = DecodeDataFileHeader(EncodeDataFileHeader(header))
+	assert.Equal(t, ErrUnsupportedDataFileVersion, err)
+
+	// an unknown format flag changes the records in a way that cannot be read
+	header = NewDataFileHeader(42, 1<<15)
+	_, err = DecodeDataFileHeader(EncodeDataFileHeader(header))
+	assert.Equal(t, ErrUnsupportedDataFileVersion, err)
+}
+
+func TestOpenDataFile_Header(t *testing.T) {
+	directory, _ := os.MkdirTemp("", "betadb-header")
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	dataFile, err := OpenDataFile(directory, 3, fileio.StandardFileIO)
+	assert.Nil(t, err)
+	assert.Equal(t, DataFileVersion, dataFile.Header.Version)
+	assert.Equal(t, uint32(3), dataFile.Header.FileID)
+	assert.Equal(t, int64(DataFileHeaderSize), dataFile.WriteOffset)
+	assert.Nil(t, dataFile.Close())
+
+	// the header of an existing file is kept
+	reopened, err := OpenDataFile(directory, 3, fileio.MemoryMap)
+	assert.Nil(t, err)
+	assert.Equal(t, dataFile.Header, reopened.Header)
+	assert.Nil(t, reopened.Close())
+
+	// a data file renamed from another one is rejected
+	assert.Nil(t, os.Rename(GetDataFileName(directory, 3), GetDataFileName(directory, 4)))
+	_, err = OpenDataFile(directory, 4, fileio.StandardFileIO)
+	assert.Equal(t, ErrInvalidDataFileHeader, err)
+
+	legacyFile, err := OpenLegacyDataFile(directory, 5)
+	assert.Nil(t, err)
+	assert.Nil(t, legacyFile.Header)
+	record, _ := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("betadb")})
+	assert.Nil(t, legacyFile.Write(record))
+	assert.Nil(t, legacyFile.Close())
+	_, err = OpenDataFile(directory, 5, fileio.StandardFileIO)
+	assert.Equal(t, ErrDataFileHeaderMissing, err)
+}
+
+func TestOpenDataFile_Checksum(t *testing.T) {
+	directory, _ := os.MkdirTemp("", "betadb-header")
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	record := &LogRecord{Key: []byte("name"), Value: []byte("betadb")}
+
+	// the data files created use CRC32C
+	dataFile, err := OpenDataFile(directory, 1, fileio.StandardFileIO)
+	assert.Nil(t, err)
+	assert.Equal(t, ChecksumCRC32C, dataFile.Checksum)
+	encRecord, _, err := dataFile.EncodeLogRecord(record)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Write(encRecord))
+	readRecord, _, err := dataFile.ReadLogRecord(DataFileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, record, readRecord)
+	assert.Nil(t, dataFile.Close())
+
+	// a data file without the flag keeps using IEEE CRC32
+	ieeeFile, err := OpenLegacyDataFile(directory, 2)
+	assert.Nil(t, err)
+	assert.Nil(t, ieeeFile.Write(EncodeDataFileHeader(NewDataFileHeader(2, 0))))
+	encRecord, _ = EncodeLogRecord(record)
+	assert.Nil(t, ieeeFile.Write(encRecord))
+	encRecord, _ = EncodeLogRecordWithChecksum(record, ChecksumCRC32C)
+	assert.Nil(t, ieeeFile.Write(encRecord))
+	assert.Nil(t, ieeeFile.Close())
+
+	ieeeFile, err = OpenDataFile(directory, 2, fileio.StandardFileIO)
+	assert.Nil(t, err)
+	assert.Equal(t, ChecksumIEEE, ieeeFile.Checksum)
+	readRecord, size, err := ieeeFile.ReadLogRecord(DataFileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, record, readRecord)
+	_, _, err = ieeeFile.ReadLogRecord(DataFileHeaderSize + size)
+	assert.Equal(t, ErrInvalidCRC, err)
+	assert.Nil(t, ieeeFile.Close())
+}
@@ -23,12 +23,51 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordTxnFinished
+	// LogRecordRangeDeleted is a range tombstone deleting every key within [Key, Value)
+	// an empty Value means that the range has no upper bound
+	LogRecordRangeDeleted
 )
 
-// "crc" "type" "keySize" "valueSize"
+// the type byte stores the LogRecordType in its low bits
+// and the per-record flags in its high bits
+const (
+	logRecordTypeMask byte = 0x0f
+
+	// logRecordExpireFlag indicates that an expire timestamp follows the value size in the header
+	logRecordExpireFlag byte = 1 << 7
+
+	// logRecordCompressedFlag indicates that the value is compressed
+	logRecordCompressedFlag byte = 1 << 6
+
+	// logRecordEncryptedFlag indicates that the value, and maybe the key, are sealed by a Keyring
+	logRecordEncryptedFlag byte = 1 << 5
+)
+
+// ChecksumType is the checksum algorithm of the records in a file
+type ChecksumType = byte
+
+const (
+	// ChecksumIEEE is the CRC32 checksum with the IEEE polynomial, used by the files without a format flag
+	ChecksumIEEE ChecksumType = iota
+
+	// ChecksumCRC32C is the CRC32 checksum with the Castagnoli polynomial, which is hardware-accelerated on most CPUs
+	ChecksumCRC32C
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumTable returns the CRC32 table of the checksum algorithm
+func checksumTable(checksum ChecksumType) *crc32.Table {
+	if checksum == ChecksumCRC32C {
+		return castagnoliTable
+	}
+	return crc32.IEEETable
+}
+
+// "crc" "type" "keySize" "valueSize" "expire"
 //
-//	4  +  1   + (max)5  +  (max)5   bytes
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+//	4  +  1   + (max)5  +  (max)5   + (max)10 bytes
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + binary.MaxVarintLen64 + 5
 
 // LogRecord is a record written to a data file consisting Key, Value and Type
 // It's called a log because the data in the data file is written in an append format, similar to a log
@@ -36,8 +75,17 @@ type LogRecord struct {
 	Key   []byte
 	Value []byte
 	// Type indicates the type of the log record
-	// it may be a normal record, a deleted record (tombstone value), or a transaction finished record
+	// it may be a normal record, a deleted record (tombstone value), a range tombstone,
+	// or a transaction finished record
 	Type LogRecordType
+	// Expire is the unix timestamp in nanoseconds after which the record is treated as missing
+	// zero means that the record never expires
+	Expire int64
+	// Compressed indicates that the Value is compressed, prefixed with the id of its compressor
+	Compressed bool
+	// Encrypted indicates that the Value is sealed by a Keyring, which is only the case
+	// when the record is read from a file without a Keyring, the Key is empty if it is sealed as well
+	Encrypted bool
 }
 
 // logRecordHeader defines the header information before LogRecord
@@ -50,6 +98,12 @@ type logRecordHeader struct {
 	keySize uint32
 	// valueSize is the length of value
 	valueSize uint32
+	// expire is the Expire field of LogRecord
+	expire int64
+	// compressed is the Compressed field of LogRecord
+	compressed bool
+	// encrypted is the Encrypted field of LogRecord
+	encrypted bool
 }
 
 // LogRecordPos defines the data index information consisting Fid, Offset and Size
@@ -61,6 +115,8 @@ type LogRecordPos struct {
 	Offset int64
 	// Size indicates the size of the file on disk
 	Size uint32
+	// Expire is the expire timestamp of the record, zero means that the record never expires
+	Expire int64
 }
 
 // TransactionRecord temporarily stores transaction-related data
@@ -72,17 +128,31 @@ type TransactionRecord struct {
 // EncodeLogRecord encodes the LogRecord (easier for file writing)
 // and returns the byte array and length
 //
-// +--------------------+----------------+-----------------------+-----------------------+------------+--------------+
-// | crc checksum value | type of record |       key size        |      value size       | actual key | actual value |
-// +--------------------+----------------+-----------------------+-----------------------+------------+--------------+
+// +--------------------+----------------+-----------------------+-----------------------+------------------------+------------+--------------+
+// | crc checksum value | type of record |       key size        |      value size       | expire (only if set)   | actual key | actual value |
+// +--------------------+----------------+-----------------------+-----------------------+------------------------+------------+--------------+
 //
-//	4 bytes            1 byte        variable(max 5 bytes)   variable(max 5 bytes)    variable      variable
+//	4 bytes            1 byte        variable(max 5 bytes)   variable(max 5 bytes)   variable(max 10 bytes)    variable      variable
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	return EncodeLogRecordWithChecksum(logRecord, ChecksumIEEE)
+}
+
+// EncodeLogRecordWithChecksum encodes a LogRecord like EncodeLogRecord, with the given checksum algorithm
+func EncodeLogRecordWithChecksum(logRecord *LogRecord, checksum ChecksumType) ([]byte, int64) {
 	// initialize a byte array representing the header part
 	header := make([]byte, maxLogRecordHeaderSize)
 
 	// the 5th byte stores type info
 	header[4] = logRecord.Type
+	if logRecord.Expire != 0 {
+		header[4] |= logRecordExpireFlag
+	}
+	if logRecord.Compressed {
+		header[4] |= logRecordCompressedFlag
+	}
+	if logRecord.Encrypted {
+		header[4] |= logRecordEncryptedFlag
+	}
 	var index = 5
 
 	// we store the length of key and value after the 5th byte
@@ -90,6 +160,11 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
 
+	// the expire timestamp is only stored when the record has one
+	if logRecord.Expire != 0 {
+		index += binary.PutVarint(header[index:], logRecord.Expire)
+	}
+
 	var size = index + len(logRecord.Key) + len(logRecord.Value)
 	encodeBytes := make([]byte, size)
 
@@ -101,7 +176,7 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	copy(encodeBytes[index+len(logRecord.Key):], logRecord.Value)
 
 	// finally, perform crc checksums on the entire LogRecord
-	crc := crc32.ChecksumIEEE(encodeBytes[4:])
+	crc := crc32.Checksum(encodeBytes[4:], checksumTable(checksum))
 	binary.LittleEndian.PutUint32(encodeBytes[:4], crc)
 
 	// fmt.Printf("header length: %d, crc: %d\n", index, crc)
@@ -111,13 +186,18 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 
 // EncodeLogRecordPos encodes the LogRecordPos position information
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buffer := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	buffer := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
 	var index = 0
 
 	index += binary.PutVarint(buffer[index:], int64(pos.Fid))
 	index += binary.PutVarint(buffer[index:], pos.Offset)
 	index += binary.PutVarint(buffer[index:], int64(pos.Size))
 
+	// positions written before expiry was supported end here, so only append it when set
+	if pos.Expire != 0 {
+		index += binary.PutVarint(buffer[index:], pos.Expire)
+	}
+
 	return buffer[:index]
 }
 
@@ -131,12 +211,19 @@ func DecodeLogRecordPos(buffer []byte) *LogRecordPos {
 	offset, numBytes := binary.Varint(buffer[index:])
 	index += numBytes
 
-	size, _ := binary.Varint(buffer[index:])
+	size, numBytes := binary.Varint(buffer[index:])
+	index += numBytes
+
+	var expire int64
+	if index < len(buffer) {
+		expire, _ = binary.Varint(buffer[index:])
+	}
 
 	return &LogRecordPos{
 		Fid:    uint32(fileID),
 		Offset: offset,
 		Size:   uint32(size),
+		Expire: expire,
 	}
 }
 
@@ -149,33 +236,53 @@ func decodeLogRecordHeader(buffer []byte) (*logRecordHeader, int64) {
 
 	header := &logRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buffer[:4]),
-		recordType: buffer[4],
+		recordType: buffer[4] & logRecordTypeMask,
+		compressed: buffer[4]&logRecordCompressedFlag != 0,
+		encrypted:  buffer[4]&logRecordEncryptedFlag != 0,
 	}
 
 	var index = 5 // not start from the 6-th byte
 
 	// get the key size
+	// a size that cannot be decoded means that the header is cut off or corrupted
 	keySize, n := binary.Varint(buffer[index:])
+	if n <= 0 {
+		return nil, 0
+	}
 	header.keySize = uint32(keySize)
 	index += n
 
 	valueSize, n := binary.Varint(buffer[index:])
+	if n <= 0 {
+		return nil, 0
+	}
 	header.valueSize = uint32(valueSize)
 	index += n
 
+	// get the expire timestamp if the record carries one
+	if buffer[4]&logRecordExpireFlag != 0 {
+		expire, n := binary.Varint(buffer[index:])
+		if n <= 0 {
+			return nil, 0
+		}
+		header.expire = expire
+		index += n
+	}
+
 	return header, int64(index)
 }
 
-// getLogRecordCRC returns the CRC code from LogRecord
-func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
+// getLogRecordCRC returns the CRC code from LogRecord with the given checksum algorithm
+func getLogRecordCRC(lr *LogRecord, header []byte, checksum ChecksumType) uint32 {
 	if lr == nil {
 		return 0
 	}
 
-	crc := crc32.ChecksumIEEE(header[:])
+	table := checksumTable(checksum)
+	crc := crc32.Checksum(header[:], table)
 
-	crc = crc32.Update(crc, crc32.IEEETable, lr.Key)
-	crc = crc32.Update(crc, crc32.IEEETable, lr.Value)
+	crc = crc32.Update(crc, table, lr.Key)
+	crc = crc32.Update(crc, table, lr.Value)
 
 	return crc
 }
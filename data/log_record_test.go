@@ -53,6 +53,46 @@ func TestEncodeLogRecord(t *testing.T) {
 	// t.Log(len3)
 	assert.NotNil(t, result3)
 	assert.Greater(t, len3, int64(5))
+
+	// test when the record has an expire timestamp
+	record4 := &LogRecord{
+		Key:    []byte("engine"),
+		Value:  []byte("betadb"),
+		Type:   LogRecordNormal,
+		Expire: 1719792000000000000,
+	}
+	result4, len4 := EncodeLogRecord(record4)
+	assert.NotNil(t, result4)
+	assert.Greater(t, len4, len1)
+
+	header4, size4 := decodeLogRecordHeader(result4)
+	assert.Equal(t, len4-size4, int64(12))
+	assert.Equal(t, LogRecordNormal, header4.recordType)
+	assert.Equal(t, record4.Expire, header4.expire)
+	assert.Equal(t, header4.crc, getLogRecordCRC(record4, result4[crc32.Size:size4], ChecksumIEEE))
+
+	// test when the value is compressed
+	record5 := &LogRecord{
+		Key:        []byte("engine"),
+		Value:      []byte("compressed"),
+		Type:       LogRecordNormal,
+		Compressed: true,
+	}
+	result5, _ := EncodeLogRecord(record5)
+	header5, _ := decodeLogRecordHeader(result5)
+	assert.Equal(t, LogRecordNormal, header5.recordType)
+	assert.True(t, header5.compressed)
+	assert.False(t, header4.compressed)
+}
+
+func TestLogRecordPos_Encode(t *testing.T) {
+	// test for the position without expire timestamp
+	pos1 := &LogRecordPos{Fid: 1, Offset: 114, Size: 514}
+	assert.Equal(t, pos1, DecodeLogRecordPos(EncodeLogRecordPos(pos1)))
+
+	// test for the position with expire timestamp
+	pos2 := &LogRecordPos{Fid: 1, Offset: 114, Size: 514, Expire: 1719792000000000000}
+	assert.Equal(t, pos2, DecodeLogRecordPos(EncodeLogRecordPos(pos2)))
 }
 
 func TestDecodeLogRecordHeader(t *testing.T) {
@@ -95,7 +135,7 @@ func TestGetLogRecordCRC(t *testing.T) {
 		Type:  LogRecordNormal,
 	}
 	headerBuffer1 := []byte{77, 26, 80, 17, 0, 12, 12}
-	crc1 := getLogRecordCRC(record1, headerBuffer1[crc32.Size:])
+	crc1 := getLogRecordCRC(record1, headerBuffer1[crc32.Size:], ChecksumIEEE)
 	assert.Equal(t, uint32(290462285), crc1)
 
 	// test when the value is empty
@@ -104,7 +144,7 @@ func TestGetLogRecordCRC(t *testing.T) {
 		Type: LogRecordNormal,
 	}
 	headerBuffer2 := []byte{207, 186, 204, 232, 0, 12, 0}
-	crc2 := getLogRecordCRC(record2, headerBuffer2[crc32.Size:])
+	crc2 := getLogRecordCRC(record2, headerBuffer2[crc32.Size:], ChecksumIEEE)
 	assert.Equal(t, uint32(3905731279), crc2)
 
 	// test when the type is deleted
@@ -114,6 +154,6 @@ func TestGetLogRecordCRC(t *testing.T) {
 		Type:  LogRecordDeleted,
 	}
 	headerBuffer3 := []byte{165, 193, 171, 168, 1, 12, 12}
-	crc3 := getLogRecordCRC(record3, headerBuffer3[crc32.Size:])
+	crc3 := getLogRecordCRC(record3, headerBuffer3[crc32.Size:], ChecksumIEEE)
 	assert.Equal(t, uint32(2829828517), crc3)
 }
@@ -13,6 +13,8 @@
 package betadb
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/LiuShuoJiang/betadb/data"
@@ -23,13 +25,14 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
+	// seqNoKey is the key of the seq-no file written by the earlier versions
 	seqNoKey     = "seq.no"
 	fileLockName = "fLock"
 )
@@ -46,6 +49,10 @@ type Database struct {
 	// they cannot be updated or used elsewhere
 	fileIDs []int
 
+	// hintFileIDs are the ids of the data files that have their own hint files
+	// they can only be used when loading the indices first as well
+	hintFileIDs map[uint32]struct{}
+
 	// activeFile is the current active file that can be written
 	activeFile *data.DataFile
 
@@ -61,11 +68,8 @@ type Database struct {
 	// isMerging tells whether we are executing the merging process or not
 	isMerging bool
 
-	// seqNoFilesExists indicates whether the file storing the transaction sequence number exists
-	seqNoFilesExists bool
-
-	// isInitial indicates whether this is the first time to initialize this data directory
-	isInitial bool
+	// manifest records the live data files, the latest transaction sequence number and the completed merges
+	manifest *manifest
 
 	// fileLock is a file lock that ensures mutual exclusion between multiple processes
 	// refer to [https://github.com/gofrs/flock]
@@ -76,6 +80,57 @@ type Database struct {
 
 	// reclaimSize indicates how many bytes of data are invalid
 	reclaimSize int64
+
+	// fileReclaimSize indicates how many bytes of data are invalid in each data file
+	fileReclaimSize map[uint32]int64
+
+	// keyring encrypts and decrypts the records of the data and hint files
+	keyring *data.Keyring
+
+	// blockCache caches the blocks of the data files read with direct IO, null for the other IO types
+	blockCache *fileio.BlockCache
+
+	// fileCache closes the data files that are not used beyond the maximum number of open files, null if unlimited
+	fileCache *fileio.FileCache
+
+	// valueCache caches the values read from the data files, null if disabled
+	valueCache *valueCache
+
+	// rawValueSize and storedValueSize are the sizes of the values written since open, before and after compression
+	rawValueSize    int64
+	storedValueSize int64
+
+	// activeTxns are the read-write transactions that have not been committed or discarded yet
+	activeTxns map[*Txn]struct{}
+
+	// writeVersion counts the writes, each write of a key gets the next version
+	writeVersion uint64
+
+	// writeLog holds the keys written since the oldest active transaction began, in the order of their versions
+	// it is checked by the transactions at commit, and holds at most txnWriteLogSize writes
+	writeLog []txnWrite
+
+	// writeLogFloor is the version of the latest write dropped from a full write log
+	writeLogFloor uint64
+
+	// pinnedFiles counts the snapshots and iterators that still reference each data file
+	pinnedFiles map[*data.DataFile]int
+
+	// retiredFiles are the data files replaced by a compaction that are still pinned
+	retiredFiles map[*data.DataFile]struct{}
+
+	// writeDataHints indicates whether a hint file is written for every data file when it becomes immutable
+	writeDataHints bool
+
+	// activeHintFile is the hint file of the active file, written along with it under a temporary name
+	// and renamed into place at rotation, null if it is not written
+	activeHintFile *data.DataFile
+
+	// mergeWorkerCancel stops the background merge worker and its running merge, null if the worker is disabled
+	mergeWorkerCancel context.CancelFunc
+
+	// mergeWorkerDone is closed when the background merge worker has exited
+	mergeWorkerDone chan struct{}
 }
 
 // Stat stores engine statistics
@@ -88,6 +143,14 @@ type Stat struct {
 	ReclaimableSize int64
 	// DiskSize is the size of the data directory on disk
 	DiskSize int64
+	// FileReclaimableSize is the number of bytes of data that can be merged in each data file
+	FileReclaimableSize map[uint32]int64
+	// CompressionRatio is the original size of the values written since open divided by their stored size
+	CompressionRatio float64
+	// ValueCacheHits is the number of values read from the value cache since open
+	ValueCacheHits uint64
+	// ValueCacheMisses is the number of values not found in the value cache since open
+	ValueCacheMisses uint64
 }
 
 // Open opens a BetaDB storage engine instance
@@ -97,12 +160,15 @@ func Open(options Options) (*Database, error) {
 		return nil, err
 	}
 
-	var isInitial bool
+	// the keyring also validates the encryption keys
+	keyring, err := data.NewKeyring(options.EncryptionKey, options.OldEncryptionKeys, options.EncryptKeys)
+	if err != nil {
+		return nil, err
+	}
 
 	// determine whether the data directory exists
 	// if not, create the directory
 	if _, err := os.Stat(options.DirectoryPath); os.IsNotExist(err) {
-		isInitial = true
 		if err := os.MkdirAll(options.DirectoryPath, os.ModePerm); err != nil {
 			return nil, err
 		}
@@ -118,75 +184,160 @@ func Open(options Options) (*Database, error) {
 		return nil, ErrDatabaseIsUsing
 	}
 
-	// check if the directory entry is empty
-	entries, err := os.ReadDir(options.DirectoryPath)
-	if err != nil {
+	// initialize Database instance struct
+	db := &Database{
+		options:         options,
+		mu:              new(sync.RWMutex),
+		olderFiles:      make(map[uint32]*data.DataFile),
+		fileLock:        fileLock,
+		activeTxns:      make(map[*Txn]struct{}),
+		pinnedFiles:     make(map[*data.DataFile]int),
+		retiredFiles:    make(map[*data.DataFile]struct{}),
+		fileReclaimSize: make(map[uint32]int64),
+		keyring:         keyring,
+		// B+ tree indices are persisted, so they never load the data files
+		writeDataHints: options.IndexType != BPlusTree,
+	}
+	if options.IOType == fileio.DirectIO && options.BlockCacheSize > 0 {
+		db.blockCache = fileio.NewBlockCache(options.BlockCacheSize)
+	}
+	if options.MaxOpenFiles > 0 {
+		db.fileCache = fileio.NewFileCache(options.MaxOpenFiles)
+	}
+	if options.ValueCacheSize > 0 {
+		db.valueCache = newValueCache(options.ValueCacheSize)
+	}
+
+	// remove the leftover of an interrupted compaction
+	if err := os.RemoveAll(db.getCompactPath()); err != nil {
+		_ = fileLock.Unlock()
 		return nil, err
 	}
-	if len(entries) == 0 {
-		isInitial = true
+
+	// the manifest is created from the existing files if the data directory has none
+	if db.manifest, err = openManifest(options.DirectoryPath); err != nil {
+		_ = fileLock.Unlock()
+		return nil, err
+	}
+	if db.manifest == nil {
+		if err := db.migrateManifest(); err != nil {
+			if db.manifest != nil {
+				_ = db.manifest.close()
+			}
+			_ = fileLock.Unlock()
+			return nil, err
+		}
+	}
+	// the index is created once the index type is known to match the data directory
+	if err := db.checkIndexType(); err != nil {
+		_ = db.manifest.close()
+		_ = fileLock.Unlock()
+		return nil, err
 	}
+	db.index = index.NewIndexer(options.IndexType, options.DirectoryPath, options.SyncWrites)
 
-	// initialize Database instance struct
-	db := &Database{
-		options:    options,
-		mu:         new(sync.RWMutex),
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType, options.DirectoryPath, options.SyncWrites),
-		isInitial:  isInitial,
-		fileLock:   fileLock,
+	// the directory is released if it cannot be opened, e.g. without the key of its encrypted records
+	if err := db.openFiles(); err != nil {
+		db.closeDataFiles()
+		_ = db.index.Close()
+		_ = db.manifest.close()
+		_ = fileLock.Unlock()
+		return nil, err
+	}
+
+	// start merging in background if configured
+	db.startMergeWorker()
+
+	return db, nil
+}
+
+// openFiles loads the data files and prepares them and the manifest for the runtime
+func (db *Database) openFiles() error {
+	if err := db.loadFiles(); err != nil {
+		return err
+	}
+
+	// keep the manifest small, since all its edits are replayed at startup
+	// the manifest is only replaced once compacted, so that it can still be closed if the compaction fails
+	manifest, err := db.manifest.compact()
+	if err != nil {
+		return err
+	}
+	db.manifest = manifest
+
+	// reset IO type to the one used at runtime
+	if db.options.MMapAtStartUp && db.options.IOType != fileio.MemoryMap {
+		if err := db.resetIOType(); err != nil {
+			return err
+		}
 	}
 
+	// the active file is preallocated through the IO manager used at runtime
+	return db.preallocateActiveFile()
+}
+
+// loadFiles loads the data files and builds the memory index or recovers the state kept by the B+ tree index
+func (db *Database) loadFiles() error {
 	// load merge data directory first
 	if err := db.loadMergeFiles(); err != nil {
-		return nil, err
+		return err
 	}
 
 	// then load data files
 	if err := db.loadDataFiles(); err != nil {
-		return nil, err
+		return err
 	}
 
 	// B+ tree indices do not require loading indexes from data files
-	if options.IndexType != BPlusTree {
+	if db.options.IndexType != BPlusTree {
 		// load index from hint index file first
 		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+			return err
 		}
 
 		// then load index from data file
 		if err := db.loadIndexFromDataFiles(); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
-	// load the current transaction sequence number
-	if options.IndexType == BPlusTree {
-		if err := db.loadSeqNo(); err != nil {
-			return nil, err
-		}
-
+	if db.options.IndexType == BPlusTree {
 		if db.activeFile != nil {
-			size, err := db.activeFile.IoManager.Size()
-			if err != nil {
-				return nil, err
+			if err := db.recoverActiveFileEnd(); err != nil {
+				return err
 			}
-			db.activeFile.WriteOffset = size
 		}
-	}
 
-	// reset IO type to standard file IO
-	if db.options.MMapAtStartUp {
-		if err := db.resetIOType(); err != nil {
-			return nil, err
+		// recover the current transaction sequence number
+		if err := db.recoverSeqNo(); err != nil {
+			return err
+		}
+
+		// the reclaimable sizes cannot be recalculated without loading the data files
+		if err := db.loadReclaimSize(); err != nil {
+			return err
 		}
 	}
 
-	return db, nil
+	return nil
+}
+
+// closeDataFiles closes the data files loaded by Open when it fails
+func (db *Database) closeDataFiles() {
+	db.discardActiveHintFile()
+	if db.activeFile != nil {
+		_ = db.activeFile.Close()
+	}
+	for _, file := range db.olderFiles {
+		_ = file.Close()
+	}
 }
 
 // Close closes the database instance
 func (db *Database) Close() error {
+	// stop the background merge before closing the files it reads
+	db.stopMergeWorker()
+
 	defer func() {
 		// release the file lock
 		if err := db.fileLock.Unlock(); err != nil {
@@ -200,33 +351,32 @@ func (db *Database) Close() error {
 	}()
 
 	if db.activeFile == nil {
-		return nil
+		return db.manifest.close()
 	}
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	// save the current transaction sequence number
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirectoryPath)
-	if err != nil {
+	// save the current transaction sequence number and the end of the data,
+	// so that no record needs to be scanned for them at startup
+	fields := append(seqNoManifestFields(db.seqNo, db.activeFile.FileID, db.activeFile.WriteOffset),
+		dataEndManifestFields(db.activeFile.FileID, db.activeFile.WriteOffset)...)
+	if err := db.manifest.append(fields...); err != nil {
 		return err
 	}
-
-	record := &data.LogRecord{
-		Key:   []byte(seqNoKey),
-		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
-	}
-
-	encodeRecord, _ := data.EncodeLogRecord(record)
-	if err := seqNoFile.Write(encodeRecord); err != nil {
+	if err := db.manifest.close(); err != nil {
 		return err
 	}
 
-	if err := seqNoFile.Sync(); err != nil {
-		return err
+	// save the reclaimable sizes for the B+ tree index, which does not load the data files at startup
+	if db.options.IndexType == BPlusTree {
+		if err := db.saveReclaimSize(); err != nil {
+			return err
+		}
 	}
 
-	// close the current active file
+	// close the current active file, whose hint file is started again at startup
+	db.discardActiveHintFile()
 	if err := db.activeFile.Close(); err != nil {
 		return err
 	}
@@ -238,6 +388,14 @@ func (db *Database) Close() error {
 		}
 	}
 
+	// close the replaced data files that are still pinned
+	for file := range db.retiredFiles {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	db.retiredFiles = make(map[*data.DataFile]struct{})
+
 	return nil
 }
 
@@ -269,12 +427,30 @@ func (db *Database) Stat() *Stat {
 		panic(fmt.Sprintf("failed to get the directory size: %v", err))
 	}
 
-	return &Stat{
-		KeyNum:          uint(db.index.Size()),
-		DataFileNum:     dataFiles,
-		ReclaimableSize: db.reclaimSize,
-		DiskSize:        dirSize,
+	fileReclaimSize := make(map[uint32]int64, len(db.fileReclaimSize))
+	for fileID, size := range db.fileReclaimSize {
+		fileReclaimSize[fileID] = size
 	}
+
+	compressionRatio := 1.0
+	if db.storedValueSize > 0 {
+		compressionRatio = float64(db.rawValueSize) / float64(db.storedValueSize)
+	}
+
+	stat := &Stat{
+		KeyNum:              uint(db.index.Size()),
+		DataFileNum:         dataFiles,
+		ReclaimableSize:     db.reclaimSize,
+		DiskSize:            dirSize,
+		FileReclaimableSize: fileReclaimSize,
+		CompressionRatio:    compressionRatio,
+	}
+	if db.valueCache != nil {
+		stat.ValueCacheHits = db.valueCache.hits.Load()
+		stat.ValueCacheMisses = db.valueCache.misses.Load()
+	}
+
+	return stat
 }
 
 // Backup backs up the database and copies the data files to a new directory
@@ -288,29 +464,57 @@ func (db *Database) Backup(directory string) error {
 
 // Put writes Key/Value data, where the key cannot be empty
 func (db *Database) Put(key []byte, value []byte) error {
+	return db.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL writes Key/Value data that expires after the given ttl
+// a zero ttl means that the key never expires
+func (db *Database) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
 	// is key valid or not
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 
+	var expire int64 = 0
+	if ttl != 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+
+	return db.putWithExpire(key, value, expire)
+}
+
+// putWithExpire writes Key/Value data that expires at the given timestamp in nanoseconds
+func (db *Database) putWithExpire(key []byte, value []byte, expire int64) error {
 	// create a LogRecord struct
 	logRecord := &data.LogRecord{
 		// use nonTransactionSeqNo to indicate the non-transaction data
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
 	}
 
+	return db.putLogRecord(key, logRecord)
+}
+
+// putLogRecord writes the non-transaction record of the key and updates the index
+func (db *Database) putLogRecord(key []byte, logRecord *data.LogRecord) error {
+	// the index is updated under the same lock,
+	// so that the transactions never observe a write without knowing about it
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	// append writes to the currently active data file
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
 
 	// update memory index
 	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+		db.addReclaimable(oldPos)
 	}
+	db.trackWrite(key)
 
 	return nil
 }
@@ -322,6 +526,9 @@ func (db *Database) Delete(key []byte) error {
 		return ErrKeyIsEmpty
 	}
 
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	// first check if key exists, return directly if key does not exist
 	if pos := db.index.Get(key); pos == nil {
 		return nil
@@ -335,11 +542,11 @@ func (db *Database) Delete(key []byte) error {
 	}
 
 	// write into the data file for the deleted record itself
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
-	db.reclaimSize += int64(pos.Size)
+	db.addReclaimable(pos)
 
 	// delete the corresponding key from the indices in memory
 	// since the lock is maintained by BTree internals, there is no need to lock here
@@ -349,12 +556,86 @@ func (db *Database) Delete(key []byte) error {
 	}
 
 	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+		db.addReclaimable(oldPos)
 	}
+	db.trackWrite(key)
 
 	return nil
 }
 
+// DeleteRange deletes every key within [start, end) by writing a single range tombstone
+// an empty end means that every key from start onwards is deleted
+func (db *Database) DeleteRange(start []byte, end []byte) error {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return ErrInvalidKeyRange
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// return directly if there is no key within the range
+	keys := db.indexRangeKeys(start, end)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// the start key is stored as the key and the end key as the value of the tombstone
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeq(start, nonTransactionSeqNo),
+		Value: end,
+		Type:  data.LogRecordRangeDeleted,
+	}
+
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	db.addReclaimable(pos)
+
+	db.deleteIndexKeys(keys)
+	for _, key := range keys {
+		db.trackWrite(key)
+	}
+
+	return nil
+}
+
+// DeletePrefix deletes every key with the given prefix by writing a single range tombstone
+func (db *Database) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	return db.DeleteRange(prefix, utils.PrefixEnd(prefix))
+}
+
+// deleteIndexRange deletes every key within [start, end) from the memory index
+// an empty end means that the range has no upper bound
+func (db *Database) deleteIndexRange(start []byte, end []byte) {
+	db.deleteIndexKeys(db.indexRangeKeys(start, end))
+}
+
+// indexRangeKeys returns the keys within [start, end) in the memory index
+// the keys are collected first, since some indices cannot be modified while being iterated,
+// and they are read from the index itself, as a clone would make the following deletions copy the shared nodes
+func (db *Database) indexRangeKeys(start []byte, end []byte) [][]byte {
+	var upperBound []byte
+	if len(end) > 0 {
+		upperBound = end
+	}
+
+	return db.index.RangeKeys(index.RangeOptions{LowerBound: start, UpperBound: upperBound})
+}
+
+// deleteIndexKeys deletes the keys from the memory index
+func (db *Database) deleteIndexKeys(keys [][]byte) {
+	for _, key := range keys {
+		if oldPos, _ := db.index.Delete(key); oldPos != nil {
+			db.addReclaimable(oldPos)
+		}
+	}
+}
+
 // Get obtains data by the key
 func (db *Database) Get(key []byte) ([]byte, error) {
 	db.mu.RLock()
@@ -368,7 +649,8 @@ func (db *Database) Get(key []byte) ([]byte, error) {
 	// get index information corresponding to the key from the memory data structure
 	logRecordPos := db.index.Get(key)
 	// if the key is not in the memory index, it means that the key does not exist
-	if logRecordPos == nil {
+	// an expired key is treated as missing as well
+	if logRecordPos == nil || isExpired(logRecordPos.Expire) {
 		return nil, ErrKeyNotFound
 	}
 
@@ -376,43 +658,52 @@ func (db *Database) Get(key []byte) ([]byte, error) {
 	return db.getValueByPosition(logRecordPos)
 }
 
-// ListKeys lists all the keys within the database
-func (db *Database) ListKeys() [][]byte {
-	iterator := db.index.Iterator(false)
-	defer iterator.Close()
+// TTL returns the remaining time to live of the key
+// a zero duration means that the key never expires
+func (db *Database) TTL(key []byte) (time.Duration, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
 
-	keys := make([][]byte, db.index.Size())
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil {
+		return 0, ErrKeyNotFound
+	}
 
-	var idx int
-	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+	if logRecordPos.Expire == 0 {
+		return 0, nil
 	}
 
-	return keys
+	remaining := logRecordPos.Expire - time.Now().UnixNano()
+	if remaining <= 0 {
+		return 0, ErrKeyNotFound
+	}
+
+	return time.Duration(remaining), nil
+}
+
+// ListKeys lists all the keys within the database
+// the keys are listed from a snapshot, so that they come from one point-in-time view
+func (db *Database) ListKeys() [][]byte {
+	snapshot := db.NewSnapshot()
+	defer snapshot.Release()
+
+	return snapshot.ListKeys()
 }
 
 // Fold obtains all data and performs the operations specified by the user
 // the traversal is terminated when the function returns false
+//
+// the data is read from a snapshot, so the function may write to the database
+// without affecting the traversal
 func (db *Database) Fold(fn func(key []byte, value []byte) bool) error {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
-	iterator := db.index.Iterator(false)
-	defer iterator.Close() // remember to close the iterator
-
-	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		value, err := db.getValueByPosition(iterator.Value())
-		if err != nil {
-			return err
-		}
+	snapshot := db.NewSnapshot()
+	defer snapshot.Release()
 
-		if !fn(iterator.Key(), value) {
-			break
-		}
-	}
-
-	return nil
+	return snapshot.Fold(fn)
 }
 
 // getValueByPosition gets the corresponding value according to the indexing information
@@ -425,11 +716,23 @@ func (db *Database) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte,
 		dataFile = db.olderFiles[logRecordPos.Fid]
 	}
 
+	return db.readValueFromFile(dataFile, logRecordPos)
+}
+
+// readValueFromFile reads the value of the record at the given position from the data file
+// and decompresses it if needed
+func (db *Database) readValueFromFile(dataFile *data.DataFile, logRecordPos *data.LogRecordPos) ([]byte, error) {
 	// if datafile is null
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
 	}
 
+	if db.valueCache != nil {
+		if value, ok := db.valueCache.get(dataFile, logRecordPos); ok {
+			return value, nil
+		}
+	}
+
 	// get the corresponding data according to offset
 	logRecord, _, err := dataFile.ReadLogRecord(logRecordPos.Offset)
 	if err != nil {
@@ -440,15 +743,15 @@ func (db *Database) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte,
 		return nil, ErrKeyNotFound
 	}
 
-	return logRecord.Value, nil
-}
-
-// appendLogRecordWithLock is a wrapper for appendLogRecord with locks
-func (db *Database) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	value, err := db.decompressValue(logRecord)
+	if err != nil {
+		return nil, err
+	}
+	if db.valueCache != nil {
+		db.valueCache.put(dataFile, logRecordPos, value)
+	}
 
-	return db.appendLogRecord(logRecord)
+	return value, nil
 }
 
 // appendLogRecord appends data to the active file
@@ -469,8 +772,18 @@ func (db *Database) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordP
 		}
 	}
 
+	// compress the value if a compressor is configured
+	rawValueSize := len(logRecord.Value)
+	logRecord, err := db.compressLogRecord(logRecord)
+	if err != nil {
+		return nil, err
+	}
+
 	// write the encoded data (we need encoding here!)
-	encRecord, size := data.EncodeLogRecord(logRecord)
+	encRecord, size, err := db.activeFile.EncodeLogRecord(logRecord)
+	if err != nil {
+		return nil, err
+	}
 
 	// If the data written has reached the active file threshold
 	// then the active file is closed and a new file is opened
@@ -481,32 +794,42 @@ func (db *Database) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordP
 		}
 
 		// convert currently active file to old data file
+		db.writeDataHintFile()
 		db.olderFiles[db.activeFile.FileID] = db.activeFile
 
 		// open a new data file
 		if err := db.setActiveDataFile(); err != nil {
 			return nil, err
 		}
-	}
 
-	// execute the actual data writing process
-	writeOffset := db.activeFile.WriteOffset
-	if err := db.activeFile.Write(encRecord); err != nil {
-		return nil, err
+		// the new file may be checksummed differently, which does not change the size of the record
+		if encRecord, _, err = db.activeFile.EncodeLogRecord(logRecord); err != nil {
+			return nil, err
+		}
 	}
-	db.bytesWrite += uint(size)
 
 	// determine synchronization based on user configurations
+	db.bytesWrite += uint(size)
 	var needSync = db.options.SyncWrites
 	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
 		needSync = true
 	}
 
+	// execute the actual data writing process, along with the synchronization if needed
+	writeOffset := db.activeFile.WriteOffset
 	if needSync {
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.activeFile.WriteSync(encRecord); err != nil {
 			return nil, err
 		}
+	} else if err := db.activeFile.Write(encRecord); err != nil {
+		return nil, err
+	}
+	if logRecord.Type == data.LogRecordNormal {
+		db.rawValueSize += int64(rawValueSize)
+		db.storedValueSize += int64(len(logRecord.Value))
+	}
 
+	if needSync {
 		// clear cumulative values
 		if db.bytesWrite > 0 {
 			db.bytesWrite = 0
@@ -518,29 +841,176 @@ func (db *Database) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordP
 		Fid:    db.activeFile.FileID,
 		Offset: writeOffset,
 		Size:   uint32(size),
+		Expire: logRecord.Expire,
 	}
 
+	db.writeActiveHint(logRecord, pos)
+
 	return pos, nil
 }
 
+// openActiveHintFile starts the hint file of the active file with the hints of the records already in it
+// the hint file is optional, if it cannot be written the data file is loaded by reading its records instead
+// must hold a mutex lock before accessing this method
+func (db *Database) openActiveHintFile(records []*data.TransactionRecord) {
+	if !db.writeDataHints {
+		return
+	}
+
+	fileID := db.activeFile.FileID
+	if err := os.RemoveAll(data.GetHintTempFileName(db.options.DirectoryPath, fileID)); err != nil {
+		return
+	}
+	hintFile, err := data.OpenDataHintTempFile(db.options.DirectoryPath, fileID)
+	if err != nil {
+		return
+	}
+	hintFile.Keyring = db.keyring
+	db.activeHintFile = hintFile
+
+	for _, record := range records {
+		db.writeActiveHint(record.Record, record.Pos)
+	}
+}
+
+// writeActiveHint appends the hint of a record in the active file to its hint file
+// must hold a mutex lock before accessing this method
+func (db *Database) writeActiveHint(logRecord *data.LogRecord, pos *data.LogRecordPos) {
+	if db.activeHintFile == nil {
+		return
+	}
+
+	if err := db.activeHintFile.WriteDataHintRecord(logRecord, pos); err != nil {
+		db.discardActiveHintFile()
+	}
+}
+
+// discardActiveHintFile closes and removes the unfinished hint file of the active file
+// must hold a mutex lock before accessing this method
+func (db *Database) discardActiveHintFile() {
+	if db.activeHintFile == nil {
+		return
+	}
+
+	_ = db.activeHintFile.Close()
+	_ = os.Remove(data.GetHintTempFileName(db.options.DirectoryPath, db.activeHintFile.FileID))
+	db.activeHintFile = nil
+}
+
+// writeDataHintFile completes the hint file of the active file, which has just become immutable
+// the hint file is written under a temporary name until then, so that an unfinished hint file is never loaded
+// must hold a mutex lock before accessing this method
+func (db *Database) writeDataHintFile() {
+	hintFile := db.activeHintFile
+	if hintFile == nil {
+		return
+	}
+
+	fileID := hintFile.FileID
+	if err := hintFile.Sync(); err != nil {
+		db.discardActiveHintFile()
+		return
+	}
+	if err := os.Rename(data.GetHintTempFileName(db.options.DirectoryPath, fileID),
+		data.GetHintFileName(db.options.DirectoryPath, fileID)); err != nil {
+		db.discardActiveHintFile()
+		return
+	}
+	_ = hintFile.Close()
+	db.activeHintFile = nil
+
+	db.hintFileIDs[fileID] = struct{}{}
+}
+
 // setActiveDataFile sets the current active data file
 // must hold a mutex lock before accessing this method
 func (db *Database) setActiveDataFile() error {
 	var initialFileID uint32 = 0
 	if db.activeFile != nil {
 		initialFileID = db.activeFile.FileID + 1
+
+		// the immutable file ends with its data, before the new data file is recorded
+		if db.options.PreallocateDataFiles {
+			if err := db.activeFile.Truncate(db.activeFile.WriteOffset); err != nil {
+				return err
+			}
+			if err := db.activeFile.Sync(); err != nil {
+				return err
+			}
+		}
+		db.unholdDataFile(db.activeFile)
+	}
+
+	// record the new data file before creating it, the records written afterwards all start in it
+	fields := append([]manifestField{{manifestAddFile, uint64(initialFileID)}},
+		seqNoManifestFields(db.seqNo, initialFileID, data.DataFileHeaderSize)...)
+	fields = append(fields, dataEndManifestFields(initialFileID, data.DataFileHeaderSize)...)
+	if err := db.manifest.append(fields...); err != nil {
+		return err
 	}
 
 	// open new data file
-	dataFile, err := data.OpenDataFile(db.options.DirectoryPath, initialFileID, fileio.StandardFileIO)
+	dataFile, err := data.OpenDataFile(db.options.DirectoryPath, initialFileID, db.options.IOType)
 	if err != nil {
 		return err
 	}
+	dataFile.Keyring = db.keyring
+	dataFile.BlockCache = db.blockCache
+	if err := db.cacheDataFile(dataFile, db.options.IOType, true); err != nil {
+		_ = dataFile.Close()
+		return err
+	}
 	db.activeFile = dataFile
+	db.openActiveHintFile(nil)
+
+	return db.preallocateActiveFile()
+}
+
+// cacheDataFile puts the data file into the file cache if the number of open files is limited,
+// the active file is held open until it becomes immutable
+func (db *Database) cacheDataFile(dataFile *data.DataFile, ioType fileio.FileIOType, active bool) error {
+	if db.fileCache == nil {
+		return nil
+	}
+
+	cachedFile := db.fileCache.Wrap(data.GetDataFileName(db.options.DirectoryPath, dataFile.FileID),
+		ioType, dataFile.IoManager)
+	dataFile.IoManager = cachedFile
+	if active {
+		return cachedFile.Hold()
+	}
 
 	return nil
 }
 
+// unholdDataFile lets the file cache close the data file that is no longer active
+func (db *Database) unholdDataFile(dataFile *data.DataFile) {
+	if cachedFile, ok := dataFile.IoManager.(*fileio.CachedFile); ok {
+		cachedFile.Unhold()
+	}
+}
+
+// holdPinnedFile keeps a pinned data file open before its path is replaced,
+// since the file cache could not reopen it afterward for the readers still pinning it
+// must hold a mutex lock before accessing this method
+func (db *Database) holdPinnedFile(dataFile *data.DataFile) error {
+	cachedFile, ok := dataFile.IoManager.(*fileio.CachedFile)
+	if !ok || db.pinnedFiles[dataFile] == 0 {
+		return nil
+	}
+
+	return cachedFile.Hold()
+}
+
+// preallocateActiveFile allocates the active file up to the data file size if configured
+func (db *Database) preallocateActiveFile() error {
+	if !db.options.PreallocateDataFiles || db.activeFile == nil {
+		return nil
+	}
+
+	return db.activeFile.IoManager.Preallocate(db.options.DataFileSize)
+}
+
 // loadDataFiles loads the data files from disk
 func (db *Database) loadDataFiles() error {
 	directoryEntries, err := os.ReadDir(db.options.DirectoryPath)
@@ -548,11 +1018,28 @@ func (db *Database) loadDataFiles() error {
 		return err
 	}
 
-	var fileIDs []int
+	hintFileIDs := make(map[uint32]struct{})
 
 	// loop through all files in the directory
 	// and find all files ending with .data
 	for _, entry := range directoryEntries {
+		// remove the leftover of an interrupted hint file write
+		if strings.HasSuffix(entry.Name(), data.HintTempFileNameSuffix) {
+			if err := os.Remove(filepath.Join(db.options.DirectoryPath, entry.Name())); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if strings.HasSuffix(entry.Name(), data.HintFileNameSuffix) {
+			fileID, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.HintFileNameSuffix))
+			if err != nil {
+				return ErrDataDirectoryCorrupted
+			}
+
+			hintFileIDs[uint32(fileID)] = struct{}{}
+		}
+
 		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
 			splitNames := strings.Split(entry.Name(), ".")
 			fileID, err := strconv.Atoi(splitNames[0])
@@ -562,25 +1049,45 @@ func (db *Database) loadDataFiles() error {
 				return ErrDataDirectoryCorrupted
 			}
 
-			fileIDs = append(fileIDs, fileID)
+			// every data file is recorded in the manifest before it is created
+			if _, ok := db.manifest.fileIDs[uint32(fileID)]; !ok {
+				return ErrDataDirectoryCorrupted
+			}
 		}
 	}
 
-	// we must sort the file ids and load them in ascending order
-	sort.Ints(fileIDs)
+	// the live data files are the ones recorded in the manifest, in ascending order
+	var fileIDs []int
+	for _, fileID := range db.manifest.sortedFileIDs() {
+		fileIDs = append(fileIDs, int(fileID))
+	}
 	db.fileIDs = fileIDs
+	db.hintFileIDs = hintFileIDs
 
 	// traverse each file id and open the corresponding data file
 	for i, fid := range fileIDs {
-		ioType := fileio.StandardFileIO
+		ioType := db.options.IOType
 		if db.options.MMapAtStartUp {
 			ioType = fileio.MemoryMap
 		}
 
+		// only the active file may be missing, if a crash happened right after it was recorded
+		if i < len(fileIDs)-1 {
+			if _, err := os.Stat(data.GetDataFileName(db.options.DirectoryPath, uint32(fid))); os.IsNotExist(err) {
+				return ErrDataFileNotFound
+			}
+		}
+
 		dataFile, err := data.OpenDataFile(db.options.DirectoryPath, uint32(fid), ioType)
 		if err != nil {
 			return err
 		}
+		dataFile.Keyring = db.keyring
+		dataFile.BlockCache = db.blockCache
+		if err := db.cacheDataFile(dataFile, ioType, i == len(fileIDs)-1); err != nil {
+			_ = dataFile.Close()
+			return err
+		}
 
 		// the last one has the largest id
 		// indicating that it is the currently active file
@@ -603,32 +1110,22 @@ func (db *Database) loadIndexFromDataFiles() error {
 	}
 
 	// check if merge has happened
-	hasMerge, nonMergeFileID := false, uint32(0)
-	mergeFinFileName := filepath.Join(db.options.DirectoryPath, data.MergeFinishedFileName)
-	if _, err := os.Stat(mergeFinFileName); err == nil {
-		fid, err := db.getNonMergeFileID(db.options.DirectoryPath)
-		if err != nil {
-			return err
-		}
-
-		hasMerge = true
-		nonMergeFileID = fid
-	}
+	hasMerge, nonMergeFileID := db.manifest.hasMerge, db.manifest.nonMergeFileID
 
 	updateIndex := func(key []byte, tp data.LogRecordType, pos *data.LogRecordPos) {
 		var oldPos *data.LogRecordPos
 
-		if tp == data.LogRecordDeleted {
-			// if it is a deleted index
+		if tp == data.LogRecordDeleted || isExpired(pos.Expire) {
+			// if it is a deleted (or already expired) index
 			// we need to process the deleted indices when starting the database engine
 			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size)
+			db.addReclaimable(pos)
 		} else {
 			oldPos = db.index.Put(key, pos)
 		}
 
 		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
+			db.addReclaimable(oldPos)
 		}
 	}
 
@@ -636,82 +1133,105 @@ func (db *Database) loadIndexFromDataFiles() error {
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
 	var currentSeqNo = nonTransactionSeqNo
 
-	// traverse all file IDs and process the records in the files
-	for i, fid := range db.fileIDs {
+	processRecord := func(logRecord *data.LogRecord, logRecordPos *data.LogRecordPos) {
+		// parse the key and get the transaction sequence number
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		if logRecord.Type == data.LogRecordRangeDeleted {
+			// a range tombstone deletes every key written before it within the range
+			db.deleteIndexRange(realKey, logRecord.Value)
+			db.addReclaimable(logRecordPos)
+		} else if seqNo == nonTransactionSeqNo {
+			// non-transactional operation, directly update the memory index
+			updateIndex(realKey, logRecord.Type, logRecordPos)
+		} else {
+			// if the transaction is completed
+			// the corresponding seqNo data can be updated to the memory index
+			if logRecord.Type == data.LogRecordTxnFinished {
+				for _, txnRecord := range transactionRecords[seqNo] {
+					updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+				}
+				delete(transactionRecords, seqNo)
+			} else { // if the transaction has not been completed, temporarily store data
+				logRecord.Key = realKey
+				transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
+					Record: logRecord,
+					Pos:    logRecordPos,
+				})
+			}
+		}
+
+		// update transaction sequence number
+		if seqNo > currentSeqNo {
+			currentSeqNo = seqNo
+		}
+	}
+
+	// collect the data files whose records are not loaded from the hint index file
+	var dataFiles []*data.DataFile
+	for _, fid := range db.fileIDs {
 		var fileID = uint32(fid)
 		// If the id is smaller than the file id that has not been merged recently
 		// it means that the index has been loaded from the Hint file
-		if hasMerge && fileID < nonMergeFileID {
+		// unless the merged file has been compacted and got its own hint file afterwards
+		if _, ok := db.hintFileIDs[fileID]; hasMerge && fileID < nonMergeFileID && !ok {
 			continue
 		}
 
-		var dataFile *data.DataFile
 		if fileID == db.activeFile.FileID {
-			dataFile = db.activeFile
+			dataFiles = append(dataFiles, db.activeFile)
 		} else {
-			dataFile = db.olderFiles[fileID]
+			dataFiles = append(dataFiles, db.olderFiles[fileID])
 		}
+	}
 
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return err
-			}
+	// the data files are decoded concurrently, but their records are processed in the order of file ids,
+	// so that the latest records and the transactions are applied as if the files were loaded one by one
+	err := db.loadDataFileRecords(dataFiles, func(dataFile *data.DataFile, decoded *dataFileRecords) error {
+		// the hint file of the active file starts again with its records, before their keys are parsed
+		if dataFile == db.activeFile {
+			db.openActiveHintFile(decoded.records)
+		}
 
-			// construct the index in memory and save
-			logRecordPos := &data.LogRecordPos{
-				Fid:    fileID,
-				Offset: offset,
-				Size:   uint32(size),
-			}
+		for _, record := range decoded.records {
+			processRecord(record.Record, record.Pos)
+		}
 
-			// parse the key and get the transaction sequence number
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
-				// non-transactional operation, directly update the memory index
-				updateIndex(realKey, logRecord.Type, logRecordPos)
-			} else {
-				// if the transaction is completed
-				// the corresponding seqNo data can be updated to the memory index
-				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
-					}
-					delete(transactionRecords, seqNo)
-				} else { // if the transaction has not been completed, temporarily store data
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
+		// if it is the current active file, update the WriteOffset of this file
+		if dataFile == db.activeFile {
+			// a torn write is truncated, so that the next record is appended right after the last one
+			if decoded.tornSize > 0 {
+				if err := db.recoverTornTail(decoded); err != nil {
+					return err
 				}
 			}
-
-			// update transaction sequence number
-			if seqNo > currentSeqNo {
-				currentSeqNo = seqNo
-			}
-
-			// increment offset and start reading from the new position next time
-			offset += size
+			db.activeFile.WriteOffset = decoded.size
 		}
 
-		// if it is the current active file, update the WriteOffset of this file
-		if i == len(db.fileIDs)-1 {
-			db.activeFile.WriteOffset = offset
-		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// update transaction sequence number
-	db.seqNo = currentSeqNo
+	// the records of the latest transactions may have been merged, the manifest still records their sequence number
+	db.seqNo = max(currentSeqNo, db.manifest.seqNo)
 
 	return nil
 }
 
+// addReclaimable records the record at the given position as invalid data
+func (db *Database) addReclaimable(pos *data.LogRecordPos) {
+	db.reclaimSize += int64(pos.Size)
+	db.fileReclaimSize[pos.Fid] += int64(pos.Size)
+}
+
+// isExpired checks whether the given expire timestamp has passed
+// a zero timestamp means that the record never expires
+func isExpired(expire int64) bool {
+	return expire > 0 && expire <= time.Now().UnixNano()
+}
+
 // checkOptions checks the validity of the used-defined options
 func checkOptions(options Options) error {
 	if options.DirectoryPath == "" {
@@ -726,48 +1246,147 @@ func checkOptions(options Options) error {
 		return errors.New("invalid merge ratio, must be between 0 and 1 inclusive")
 	}
 
+	if options.LoadConcurrency < 0 {
+		return errors.New("the load concurrency cannot be negative")
+	}
+
+	if options.IOType > fileio.DirectIO {
+		return errors.New("unsupported IO type")
+	}
+
+	if options.BlockCacheSize < 0 {
+		return errors.New("the block cache size cannot be negative")
+	}
+
+	if options.MaxOpenFiles < 0 {
+		return errors.New("the maximum number of open files cannot be negative")
+	}
+
+	if options.ValueCacheSize < 0 {
+		return errors.New("the value cache size cannot be negative")
+	}
+
+	if options.CompressionMinSize < 0 {
+		return errors.New("the compression min size cannot be negative")
+	}
+
+	if options.EncryptKeys && options.EncryptionKey == nil {
+		return errors.New("the keys cannot be encrypted without an encryption key")
+	}
+
+	if options.EncryptKeys && options.IndexType == BPlusTree {
+		return errors.New("the keys cannot be encrypted with the B+ tree index, which stores them in plaintext")
+	}
+
+	if options.AutoMergeInterval < 0 {
+		return errors.New("the auto merge interval cannot be negative")
+	}
+
+	if window := options.AutoMergeWindow; window != nil &&
+		(window.Start < 0 || window.Start >= 24*time.Hour || window.End < 0 || window.End > 24*time.Hour) {
+		return errors.New("invalid auto merge window, must be within a day")
+	}
+
 	return nil
 }
 
-func (db *Database) loadSeqNo() error {
-	fileName := filepath.Join(db.options.DirectoryPath, data.SeqNoFileName)
-	if _, err := os.Stat(fileName); os.IsNotExist(err) {
-		return nil
+// saveReclaimSize saves the reclaimable size of each data file
+// one record is written for each file, with the file id as the key and the size as the value
+func (db *Database) saveReclaimSize() error {
+	fileName := filepath.Join(db.options.DirectoryPath, data.ReclaimFileName)
+	if err := os.RemoveAll(fileName); err != nil {
+		return err
 	}
 
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirectoryPath)
+	reclaimFile, err := data.OpenReclaimFile(db.options.DirectoryPath)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = reclaimFile.Close()
+	}()
 
-	record, _, err := seqNoFile.ReadLogRecord(0)
-	if err != nil {
-		return err
+	for fileID, size := range db.fileReclaimSize {
+		record := &data.LogRecord{
+			Key:   []byte(strconv.FormatUint(uint64(fileID), 10)),
+			Value: []byte(strconv.FormatInt(size, 10)),
+		}
+
+		encodeRecord, _ := data.EncodeLogRecord(record)
+		if err := reclaimFile.Write(encodeRecord); err != nil {
+			return err
+		}
+	}
+
+	return reclaimFile.Sync()
+}
+
+// loadReclaimSize loads the reclaimable size of each data file saved when the database was closed
+func (db *Database) loadReclaimSize() error {
+	fileName := filepath.Join(db.options.DirectoryPath, data.ReclaimFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
 	}
 
-	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
+	reclaimFile, err := data.OpenReclaimFile(db.options.DirectoryPath)
 	if err != nil {
 		return err
 	}
 
-	db.seqNo = seqNo
-	db.seqNoFilesExists = true
+	var offset int64 = 0
+	for {
+		record, size, err := reclaimFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			_ = reclaimFile.Close()
+			return err
+		}
+
+		fileID, err := strconv.ParseUint(string(record.Key), 10, 32)
+		if err != nil {
+			_ = reclaimFile.Close()
+			return err
+		}
+
+		reclaimSize, err := strconv.ParseInt(string(record.Value), 10, 64)
+		if err != nil {
+			_ = reclaimFile.Close()
+			return err
+		}
+
+		db.fileReclaimSize[uint32(fileID)] = reclaimSize
+		db.reclaimSize += reclaimSize
+		offset += size
+	}
+
+	if err := reclaimFile.Close(); err != nil {
+		return err
+	}
 
+	// the sizes change as soon as the database is written, so the file is only valid once
 	return os.Remove(fileName)
 }
 
-// resetIOType sets the IO type of the data files into standard file IO
+// resetIOType sets the IO type of the data files into the one used at runtime
 func (db *Database) resetIOType() error {
 	if db.activeFile == nil {
 		return nil
 	}
 
-	if err := db.activeFile.SetIOManager(db.options.DirectoryPath, fileio.StandardFileIO); err != nil {
+	if err := db.activeFile.SetIOManager(db.options.DirectoryPath, db.options.IOType); err != nil {
+		return err
+	}
+	if err := db.cacheDataFile(db.activeFile, db.options.IOType, true); err != nil {
 		return err
 	}
 
 	for _, dataFile := range db.olderFiles {
-		if err := dataFile.SetIOManager(db.options.DirectoryPath, fileio.StandardFileIO); err != nil {
+		if err := dataFile.SetIOManager(db.options.DirectoryPath, db.options.IOType); err != nil {
+			return err
+		}
+		if err := db.cacheDataFile(dataFile, db.options.IOType, false); err != nil {
 			return err
 		}
 	}
@@ -13,10 +13,14 @@
 package betadb
 
 import (
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/fileio"
 	"github.com/LiuShuoJiang/betadb/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"sync"
 	"testing"
+	"time"
 )
 
 func destroyDB(db *Database) {
@@ -297,6 +301,521 @@ func TestDatabase_Sync(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestDatabase_MemoryMapIO(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+	options.DataFileSize = 64 * 1024
+	options.DataFileMergeRatio = 0
+	options.IOType = fileio.MemoryMap
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 5000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Sync())
+	assert.Greater(t, len(db.olderFiles), 1)
+	_, ok := db.activeFile.IoManager.(*fileio.MMap)
+	assert.True(t, ok)
+
+	check := func(db *Database, keyNum int) {
+		assert.Equal(t, keyNum, len(db.ListKeys()))
+		for i := 1000; i < 5000; i++ {
+			value, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), value)
+		}
+	}
+	check(db, 4000)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	check(db, 4000)
+
+	// the preallocated space is dropped when the files are closed
+	activeFileID := db.activeFile.FileID
+	activeFileSize := db.activeFile.WriteOffset
+	err = db.Close()
+	assert.Nil(t, err)
+	fileInfo, err := os.Stat(data.GetDataFileName(directory, activeFileID))
+	assert.Nil(t, err)
+	assert.Equal(t, activeFileSize, fileInfo.Size())
+
+	// the files written with mmap are read with standard file IO
+	options.IOType = fileio.StandardFileIO
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check(db, 4000)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// the preallocated space left by a crash is discarded at startup
+	file, err := os.OpenFile(data.GetDataFileName(directory, activeFileID), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Truncate(activeFileSize+64*1024))
+	assert.Nil(t, file.Close())
+
+	var reports []RecoveryReport
+	options.IOType = fileio.MemoryMap
+	options.OnRecovery = func(report RecoveryReport) {
+		reports = append(reports, report)
+	}
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, activeFileSize, reports[0].Offset)
+	assert.Equal(t, int64(64*1024), reports[0].DiscardedSize)
+
+	err = db.Put([]byte("name"), []byte("betadb"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check(db, 4001)
+	value, err := db.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("betadb"), value)
+}
+
+func TestDatabase_IOUringIO(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+	options.DataFileSize = 64 * 1024
+	options.DataFileMergeRatio = 0
+	options.IOType = fileio.IOUring
+	options.BytesPerSync = 4 * 1024
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 5000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Greater(t, len(db.olderFiles), 1)
+
+	// the reads of concurrent Get calls share the ring
+	check := func(db *Database, keyNum int) {
+		assert.Equal(t, keyNum, len(db.ListKeys()))
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 1000 + g; i < 5000; i += 8 {
+					value, err := db.Get(utils.GetTestKey(i))
+					assert.Nil(t, err)
+					assert.Equal(t, utils.GetTestKey(i), value)
+				}
+			}(g)
+		}
+		wg.Wait()
+	}
+	check(db, 4000)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	check(db, 4000)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// the writes and their fsync are submitted together
+	options.SyncWrites = true
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check(db, 4000)
+	err = db.Put([]byte("name"), []byte("betadb"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// the files written with io_uring are read with standard file IO
+	options.IOType = fileio.StandardFileIO
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check(db, 4001)
+	value, err := db.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("betadb"), value)
+}
+
+func TestDatabase_DirectIO(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+	options.DataFileSize = 64 * 1024
+	options.DataFileMergeRatio = 0
+	options.IOType = fileio.DirectIO
+	options.BlockCacheSize = 1024 * 1024
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 5000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Greater(t, len(db.olderFiles), 1)
+	_, ok := db.activeFile.IoManager.(*fileio.DirectFile)
+	assert.True(t, ok)
+
+	check := func(db *Database, keyNum int) {
+		assert.Equal(t, keyNum, len(db.ListKeys()))
+		for i := 1000; i < 5000; i++ {
+			value, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), value)
+		}
+	}
+	check(db, 4000)
+
+	// the whole blocks read are cached, up to the capacity
+	assert.Greater(t, db.blockCache.Size(), int64(0))
+	assert.LessOrEqual(t, db.blockCache.Size(), options.BlockCacheSize)
+	check(db, 4000)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	check(db, 4000)
+
+	// the records written after reading the last block of the active file are read back
+	err = db.Put([]byte("name"), []byte("betadb"))
+	assert.Nil(t, err)
+	value, err := db.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("betadb"), value)
+	err = db.Put([]byte("name"), []byte("betadb-direct"))
+	assert.Nil(t, err)
+	value, err = db.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("betadb-direct"), value)
+
+	// the padding is dropped when the files are closed
+	activeFileID := db.activeFile.FileID
+	activeFileSize := db.activeFile.WriteOffset
+	err = db.Close()
+	assert.Nil(t, err)
+	fileInfo, err := os.Stat(data.GetDataFileName(directory, activeFileID))
+	assert.Nil(t, err)
+	assert.Equal(t, activeFileSize, fileInfo.Size())
+
+	// the files written with direct IO are read with standard file IO
+	options.IOType = fileio.StandardFileIO
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.blockCache)
+	check(db, 4001)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// and the other way around, without the block cache
+	options.IOType = fileio.DirectIO
+	options.BlockCacheSize = 0
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.blockCache)
+	check(db, 4001)
+	value, err = db.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("betadb-direct"), value)
+}
+
+func TestDatabase_MaxOpenFiles(t *testing.T) {
+	for _, ioType := range []fileio.FileIOType{fileio.StandardFileIO, fileio.MemoryMap, fileio.DirectIO} {
+		options := DefaultOptions
+		directory, _ := os.MkdirTemp("", "betadb")
+		options.DirectoryPath = directory
+		options.DataFileSize = 16 * 1024
+		options.IOType = ioType
+		options.DataFileMergeRatio = 0
+		options.MaxOpenFiles = 2
+
+		db, err := Open(options)
+		assert.Nil(t, err)
+
+		for i := 0; i < 5000; i++ {
+			err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+			assert.Nil(t, err)
+		}
+		assert.Greater(t, len(db.olderFiles), options.MaxOpenFiles)
+		assert.LessOrEqual(t, db.fileCache.OpenNum(), options.MaxOpenFiles)
+
+		check := func(get func([]byte) ([]byte, error), value func(int) []byte) {
+			for i := 0; i < 5000; i++ {
+				v, err := get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, value(i), v)
+			}
+		}
+		check(db.Get, utils.GetTestKey)
+		assert.LessOrEqual(t, db.fileCache.OpenNum(), options.MaxOpenFiles)
+
+		// the snapshot keeps reading the files replaced by the compaction and the merge,
+		// which are held open since they cannot be reopened
+		snapshot := db.NewSnapshot()
+		for i := 0; i < 5000; i++ {
+			err := db.Put(utils.GetTestKey(i), []byte("new-value"))
+			assert.Nil(t, err)
+		}
+		newValue := func(int) []byte {
+			return []byte("new-value")
+		}
+
+		err = db.Compact(0.5)
+		assert.Nil(t, err)
+		check(snapshot.Get, utils.GetTestKey)
+		check(db.Get, newValue)
+
+		err = db.Merge()
+		assert.Nil(t, err)
+		check(snapshot.Get, utils.GetTestKey)
+		check(db.Get, newValue)
+
+		// the replaced files are closed once they are released
+		snapshot.Release()
+		check(db.Get, newValue)
+		assert.LessOrEqual(t, db.fileCache.OpenNum(), options.MaxOpenFiles)
+
+		// the data files are loaded within the limit too
+		err = db.Close()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, db.fileCache.OpenNum())
+		db, err = Open(options)
+		assert.Nil(t, err)
+		check(db.Get, newValue)
+		assert.LessOrEqual(t, db.fileCache.OpenNum(), options.MaxOpenFiles)
+
+		destroyDB(db)
+	}
+}
+
+func TestDatabase_PutWithTTL(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// (1) test for a key that has not expired yet
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(128), time.Hour)
+	assert.Nil(t, err)
+	value1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, value1)
+
+	// (2) test for an expired key
+	err = db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(128), time.Millisecond*50)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(128))
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 100)
+
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 2, len(db.ListKeys()))
+
+	var foldCount int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		foldCount++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, foldCount)
+
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	var iterCount int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		assert.NotEqual(t, utils.GetTestKey(2), iterator.Key())
+		iterCount++
+	}
+	iterator.Close()
+	assert.Equal(t, 2, iterCount)
+
+	// (3) test for overwriting an expired key
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(128))
+	assert.Nil(t, err)
+	value2, err := db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.NotNil(t, value2)
+
+	// (4) test for restarting the database
+	err = db.PutWithTTL(utils.GetTestKey(4), utils.RandomValue(128), time.Millisecond*50)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 100)
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(options)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+
+	_, err = db2.Get(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+	value3, err := db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value1, value3)
+	assert.Equal(t, 3, len(db2.ListKeys()))
+}
+
+func TestDatabase_TTL(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// (1) test for a nonexistent key
+	_, err = db.TTL(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// (2) test for a key without expiry
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(128))
+	assert.Nil(t, err)
+	ttl1, err := db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), ttl1)
+
+	// (3) test for a key with expiry
+	err = db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(128), time.Hour)
+	assert.Nil(t, err)
+	ttl2, err := db.TTL(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Greater(t, ttl2, time.Minute*59)
+	assert.LessOrEqual(t, ttl2, time.Hour)
+
+	// (4) test for an expired key
+	err = db.PutWithTTL(utils.GetTestKey(3), utils.RandomValue(128), time.Millisecond*10)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 20)
+	_, err = db.TTL(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDatabase_DeleteRange(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	// (1) test for an invalid range
+	err = db.DeleteRange(utils.GetTestKey(20), utils.GetTestKey(10))
+	assert.Equal(t, ErrInvalidKeyRange, err)
+
+	// (2) the start key is deleted while the end key is kept
+	err = db.DeleteRange(utils.GetTestKey(10), utils.GetTestKey(20))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(19))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+	assert.Equal(t, 90, len(db.ListKeys()))
+
+	// (3) the keys written after the tombstone are visible
+	err = db.Put(utils.GetTestKey(15), utils.RandomValue(128))
+	assert.Nil(t, err)
+
+	// (4) an empty end deletes every key from the start onwards
+	err = db.DeleteRange(utils.GetTestKey(50), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 41, len(db.ListKeys()))
+
+	// (5) restart database, the tombstones are replayed in order
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 41, len(db2.ListKeys()))
+
+	_, err = db2.Get(utils.GetTestKey(15))
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(16))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(99))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDatabase_DeletePrefix(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for _, key := range []string{"tenant-1:a", "tenant-1:b", "tenant-10:a", "tenant-2:a"} {
+		err := db.Put([]byte(key), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	err = db.DeletePrefix(nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	err = db.DeletePrefix([]byte("tenant-1:"))
+	assert.Nil(t, err)
+
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	var keys []string
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys = append(keys, string(iterator.Key()))
+	}
+	iterator.Close()
+	assert.Equal(t, []string{"tenant-10:a", "tenant-2:a"}, keys)
+
+	// nothing is written when no key has the prefix
+	size := db.activeFile.WriteOffset
+	err = db.DeletePrefix([]byte("tenant-3:"))
+	assert.Nil(t, err)
+	assert.Equal(t, size, db.activeFile.WriteOffset)
+}
+
 func TestDatabase_ListKeys(t *testing.T) {
 	options := DefaultOptions
 	directory, _ := os.MkdirTemp("", "betadb")
@@ -388,6 +907,35 @@ func TestDatabase_FileLock(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+// TestDatabase_OpenFailureReleasesLock tests for releasing the directory when Open fails after loading the files
+func TestDatabase_OpenFailureReleasesLock(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+	assert.Nil(t, db.Close())
+
+	// the active file cannot be preallocated beyond the limits of the file system
+	failOptions := options
+	failOptions.DataFileSize = 1 << 62
+	failOptions.PreallocateDataFiles = true
+	db, err = Open(failOptions)
+	if err == nil {
+		assert.Nil(t, db.Close())
+		t.Skip("fallocate is not supported by the file system")
+	}
+
+	db, err = Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	value, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, value)
+}
+
 func TestDatabase_Stat(t *testing.T) {
 	options := DefaultOptions
 	directory, _ := os.MkdirTemp("", "betadb")
@@ -449,3 +997,162 @@ func TestDatabase_Backup(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db2)
 }
+
+// TestDatabase_OpenWithDataHintFiles tests for loading the immutable files from their hint files
+func TestDatabase_OpenWithDataHintFiles(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+	options.DataFileSize = 64 * 1024
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.DeleteRange(utils.GetTestKey(900), nil)
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 1100; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	for i := 1100; i < 1500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	// every immutable file has got its hint file at rotation
+	assert.True(t, len(db.olderFiles) > 1)
+	for fileID := range db.olderFiles {
+		_, err := os.Stat(data.GetHintFileName(directory, fileID))
+		assert.Nil(t, err)
+	}
+	_, err = os.Stat(data.GetHintFileName(directory, db.activeFile.FileID))
+	assert.True(t, os.IsNotExist(err))
+
+	stat := db.Stat()
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// corrupt the first record of the first data file, which is never read when its hint file is loaded
+	dataFile, err := os.OpenFile(data.GetDataFileName(directory, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = dataFile.WriteAt([]byte("corrupted"), data.DataFileHeaderSize+20)
+	assert.Nil(t, err)
+	_ = dataFile.Close()
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 800+500, len(db.ListKeys()))
+	assert.Equal(t, stat.ReclaimableSize, db.Stat().ReclaimableSize)
+
+	_, err = db.Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(950))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	val, err = db.Get(utils.GetTestKey(1050))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+// TestDatabase_DataHintFileError tests for writing when the hint file of the rotated active file cannot be written
+func TestDatabase_DataHintFileError(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+	options.DataFileSize = 64 * 1024
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(0), utils.RandomValue(128))
+	assert.Nil(t, err)
+
+	// a directory in place of the hint file keeps it from being renamed into place
+	hintFileName := data.GetHintFileName(directory, 0)
+	assert.Nil(t, os.MkdirAll(hintFileName+"/occupied", os.ModePerm))
+
+	// the hint file is optional, so the writes go on in a new active file
+	for i := 1; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 1)
+	_, err = os.Stat(data.GetHintTempFileName(directory, 0))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(data.GetHintFileName(directory, 1))
+	assert.Nil(t, err)
+
+	// restart database, the first file is loaded from its data file
+	err = db.Close()
+	assert.Nil(t, err)
+	assert.Nil(t, os.RemoveAll(hintFileName))
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+}
+
+// TestDatabase_DataHintFileAfterRestart tests for writing the hint file of an active file reopened at startup
+func TestDatabase_DataHintFileAfterRestart(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+	options.DataFileSize = 64 * 1024
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 100; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// the records written before the restart are in the hint file of the first file as well
+	db, err = Open(options)
+	assert.Nil(t, err)
+	for i := 100; db.activeFile.FileID == 0; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	keys := len(db.ListKeys())
+	_, err = os.Stat(data.GetHintFileName(directory, 0))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// corrupt the first record of the first data file, which is never read when its hint file is loaded
+	dataFile, err := os.OpenFile(data.GetDataFileName(directory, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = dataFile.WriteAt([]byte("corrupted"), data.DataFileHeaderSize+20)
+	assert.Nil(t, err)
+	_ = dataFile.Close()
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, keys, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
@@ -0,0 +1,265 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"bytes"
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// directoryContains tells whether any file of the directory contains the bytes
+func directoryContains(t *testing.T, directory string, b []byte) bool {
+	entries, err := os.ReadDir(directory)
+	assert.Nil(t, err)
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(directory, entry.Name()))
+		assert.Nil(t, err)
+		if bytes.Contains(content, b) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDatabase_Encryption(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-encryption")
+	options.DataFileSize = 64 * 1024
+	options.DirectoryPath = directory
+	options.EncryptionKey = bytes.Repeat([]byte{1}, 32)
+	options.Compression = FlateCompressor
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("secret-value"))
+		assert.Nil(t, err)
+	}
+	err = db.Put([]byte("compressed"), compressibleValue(0, 1024))
+	assert.Nil(t, err)
+
+	check := func(db *Database) {
+		for i := 0; i < 1000; i++ {
+			value, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("secret-value"), value)
+		}
+		value, err := db.Get([]byte("compressed"))
+		assert.Nil(t, err)
+		assert.Equal(t, compressibleValue(0, 1024), value)
+	}
+	check(db)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	assert.False(t, directoryContains(t, directory, []byte("secret-value")))
+	assert.True(t, directoryContains(t, directory, utils.GetTestKey(999)))
+
+	// the encrypted records cannot be read without the key
+	options.EncryptionKey = nil
+	_, err = Open(options)
+	assert.Equal(t, data.ErrEncryptionKeyNotFound, err)
+
+	options.EncryptionKey = bytes.Repeat([]byte{1}, 32)
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check(db)
+}
+
+func TestDatabase_EncryptionKeyRotation(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-encryption")
+	options.DataFileSize = 64 * 1024
+	options.DataFileMergeRatio = 0
+	options.DirectoryPath = directory
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	options.EncryptionKey = oldKey
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	check := func(db *Database) {
+		assert.Equal(t, 2000, len(db.ListKeys()))
+		for i := 0; i < 2000; i++ {
+			value, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), value)
+		}
+	}
+
+	// the records written under the old key stay readable, while the new ones are written under the new key
+	options.EncryptionKey = newKey
+	options.OldEncryptionKeys = [][]byte{oldKey}
+	db, err = Open(options)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	check(db)
+
+	// the old key is no longer needed once the merge has re-encrypted every record
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	options.OldEncryptionKeys = nil
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check(db)
+
+	// the hint files are in plaintext, so the values are only decrypted when they are read
+	err = db.Close()
+	assert.Nil(t, err)
+	options.EncryptionKey = oldKey
+	db, err = Open(options)
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, data.ErrEncryptionKeyNotFound, err)
+}
+
+func TestDatabase_EncryptKeys(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-encryption")
+	options.DataFileSize = 64 * 1024
+	options.DataFileMergeRatio = 0
+	options.DirectoryPath = directory
+	options.EncryptionKey = bytes.Repeat([]byte{1}, 16)
+	options.EncryptKeys = true
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("secret-value"))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.DeleteRange(utils.GetTestKey(500), utils.GetTestKey(600))
+	assert.Nil(t, err)
+
+	txn := db.Begin(false)
+	assert.Nil(t, txn.Put([]byte("txn-key"), []byte("secret-value")))
+	assert.Nil(t, txn.Commit())
+
+	// the merge writes an encrypted hint index, and the rotated files have encrypted hint files
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	check := func(db *Database) {
+		assert.Equal(t, 1401, len(db.ListKeys()))
+		_, err := db.Get(utils.GetTestKey(550))
+		assert.Equal(t, ErrKeyNotFound, err)
+		value, err := db.Get(utils.GetTestKey(1999))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("secret-value"), value)
+		value, err = db.Get([]byte("txn-key"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("secret-value"), value)
+	}
+	check(db)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	assert.False(t, directoryContains(t, directory, []byte("secret-value")))
+	assert.False(t, directoryContains(t, directory, []byte("betadb-key")))
+	assert.False(t, directoryContains(t, directory, []byte("txn-key")))
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check(db)
+
+	// the offline tools cannot read the encrypted keys
+	err = db.Close()
+	assert.Nil(t, err)
+	report, err := Check(directory)
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy())
+	_, err = Repair(directory)
+	assert.Equal(t, ErrKeysEncrypted, err)
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+
+	options.IndexType = BPlusTree
+	_, err = Open(options)
+	assert.NotNil(t, err)
+	options.IndexType = BTree
+	options.EncryptionKey = nil
+	_, err = Open(options)
+	assert.NotNil(t, err)
+}
+
+// TestRepair_Encryption tests for repairing a data directory with encrypted values without the key
+func TestRepair_Encryption(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-encryption")
+	options.DirectoryPath = directory
+	options.EncryptionKey = bytes.Repeat([]byte{1}, 24)
+
+	db, err := Open(options)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("secret-value"))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	report, err := Check(directory)
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy())
+
+	report2, err := Repair(directory)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, report2.KeyNum)
+	defer func() {
+		_ = os.RemoveAll(report2.DirectoryPath)
+	}()
+
+	// the values are copied as stored, so they are still encrypted with the same key
+	assert.False(t, directoryContains(t, report2.DirectoryPath, []byte("secret-value")))
+	options.DirectoryPath = report2.DirectoryPath
+	repairedDB, err := Open(options)
+	defer destroyDB(repairedDB)
+	assert.Nil(t, err)
+	value, err := repairedDB.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret-value"), value)
+
+	_ = os.RemoveAll(directory)
+}
@@ -20,9 +20,22 @@ var (
 	ErrKeyNotFound            = errors.New("key is not found in the database")
 	ErrDataFileNotFound       = errors.New("data file is not found")
 	ErrDataDirectoryCorrupted = errors.New("database directory might be corrupted")
+	ErrDataFileCorrupted      = errors.New("data file is corrupted in the middle")
 	ErrExceedMaxBatchNum      = errors.New("maximum batch numbers has been exceeded")
 	ErrMergeIsInProgress      = errors.New("merging is in progress, please try again later")
 	ErrDatabaseIsUsing        = errors.New("database directory is being used by another process")
 	ErrMergeRatioUnreached    = errors.New("merge ratio does not reach the option")
+	ErrInvalidCompactRatio    = errors.New("invalid compaction ratio, must be between 0 and 1 inclusive")
+	ErrCompactUnsupported     = errors.New("compaction is not supported by the B+ tree index")
 	ErrNoEnoughSpaceForMerge  = errors.New("no enough space on disk for merging")
+	ErrTxnConflict            = errors.New("transaction conflicts, the data read has been modified by others")
+	ErrTxnReadOnly            = errors.New("cannot write data in a read-only transaction")
+	ErrTxnClosed              = errors.New("transaction has been committed or discarded")
+	ErrSnapshotReleased       = errors.New("snapshot has been released")
+	ErrInvalidKeyRange        = errors.New("the start key must be less than the end key")
+	ErrKeysOnlyIterator       = errors.New("cannot read values from a keys-only iterator")
+	ErrRepairDirectoryExists  = errors.New("the repair directory already exists")
+	ErrIndexTypeMismatch      = errors.New("the data directory was written with an index type that cannot be switched to")
+	ErrUnknownCompressor      = errors.New("the value is compressed by a compressor that is not configured")
+	ErrKeysEncrypted          = errors.New("the keys are encrypted and cannot be read without the encryption key")
 )
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fileio
+
+import (
+	"container/list"
+	"sync"
+)
+
+// BlockCache is an LRU cache of the blocks read with direct IO, shared by the files of a database
+// the blocks are identified by a number chosen by the owner of each file, which should never be reused
+// for another file, or for the same file once it has been truncated
+type BlockCache struct {
+	// capacity is the maximum number of bytes of the cached blocks
+	capacity int64
+
+	// size is the number of bytes of the cached blocks
+	size int64
+
+	blocks map[blockCacheKey]*list.Element
+
+	// lru lists the blocks from the most recently used to the least recently used one
+	lru *list.List
+
+	mu sync.Mutex
+}
+
+type blockCacheKey struct {
+	file  uint64
+	block int64
+}
+
+type blockCacheEntry struct {
+	key  blockCacheKey
+	data []byte
+}
+
+// NewBlockCache creates a block cache holding up to capacity bytes
+func NewBlockCache(capacity int64) *BlockCache {
+	return &BlockCache{
+		capacity: capacity,
+		blocks:   make(map[blockCacheKey]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Get gets a block of the file, which must not be modified
+func (c *BlockCache) Get(file uint64, block int64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.blocks[blockCacheKey{file: file, block: block}]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+
+	return element.Value.(*blockCacheEntry).data, true
+}
+
+// Put caches a block of the file, evicting the least recently used blocks beyond the capacity
+// the cache keeps the data, which must not be modified afterward
+func (c *BlockCache) Put(file uint64, block int64, data []byte) {
+	if int64(len(data)) > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := blockCacheKey{file: file, block: block}
+	if element, ok := c.blocks[key]; ok {
+		entry := element.Value.(*blockCacheEntry)
+		c.size += int64(len(data) - len(entry.data))
+		entry.data = data
+		c.lru.MoveToFront(element)
+	} else {
+		c.blocks[key] = c.lru.PushFront(&blockCacheEntry{key: key, data: data})
+		c.size += int64(len(data))
+	}
+
+	for c.size > c.capacity {
+		element := c.lru.Back()
+		entry := element.Value.(*blockCacheEntry)
+		c.lru.Remove(element)
+		delete(c.blocks, entry.key)
+		c.size -= int64(len(entry.data))
+	}
+}
+
+// Size gets the number of bytes of the cached blocks
+func (c *BlockCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fileio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBlockCache(t *testing.T) {
+	cache := NewBlockCache(3 * DirectIOBlockSize)
+
+	_, ok := cache.Get(1, 0)
+	assert.False(t, ok)
+
+	for block := int64(0); block < 3; block++ {
+		cache.Put(1, block, make([]byte, DirectIOBlockSize))
+	}
+	assert.Equal(t, int64(3*DirectIOBlockSize), cache.Size())
+
+	// the blocks of another file are told apart
+	_, ok = cache.Get(2, 0)
+	assert.False(t, ok)
+
+	// the least recently used block is evicted first
+	_, ok = cache.Get(1, 0)
+	assert.True(t, ok)
+	cache.Put(2, 0, make([]byte, DirectIOBlockSize))
+	assert.Equal(t, int64(3*DirectIOBlockSize), cache.Size())
+	_, ok = cache.Get(1, 1)
+	assert.False(t, ok)
+	_, ok = cache.Get(1, 0)
+	assert.True(t, ok)
+	_, ok = cache.Get(2, 0)
+	assert.True(t, ok)
+
+	// a block replaced keeps a single entry
+	cache.Put(2, 0, []byte("betadb"))
+	assert.Equal(t, int64(2*DirectIOBlockSize+6), cache.Size())
+	data, ok := cache.Get(2, 0)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("betadb"), data)
+
+	// a block larger than the cache is not cached
+	cache.Put(3, 0, make([]byte, 4*DirectIOBlockSize))
+	_, ok = cache.Get(3, 0)
+	assert.False(t, ok)
+}
@@ -0,0 +1,248 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fileio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// DirectIOBlockSize is the alignment of the offsets, the lengths and the buffers of direct IO,
+// which covers the logical block size of the common devices
+const DirectIOBlockSize = 4096
+
+// directIOBufferSize is the size of the buffer kept for the writes, the larger ones use a buffer of their own
+const directIOBufferSize = 64 * 1024
+
+// BlockReader is implemented by the IO managers that read whole aligned blocks,
+// whose callers should read with aligned offsets, lengths and buffers to avoid copying the data
+type BlockReader interface {
+	// BlockSize gets the alignment of the reads, zero if they need none
+	BlockSize() int
+}
+
+// DirectFile is a file read and written with O_DIRECT, bypassing the page cache
+// every write rewrites the last partial block of the file padded with zeros,
+// and the padding after the data is truncated when the file is closed
+type DirectFile struct {
+	fd *os.File
+
+	// size is the size of the data written to the file
+	size int64
+
+	// buffer is an aligned buffer for the writes, which starts with the last partial block of the file
+	buffer []byte
+
+	// preallocated indicates whether the file may have been allocated beyond the data
+	preallocated bool
+
+	// mu serializes the writes, which rewrite the last block of the file, with the reads
+	mu sync.RWMutex
+}
+
+// AlignedBuffer allocates a buffer whose address is aligned to the block size of direct IO
+func AlignedBuffer(size int) []byte {
+	buffer := make([]byte, size+DirectIOBlockSize)
+	shift := 0
+	if remainder := int(uintptr(unsafe.Pointer(&buffer[0])) & (DirectIOBlockSize - 1)); remainder > 0 {
+		shift = DirectIOBlockSize - remainder
+	}
+
+	return buffer[shift : shift+size : shift+size]
+}
+
+// isAligned checks whether the buffer and the offset can be used for direct IO as they are
+func isAligned(b []byte, offset int64) bool {
+	return len(b) > 0 && len(b)%DirectIOBlockSize == 0 && offset%DirectIOBlockSize == 0 &&
+		uintptr(unsafe.Pointer(&b[0]))&(DirectIOBlockSize-1) == 0
+}
+
+// alignUp rounds the size up to a multiple of the block size
+func alignUp(size int64) int64 {
+	return (size + DirectIOBlockSize - 1) / DirectIOBlockSize * DirectIOBlockSize
+}
+
+// NewDirectIOManager opens a file for direct IO
+// the file is opened through the page cache if its file system does not support O_DIRECT
+func NewDirectIOManager(fileName string) (*DirectFile, error) {
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|directIOFlag, DataFilePermission)
+	if errors.Is(err, syscall.EINVAL) {
+		fd, err = os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, DataFilePermission)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+
+	d := &DirectFile{fd: fd, buffer: AlignedBuffer(directIOBufferSize)}
+	if err := d.loadTail(fileInfo.Size()); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// loadTail sets the size of the data and reads its last partial block into the write buffer
+func (d *DirectFile) loadTail(size int64) error {
+	d.size = size
+
+	tailSize := int(size % DirectIOBlockSize)
+	if tailSize == 0 {
+		return nil
+	}
+
+	// the block is read as a whole, the read stops at the end of file
+	numBytes, err := d.fd.ReadAt(d.buffer[:DirectIOBlockSize], size-int64(tailSize))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if numBytes < tailSize {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
+
+// Read reads the corresponding data from a given location in a file
+// an unaligned read goes through an aligned buffer covering the blocks of the data
+func (d *DirectFile) Read(b []byte, offset int64) (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if offset >= d.size {
+		return 0, io.EOF
+	}
+
+	numBytes := min(int64(len(b)), d.size-offset)
+	if numBytes == int64(len(b)) && isAligned(b, offset) {
+		return d.fd.ReadAt(b, offset)
+	}
+
+	start := offset / DirectIOBlockSize * DirectIOBlockSize
+	buffer := AlignedBuffer(int(alignUp(offset+numBytes) - start))
+	n, err := d.fd.ReadAt(buffer, start)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if int64(n) < offset+numBytes-start {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	copy(b, buffer[offset-start:offset-start+numBytes])
+	if numBytes < int64(len(b)) {
+		return int(numBytes), io.EOF
+	}
+
+	return int(numBytes), nil
+}
+
+// Write appends the given byte array to file
+// the last partial block of the file is written again along with the data, padded with zeros to a whole block
+func (d *DirectFile) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tailSize := int(d.size % DirectIOBlockSize)
+	start := d.size - int64(tailSize)
+	length := int(alignUp(int64(tailSize + len(b))))
+
+	buffer := d.buffer
+	if length > len(buffer) {
+		buffer = AlignedBuffer(length)
+		copy(buffer, d.buffer[:tailSize])
+	}
+	buffer = buffer[:length]
+	copy(buffer[tailSize:], b)
+	clear(buffer[tailSize+len(b):])
+
+	if _, err := d.fd.WriteAt(buffer, start); err != nil {
+		return 0, err
+	}
+	d.size += int64(len(b))
+
+	// keep the new last partial block at the beginning of the buffer
+	newTailSize := int(d.size % DirectIOBlockSize)
+	newStart := int(d.size-start) - newTailSize
+	copy(d.buffer[:newTailSize], buffer[newStart:newStart+newTailSize])
+
+	return len(b), nil
+}
+
+// Sync forces any writes to sync to disk
+// the data bypasses the page cache, but the size of the file may still need to be synced
+func (d *DirectFile) Sync() error {
+	return d.fd.Sync()
+}
+
+// Truncate discards the data after the given size
+func (d *DirectFile) Truncate(size int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.fd.Truncate(size); err != nil {
+		return err
+	}
+
+	return d.loadTail(size)
+}
+
+// Preallocate allocates the file up to the given size for the data written afterward
+func (d *DirectFile) Preallocate(size int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := preallocate(d.fd, size); err != nil {
+		return err
+	}
+	d.preallocated = true
+
+	return nil
+}
+
+// Close drops the padding and the space preallocated after the data, and closes the file
+func (d *DirectFile) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.preallocated || d.size%DirectIOBlockSize != 0 {
+		if err := d.fd.Truncate(d.size); err != nil {
+			_ = d.fd.Close()
+			return err
+		}
+	}
+
+	return d.fd.Close()
+}
+
+// Size gets the size of the data written to the file
+func (d *DirectFile) Size() (int64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.size, nil
+}
+
+// BlockSize gets the alignment of the reads
+func (d *DirectFile) BlockSize() int {
+	return DirectIOBlockSize
+}
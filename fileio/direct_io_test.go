@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fileio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+)
+
+func TestAlignedBuffer(t *testing.T) {
+	for _, size := range []int{DirectIOBlockSize, 3 * DirectIOBlockSize, 100} {
+		buffer := AlignedBuffer(size)
+		assert.Equal(t, size, len(buffer))
+		assert.Equal(t, size, cap(buffer))
+		assert.Equal(t, uintptr(0), uintptr(unsafe.Pointer(&buffer[0]))%DirectIOBlockSize)
+	}
+}
+
+func TestDirectFile_Write(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "direct-write")
+	defer destroyFile(path)
+
+	directIO, err := NewDirectIOManager(path)
+	assert.Nil(t, err)
+
+	numBytes, err := directIO.Write([]byte("betadb"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, numBytes)
+
+	// the writes are padded to whole blocks
+	fileInfo, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(DirectIOBlockSize), fileInfo.Size())
+
+	// a write larger than the buffer spans several blocks after the last partial one
+	value := make([]byte, directIOBufferSize+100)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	_, err = directIO.Write(value)
+	assert.Nil(t, err)
+	_, err = directIO.Write([]byte("golang"))
+	assert.Nil(t, err)
+
+	size, err := directIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(value)+12), size)
+
+	buffer := make([]byte, 6)
+	_, err = directIO.Read(buffer, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("betadb"), buffer)
+
+	buffer = make([]byte, len(value))
+	_, err = directIO.Read(buffer, 6)
+	assert.Nil(t, err)
+	assert.Equal(t, value, buffer)
+
+	buffer = make([]byte, 10)
+	numBytes, err = directIO.Read(buffer, size-8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 8, numBytes)
+	assert.Equal(t, append(value[len(value)-2:], "golang"...), buffer[:8])
+
+	// the padding is dropped when the file is closed
+	assert.Nil(t, directIO.Close())
+	fileInfo, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, size, fileInfo.Size())
+
+	// the writes continue after the last partial block of the reopened file
+	directIO, err = NewDirectIOManager(path)
+	assert.Nil(t, err)
+	_, err = directIO.Write([]byte("!"))
+	assert.Nil(t, err)
+	buffer = make([]byte, 7)
+	_, err = directIO.Read(buffer, size-6)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("golang!"), buffer)
+	assert.Nil(t, directIO.Close())
+}
+
+func TestDirectFile_AlignedRead(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "direct-aligned-read")
+	defer destroyFile(path)
+
+	directIO, err := NewDirectIOManager(path)
+	assert.Nil(t, err)
+	value := make([]byte, 2*DirectIOBlockSize)
+	for i := range value {
+		value[i] = byte(i % 251)
+	}
+	_, err = directIO.Write(value)
+	assert.Nil(t, err)
+
+	// an aligned read goes to the file as it is
+	buffer := AlignedBuffer(DirectIOBlockSize)
+	numBytes, err := directIO.Read(buffer, DirectIOBlockSize)
+	assert.Nil(t, err)
+	assert.Equal(t, DirectIOBlockSize, numBytes)
+	assert.Equal(t, value[DirectIOBlockSize:], buffer)
+
+	// an aligned read beyond the data stops at its end
+	buffer = AlignedBuffer(2 * DirectIOBlockSize)
+	numBytes, err = directIO.Read(buffer, DirectIOBlockSize)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, DirectIOBlockSize, numBytes)
+	assert.Nil(t, directIO.Close())
+}
+
+func TestDirectFile_Truncate(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "direct-truncate")
+	defer destroyFile(path)
+
+	directIO, err := NewDirectIOManager(path)
+	assert.Nil(t, err)
+	_, err = directIO.Write([]byte("betadb-torn"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, directIO.Truncate(6))
+	size, err := directIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+
+	_, err = directIO.Write([]byte("!"))
+	assert.Nil(t, err)
+	buffer := make([]byte, 7)
+	_, err = directIO.Read(buffer, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("betadb!"), buffer)
+	assert.Nil(t, directIO.Close())
+
+	fileInfo, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), fileInfo.Size())
+}
+
+func TestDirectFile_Preallocate(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "direct-preallocate")
+	defer destroyFile(path)
+
+	checkPreallocate(t, path, DirectIO)
+}
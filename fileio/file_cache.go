@@ -0,0 +1,383 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fileio
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrFileClosed is returned by the operations on a cached file that has been closed
+var ErrFileClosed = errors.New("the cached file is closed")
+
+// FileCache limits the number of files kept open by the IO managers of a database
+// the files are closed from the least recently used one once too many are open, and reopened on demand
+//
+// an open file is used through its own reference count, the cache lock is only taken to reopen a file closed
+// by the cache, and to close the files beyond the capacity or those closed by their owner
+type FileCache struct {
+	// capacity is the maximum number of files kept open while they are not used
+	capacity int
+
+	// openNum is the number of files open, only changed while holding the lock
+	openNum atomic.Int64
+
+	// clock stamps the files each time they are used
+	clock atomic.Uint64
+
+	// open lists the open files
+	open *list.List
+
+	mu sync.Mutex
+}
+
+// CachedFile is the IO manager of a file in the file cache, which opens the file only while it is used
+type CachedFile struct {
+	cache    *FileCache
+	fileName string
+	ioType   FileIOType
+
+	// ioManager is the IO manager of the open file, null once the file is closed by the cache
+	// it is only replaced while refs is negative, so a reference keeps it valid
+	ioManager IOManager
+
+	// refs is the number of operations using the file plus one if the file is held,
+	// negative once the file has been closed by the cache or by its owner
+	refs atomic.Int64
+
+	// lastUsed is the clock of the cache when the file was last used
+	lastUsed atomic.Uint64
+
+	// held keeps the file open until it is released, only accessed while holding the lock of the cache
+	held bool
+
+	// element is the position of the file in the open list, null if the file is not open
+	element *list.Element
+
+	// closed indicates whether the file has been closed by its owner
+	closed atomic.Bool
+}
+
+// NewFileCache creates a file cache keeping up to capacity files open
+// the files used or held are never closed by the cache, so more files may be open for a while
+func NewFileCache(capacity int) *FileCache {
+	return &FileCache{
+		capacity: capacity,
+		open:     list.New(),
+	}
+}
+
+// Wrap adds an open file to the file cache, the file is reopened with the given IO type after it is closed by the cache
+func (c *FileCache) Wrap(fileName string, ioType FileIOType, ioManager IOManager) *CachedFile {
+	file := &CachedFile{
+		cache:     c,
+		fileName:  fileName,
+		ioType:    ioType,
+		ioManager: ioManager,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.openNum.Add(1)
+	file.element = c.open.PushBack(file)
+	file.touch()
+	c.evict()
+
+	return file
+}
+
+// OpenNum gets the number of files open
+func (c *FileCache) OpenNum() int {
+	return int(c.openNum.Load())
+}
+
+// overCapacity checks whether more files are open than the capacity
+func (c *FileCache) overCapacity() bool {
+	return c.openNum.Load() > int64(c.capacity)
+}
+
+// evict closes the least recently used files beyond the capacity that are neither used nor held
+// must hold a mutex lock before accessing this method
+func (c *FileCache) evict() {
+	for c.overCapacity() {
+		var victim *CachedFile
+		for element := c.open.Front(); element != nil; element = element.Next() {
+			file := element.Value.(*CachedFile)
+			if file.refs.Load() == 0 && (victim == nil || file.lastUsed.Load() < victim.lastUsed.Load()) {
+				victim = file
+			}
+		}
+
+		// the file may have been used since, and is then looked for again
+		if victim == nil {
+			return
+		}
+		if victim.refs.CompareAndSwap(0, -1) {
+			_ = c.closeFile(victim)
+		}
+	}
+}
+
+// closeFile closes the IO manager of the file, which must not be referenced any more
+// must hold a mutex lock before accessing this method
+func (c *FileCache) closeFile(file *CachedFile) error {
+	if file.ioManager == nil {
+		return nil
+	}
+
+	c.open.Remove(file.element)
+	file.element = nil
+
+	err := file.ioManager.Close()
+	file.ioManager = nil
+	c.openNum.Add(-1)
+
+	return err
+}
+
+// acquire keeps the file open until it is released, the file is only reopened under the cache lock
+// if it has been closed by the cache
+func (f *CachedFile) acquire() (IOManager, error) {
+	for {
+		refs := f.refs.Load()
+		if refs < 0 {
+			return f.reopen()
+		}
+		if f.refs.CompareAndSwap(refs, refs+1) {
+			break
+		}
+	}
+
+	if f.closed.Load() {
+		f.release()
+		return nil, ErrFileClosed
+	}
+	f.touch()
+
+	return f.ioManager, nil
+}
+
+// touch marks the file as the most recently used one
+func (f *CachedFile) touch() {
+	f.lastUsed.Store(f.cache.clock.Add(1))
+}
+
+// reopen opens the file closed by the cache, and keeps it open until it is released
+func (f *CachedFile) reopen() (IOManager, error) {
+	c := f.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return f.acquireLocked()
+}
+
+// acquireLocked opens the file if needed, and keeps it open until it is released
+// must hold a mutex lock before accessing this method
+func (f *CachedFile) acquireLocked() (IOManager, error) {
+	if f.closed.Load() {
+		return nil, ErrFileClosed
+	}
+
+	// the file may have been reopened since, which cannot be closed while holding the lock
+	if f.refs.Load() >= 0 {
+		f.refs.Add(1)
+		f.touch()
+		return f.ioManager, nil
+	}
+
+	ioManager, err := NewIOManager(f.fileName, f.ioType)
+	if err != nil {
+		return nil, err
+	}
+	f.ioManager = ioManager
+	f.element = f.cache.open.PushBack(f)
+	f.cache.openNum.Add(1)
+	f.touch()
+	f.refs.Store(1)
+	f.cache.evict()
+
+	return f.ioManager, nil
+}
+
+// release drops a reference to the file, the cache lock is only taken once the file is no longer referenced,
+// to close it if it has been closed by its owner or to close the files beyond the capacity
+func (f *CachedFile) release() {
+	if f.refs.Add(-1) > 0 {
+		return
+	}
+
+	c := f.cache
+	if !f.closed.Load() && !c.overCapacity() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f.closed.Load() && f.refs.CompareAndSwap(0, -1) {
+		_ = c.closeFile(f)
+	}
+	c.evict()
+}
+
+// Hold opens the file if needed, and keeps it open until Unhold or Close is called
+// it keeps the file readable once its path has been replaced, which would not be reopened any more
+func (f *CachedFile) Hold() error {
+	c := f.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f.held {
+		return nil
+	}
+	if _, err := f.acquireLocked(); err != nil {
+		return err
+	}
+	f.held = true
+
+	return nil
+}
+
+// Unhold lets the cache close the file again
+func (f *CachedFile) Unhold() {
+	c := f.cache
+	c.mu.Lock()
+	held := f.held
+	f.held = false
+	c.mu.Unlock()
+
+	if held {
+		f.release()
+	}
+}
+
+// Read reads the corresponding data from a given location in a file
+func (f *CachedFile) Read(b []byte, offset int64) (int, error) {
+	ioManager, err := f.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer f.release()
+
+	return ioManager.Read(b, offset)
+}
+
+// Write writes the given byte array to file
+func (f *CachedFile) Write(b []byte) (int, error) {
+	ioManager, err := f.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer f.release()
+
+	return ioManager.Write(b)
+}
+
+// WriteSync writes the given byte array to file and forces it to sync to disk,
+// in a single operation if the IO manager of the file supports it
+func (f *CachedFile) WriteSync(b []byte) (int, error) {
+	ioManager, err := f.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer f.release()
+
+	if syncWriter, ok := ioManager.(SyncWriter); ok {
+		return syncWriter.WriteSync(b)
+	}
+
+	numBytes, err := ioManager.Write(b)
+	if err != nil {
+		return numBytes, err
+	}
+	return numBytes, ioManager.Sync()
+}
+
+// Sync forces any writes to sync to disk
+func (f *CachedFile) Sync() error {
+	ioManager, err := f.acquire()
+	if err != nil {
+		return err
+	}
+	defer f.release()
+
+	return ioManager.Sync()
+}
+
+// Truncate discards the data after the given size
+func (f *CachedFile) Truncate(size int64) error {
+	ioManager, err := f.acquire()
+	if err != nil {
+		return err
+	}
+	defer f.release()
+
+	return ioManager.Truncate(size)
+}
+
+// Preallocate allocates the file up to the given size for the data written afterward
+// the file must be held, since the space left after the data is dropped when the file is closed
+func (f *CachedFile) Preallocate(size int64) error {
+	ioManager, err := f.acquire()
+	if err != nil {
+		return err
+	}
+	defer f.release()
+
+	return ioManager.Preallocate(size)
+}
+
+// Size gets the size of the data written to the file
+func (f *CachedFile) Size() (int64, error) {
+	ioManager, err := f.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer f.release()
+
+	return ioManager.Size()
+}
+
+// BlockSize gets the size of the blocks read by the IO manager of the file, zero if it reads any range
+func (f *CachedFile) BlockSize() int {
+	if f.ioType == DirectIO {
+		return DirectIOBlockSize
+	}
+	return 0
+}
+
+// Close closes the file, which cannot be reopened afterward
+// a file still used is closed once its last operation completes
+func (f *CachedFile) Close() error {
+	c := f.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f.closed.Load() {
+		return nil
+	}
+	f.closed.Store(true)
+
+	if f.held {
+		f.held = false
+		f.refs.Add(-1)
+	}
+	if !f.refs.CompareAndSwap(0, -1) {
+		return nil
+	}
+
+	return c.closeFile(f)
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fileio
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestCachedFiles creates the files holding their own names, wrapped into the file cache
+func newTestCachedFiles(t *testing.T, cache *FileCache, num int, ioType FileIOType) []*CachedFile {
+	files := make([]*CachedFile, num)
+	for i := range files {
+		path := filepath.Join(t.TempDir(), fmt.Sprintf("%09d.data", i))
+		ioManager, err := NewIOManager(path, ioType)
+		assert.Nil(t, err)
+		_, err = ioManager.Write([]byte(path))
+		assert.Nil(t, err)
+
+		files[i] = cache.Wrap(path, ioType, ioManager)
+		t.Cleanup(func() {
+			_ = files[i].Close()
+		})
+	}
+
+	return files
+}
+
+// checkCachedFile checks that the file is readable and holds its name
+func checkCachedFile(t *testing.T, file *CachedFile) {
+	size, err := file.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(file.fileName)), size)
+
+	b := make([]byte, size)
+	_, err = file.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, file.fileName, string(b))
+}
+
+func TestFileCache(t *testing.T) {
+	for _, ioType := range []FileIOType{StandardFileIO, MemoryMap, IOUring, DirectIO} {
+		cache := NewFileCache(2)
+		files := newTestCachedFiles(t, cache, 4, ioType)
+		assert.Equal(t, 2, cache.OpenNum())
+
+		// the evicted files are reopened on demand
+		for _, file := range files {
+			checkCachedFile(t, file)
+		}
+		assert.Equal(t, 2, cache.OpenNum())
+
+		// the least recently used file is evicted first
+		checkCachedFile(t, files[2])
+		checkCachedFile(t, files[0])
+		assert.NotNil(t, files[0].ioManager)
+		assert.Nil(t, files[1].ioManager)
+		assert.NotNil(t, files[2].ioManager)
+		assert.Nil(t, files[3].ioManager)
+	}
+}
+
+func TestCachedFile_Hold(t *testing.T) {
+	cache := NewFileCache(1)
+	files := newTestCachedFiles(t, cache, 3, StandardFileIO)
+
+	// a held file is never evicted, even beyond the capacity
+	assert.Nil(t, files[0].Hold())
+	assert.Nil(t, files[1].Hold())
+	checkCachedFile(t, files[2])
+	assert.Equal(t, 2, cache.OpenNum())
+	assert.NotNil(t, files[0].ioManager)
+	assert.NotNil(t, files[1].ioManager)
+
+	files[0].Unhold()
+	files[1].Unhold()
+	assert.Equal(t, 1, cache.OpenNum())
+	assert.NotNil(t, files[1].ioManager)
+
+	// the file is writable while it is held
+	numBytes, err := files[1].Write([]byte("betadb"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, numBytes)
+	size, err := files[1].Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(files[1].fileName)+6), size)
+}
+
+func TestCachedFile_Close(t *testing.T) {
+	cache := NewFileCache(1)
+	files := newTestCachedFiles(t, cache, 2, StandardFileIO)
+	assert.Nil(t, files[0].Hold())
+	assert.Equal(t, 1, cache.OpenNum())
+	assert.NotNil(t, files[0].ioManager)
+
+	// a held file is closed too, and cannot be reopened
+	assert.Nil(t, files[0].Close())
+	assert.Nil(t, files[1].Close())
+	assert.Nil(t, files[1].Close())
+	assert.Equal(t, 0, cache.OpenNum())
+
+	_, err := files[0].Read(make([]byte, 1), 0)
+	assert.Equal(t, ErrFileClosed, err)
+	_, err = files[1].Size()
+	assert.Equal(t, ErrFileClosed, err)
+	assert.Equal(t, ErrFileClosed, files[1].Hold())
+}
+
+func TestCachedFile_ConcurrentRead(t *testing.T) {
+	cache := NewFileCache(2)
+	files := newTestCachedFiles(t, cache, 8, StandardFileIO)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				checkCachedFile(t, files[(i+j)%len(files)])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 2, cache.OpenNum())
+}
+
+func TestCachedFile_ReadWithoutLock(t *testing.T) {
+	cache := NewFileCache(2)
+	files := newTestCachedFiles(t, cache, 2, StandardFileIO)
+
+	// an open file is read without taking the lock of the cache
+	cache.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		checkCachedFile(t, files[0])
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("the read waits for the lock of the cache")
+	}
+	cache.mu.Unlock()
+	<-done
+
+	// a file closed by the cache is reopened under the lock
+	cache = NewFileCache(1)
+	files = newTestCachedFiles(t, cache, 2, StandardFileIO)
+	assert.Nil(t, files[0].ioManager)
+	checkCachedFile(t, files[0])
+	assert.NotNil(t, files[0].ioManager)
+	assert.Nil(t, files[1].ioManager)
+	assert.Equal(t, 1, cache.OpenNum())
+}
@@ -12,21 +12,32 @@
 
 package fileio
 
-import "os"
+import (
+	"io"
+	"os"
+	"sync/atomic"
+)
 
 // FileIO is a wrapper for the standard file IO descriptor
 type FileIO struct {
 	// fd is the system file descriptor
 	fd *os.File
+
+	// size is the size of the data written to the file, where the next write starts
+	size atomic.Int64
+
+	// preallocated indicates whether the file may have been allocated beyond the data
+	preallocated bool
 }
 
 // NewFileIOManager creates a new FileIO instance
 func NewFileIOManager(fileName string) (*FileIO, error) {
 	// Open the file in read-write mode
+	// the writes append at the end of the data rather than with O_APPEND, since the file may be preallocated
 	fd, err := os.OpenFile(
 		fileName,
-		// O_CREATE: create the file if it does not exist; O_RDWR: read-write mode; O_APPEND: append mode
-		os.O_CREATE|os.O_RDWR|os.O_APPEND,
+		// O_CREATE: create the file if it does not exist; O_RDWR: read-write mode
+		os.O_CREATE|os.O_RDWR,
 		DataFilePermission,
 	)
 
@@ -34,17 +45,41 @@ func NewFileIOManager(fileName string) (*FileIO, error) {
 		return nil, err
 	}
 
-	return &FileIO{fd: fd}, nil
+	fileInfo, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+
+	f := &FileIO{fd: fd}
+	f.size.Store(fileInfo.Size())
+
+	return f, nil
 }
 
-// Read reads the corresponding data from a given location in a file
+// Read reads the corresponding data from a given location in a file, up to the end of the data
 func (f *FileIO) Read(b []byte, offset int64) (int, error) {
+	if remaining := f.size.Load() - offset; remaining < int64(len(b)) {
+		if remaining <= 0 {
+			return 0, io.EOF
+		}
+
+		numBytes, err := f.fd.ReadAt(b[:remaining], offset)
+		if err == nil {
+			err = io.EOF
+		}
+		return numBytes, err
+	}
+
 	return f.fd.ReadAt(b, offset)
 }
 
-// Write writes the given byte array to file
+// Write appends the given byte array to the data of the file
 func (f *FileIO) Write(b []byte) (int, error) {
-	return f.fd.Write(b)
+	numBytes, err := f.fd.WriteAt(b, f.size.Load())
+	f.size.Add(int64(numBytes))
+
+	return numBytes, err
 }
 
 // Sync forces any writes to sync to disk
@@ -52,17 +87,39 @@ func (f *FileIO) Sync() error {
 	return f.fd.Sync()
 }
 
-// Close closes the file
+// Truncate discards the data after the given size
+func (f *FileIO) Truncate(size int64) error {
+	if err := f.fd.Truncate(size); err != nil {
+		return err
+	}
+	f.size.Store(size)
+
+	return nil
+}
+
+// Preallocate allocates the file up to the given size for the data written afterward
+func (f *FileIO) Preallocate(size int64) error {
+	if err := preallocate(f.fd, size); err != nil {
+		return err
+	}
+	f.preallocated = true
+
+	return nil
+}
+
+// Close drops the space preallocated after the data and closes the file
 func (f *FileIO) Close() error {
+	if f.preallocated {
+		if err := f.fd.Truncate(f.size.Load()); err != nil {
+			_ = f.fd.Close()
+			return err
+		}
+	}
+
 	return f.fd.Close()
 }
 
-// Size gets the size of file
+// Size gets the size of the data written to the file
 func (f *FileIO) Size() (int64, error) {
-	fileInfo, err := f.fd.Stat()
-	if err != nil {
-		return 0, err
-	}
-
-	return fileInfo.Size(), nil
+	return f.size.Load(), nil
 }
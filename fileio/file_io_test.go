@@ -14,6 +14,7 @@ package fileio
 
 import (
 	"github.com/stretchr/testify/assert"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -104,3 +105,63 @@ func TestFileIO_Close(t *testing.T) {
 	err = fIO.Close()
 	assert.Nil(t, err)
 }
+
+func TestFileIO_Truncate(t *testing.T) {
+	path := filepath.Join("/tmp", "some.data")
+	fIO, err := NewFileIOManager(path)
+	defer destroyFile(path)
+	assert.Nil(t, err)
+
+	_, err = fIO.Write([]byte("betadb-torn"))
+	assert.Nil(t, err)
+	err = fIO.Truncate(6)
+	assert.Nil(t, err)
+
+	// the writes are appended after the truncated data
+	_, err = fIO.Write([]byte("!"))
+	assert.Nil(t, err)
+	buffer := make([]byte, 7)
+	_, err = fIO.Read(buffer, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("betadb!"), buffer)
+
+	err = fIO.Close()
+	assert.Nil(t, err)
+}
+
+// checkPreallocate checks that the data written to a preallocated file ends where it was written,
+// and that the space after it is dropped when the file is closed
+func checkPreallocate(t *testing.T, path string, ioType FileIOType) {
+	ioManager, err := NewIOManager(path, ioType)
+	assert.Nil(t, err)
+	_, err = ioManager.Write([]byte("betadb"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, ioManager.Preallocate(64*1024))
+	size, err := ioManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+	fileInfo, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, fileInfo.Size(), int64(64*1024))
+
+	// the writes are appended after the data rather than after the preallocated space
+	_, err = ioManager.Write([]byte("!"))
+	assert.Nil(t, err)
+	buffer := make([]byte, 8)
+	numBytes, err := ioManager.Read(buffer, 0)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []byte("betadb!"), buffer[:numBytes])
+
+	assert.Nil(t, ioManager.Close())
+	fileInfo, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), fileInfo.Size())
+}
+
+func TestFileIO_Preallocate(t *testing.T) {
+	path := filepath.Join("/tmp", "some.data")
+	defer destroyFile(path)
+
+	checkPreallocate(t, path, StandardFileIO)
+}
@@ -22,12 +22,19 @@ const (
 	// StandardFileIO is the common standard file IO option
 	StandardFileIO FileIOType = iota
 
-	// MemoryMap is the MMAP file IO option
+	// MemoryMap is the MMAP file IO option, which maps the file into memory for both reads and writes
 	MemoryMap
+
+	// IOUring is the io_uring file IO option, which batches the reads and fsyncs of concurrent callers
+	// it falls back to the standard file IO when the kernel does not support io_uring
+	IOUring
+
+	// DirectIO is the O_DIRECT file IO option, which bypasses the page cache with aligned reads and writes
+	DirectIO
 )
 
 // IOManager is an abstract IO management interface that can integrate different IO types
-// currently supports standard file IO
+// currently supports standard file IO, mmap, io_uring and direct IO
 type IOManager interface {
 	// Read reads the corresponding data from a given location in a file
 	Read([]byte, int64) (int, error)
@@ -38,21 +45,38 @@ type IOManager interface {
 	// Sync forces any writes to sync to disk
 	Sync() error
 
+	// Truncate discards the data after the given size
+	Truncate(int64) error
+
+	// Preallocate allocates the file up to the given size for the data written afterward,
+	// without changing the size of the data, the space left after the data is dropped when the file is closed
+	Preallocate(int64) error
+
 	// Close closes the file
 	Close() error
 
-	// Size gets the size of file
+	// Size gets the size of the data written to the file, which excludes the space preallocated after it
 	Size() (int64, error)
 }
 
-// NewIOManager initializes IOManager, currently only supports standard FileIO
+// SyncWriter is implemented by the IO managers that can write and sync the data together more cheaply than apart
+type SyncWriter interface {
+	// WriteSync writes the given byte array to file and forces it to sync to disk
+	WriteSync([]byte) (int, error)
+}
+
+// NewIOManager initializes IOManager of the given IO type
 func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 	switch ioType {
 	case StandardFileIO:
 		return NewFileIOManager(fileName)
 	case MemoryMap:
 		return NewMMapIOManager(fileName)
+	case IOUring:
+		return NewIOUringIOManager(fileName)
+	case DirectIO:
+		return NewDirectIOManager(fileName)
 	default:
-		panic("unsupported IO type, use standard IO or mmap")
+		panic("unsupported IO type, use standard IO, mmap, io_uring or direct IO")
 	}
 }
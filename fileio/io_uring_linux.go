@@ -0,0 +1,806 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fileio
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// the io_uring ABI of the kernel, refer to [include/uapi/linux/io_uring.h]
+const (
+	ioUringOpNop   = 0
+	ioUringOpFsync = 3
+	ioUringOpRead  = 22
+	ioUringOpWrite = 23
+
+	ioUringSqeIODrain = 1 << 1
+	ioUringSqeIOLink  = 1 << 2
+
+	ioUringEnterGetEvents = 1 << 0
+
+	ioUringFeatSingleMMap = 1 << 0
+	// ioUringFeatRWCurPos was introduced along with the read and write operations in Linux 5.6
+	ioUringFeatRWCurPos = 1 << 3
+
+	ioUringOffSqRing = 0
+	ioUringOffCqRing = 0x8000000
+	ioUringOffSqes   = 0x10000000
+)
+
+// ioUringEntries is the size of the submission queue, which bounds the number of operations submitted at once
+const ioUringEntries = 256
+
+// ioUringStopID is the user data of the operation that stops the completion loop
+const ioUringStopID = 0
+
+var errIOUringUnsupported = errors.New("io_uring does not support the read and write operations")
+
+// ioUringParams is struct io_uring_params
+type ioUringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	resv         [3]uint32
+	sqOff        ioUringSqOffsets
+	cqOff        ioUringCqOffsets
+}
+
+// ioUringSqOffsets is struct io_sqring_offsets
+type ioUringSqOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+// ioUringCqOffsets is struct io_cqring_offsets
+type ioUringCqOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+// ioUringSqe is struct io_uring_sqe, the submission queue entry
+type ioUringSqe struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFDIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+// ioUringCqe is struct io_uring_cqe, the completion queue entry
+type ioUringCqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// ioUringOp is an operation submitted to the ring
+type ioUringOp struct {
+	sqe ioUringSqe
+
+	// buffer is the memory read into or written from, referenced until the operation completes
+	buffer []byte
+
+	// pinner keeps the buffer from being moved by the garbage collector until the completion is reaped,
+	// since the kernel only knows its address
+	pinner runtime.Pinner
+
+	// linked is submitted right after the operation, and only started once it has succeeded
+	linked *ioUringOp
+
+	// res is the result of the operation, the number of bytes transferred or a negated errno
+	res int32
+
+	// done is closed once the operation has completed
+	done chan struct{}
+}
+
+// newIOUringOp creates an operation on the file descriptor at the given offset
+func newIOUringOp(opcode uint8, fd int, buffer []byte, offset int64) *ioUringOp {
+	op := &ioUringOp{
+		sqe:    ioUringSqe{opcode: opcode, fd: int32(fd), off: uint64(offset), len: uint32(len(buffer))},
+		buffer: buffer,
+		done:   make(chan struct{}),
+	}
+	if len(buffer) > 0 {
+		op.pinner.Pin(&buffer[0])
+		op.sqe.addr = uint64(uintptr(unsafe.Pointer(&buffer[0])))
+	}
+
+	return op
+}
+
+// finish unpins the buffer of the operation, sets its result and wakes up the caller waiting for it
+func (op *ioUringOp) finish(res int32) {
+	op.pinner.Unpin()
+	op.res = res
+	close(op.done)
+}
+
+// result waits for the operation and returns the number of bytes transferred
+func (op *ioUringOp) result() (int, error) {
+	<-op.done
+	if op.res < 0 {
+		return 0, unix.Errno(-op.res)
+	}
+	return int(op.res), nil
+}
+
+// ioUring is a ring shared by all the files opened with IOUring
+// the operations queued by the concurrent callers are submitted together by a single system call,
+// while their completions are reaped by another goroutine, so that a slow fsync never holds back the reads
+type ioUring struct {
+	fd int
+
+	sqRing  []byte
+	cqRing  []byte
+	sqesMem []byte
+
+	sqHead  *uint32
+	sqTail  *uint32
+	sqMask  uint32
+	sqArray []uint32
+	sqes    []ioUringSqe
+
+	cqHead *uint32
+	cqTail *uint32
+	cqMask uint32
+	cqes   []ioUringCqe
+
+	// ops are the operations waiting to be submitted
+	ops chan *ioUringOp
+
+	// slots bounds the operations in flight by the size of the completion queue
+	slots chan struct{}
+
+	// inflight are the submitted operations by their user data
+	mu       sync.Mutex
+	inflight map[uint64]*ioUringOp
+	nextID   uint64
+
+	// errno is the error that broke the ring once its completions cannot be reaped, guarded by mu
+	// the operations of a broken ring fail with it, and their files fall back to the standard file IO
+	errno unix.Errno
+
+	wg sync.WaitGroup
+
+	// refs is the number of files using the ring, guarded by sharedIOUringMu
+	refs int
+}
+
+var (
+	sharedIOUringMu sync.Mutex
+	sharedIOUring   *ioUring
+
+	// ioUringSetup creates a ring, it is replaced by the tests to simulate a kernel without io_uring
+	ioUringSetup = newIOUring
+
+	// ioUringWait waits for at least one completion of the ring, it is replaced by the tests to simulate a failure
+	ioUringWait = func(fd int) unix.Errno {
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(fd), 0, 1, ioUringEnterGetEvents, 0, 0)
+		return errno
+	}
+
+	// abandonedIOUringOps are the operations failed by a broken ring before their completions were reaped,
+	// whose buffers stay pinned for the lifetime of the process since the kernel may still use them
+	abandonedIOUringMu  sync.Mutex
+	abandonedIOUringOps []*ioUringOp
+)
+
+// acquireIOUring returns the shared ring, which is created for the first file using it
+func acquireIOUring() (*ioUring, error) {
+	sharedIOUringMu.Lock()
+	defer sharedIOUringMu.Unlock()
+
+	// a broken ring is only released by the files still using it
+	if sharedIOUring != nil && sharedIOUring.broken() {
+		sharedIOUring = nil
+	}
+	if sharedIOUring == nil {
+		ring, err := ioUringSetup(ioUringEntries)
+		if err != nil {
+			return nil, err
+		}
+		sharedIOUring = ring
+	}
+	sharedIOUring.refs++
+
+	return sharedIOUring, nil
+}
+
+// release stops the ring once the last file using it is closed
+func (r *ioUring) release() {
+	sharedIOUringMu.Lock()
+	defer sharedIOUringMu.Unlock()
+
+	r.refs--
+	if r.refs > 0 {
+		return
+	}
+
+	close(r.ops)
+	r.wg.Wait()
+	r.unmap()
+	_ = unix.Close(r.fd)
+	if sharedIOUring == r {
+		sharedIOUring = nil
+	}
+}
+
+// broken checks whether the ring has been broken, after which its files use the standard file IO
+func (r *ioUring) broken() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.errno != 0
+}
+
+// newIOUring sets up a ring and starts its submission and completion loops
+func newIOUring(entries uint32) (*ioUring, error) {
+	var params ioUringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+
+	r := &ioUring{fd: int(fd), inflight: make(map[uint64]*ioUringOp), nextID: ioUringStopID + 1}
+	if params.features&ioUringFeatRWCurPos == 0 {
+		_ = unix.Close(r.fd)
+		return nil, errIOUringUnsupported
+	}
+
+	if err := r.mmap(&params); err != nil {
+		r.unmap()
+		_ = unix.Close(r.fd)
+		return nil, err
+	}
+
+	r.ops = make(chan *ioUringOp, params.sqEntries)
+	r.slots = make(chan struct{}, params.cqEntries)
+	r.wg.Add(2)
+	go r.submitLoop()
+	go r.completeLoop()
+
+	return r, nil
+}
+
+// mmap maps the submission queue, the completion queue and the submission queue entries of the ring
+func (r *ioUring) mmap(params *ioUringParams) error {
+	sqSize := int(params.sqOff.array + params.sqEntries*uint32(unsafe.Sizeof(uint32(0))))
+	cqSize := int(params.cqOff.cqes + params.cqEntries*uint32(unsafe.Sizeof(ioUringCqe{})))
+
+	// the two queues share a single mapping since Linux 5.4
+	singleMMap := params.features&ioUringFeatSingleMMap != 0
+	if singleMMap {
+		sqSize = max(sqSize, cqSize)
+	}
+
+	var err error
+	prot, flags := unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE
+	if r.sqRing, err = unix.Mmap(r.fd, ioUringOffSqRing, sqSize, prot, flags); err != nil {
+		return err
+	}
+	r.cqRing = r.sqRing
+	if !singleMMap {
+		if r.cqRing, err = unix.Mmap(r.fd, ioUringOffCqRing, cqSize, prot, flags); err != nil {
+			return err
+		}
+	}
+	sqesSize := int(params.sqEntries) * int(unsafe.Sizeof(ioUringSqe{}))
+	if r.sqesMem, err = unix.Mmap(r.fd, ioUringOffSqes, sqesSize, prot, flags); err != nil {
+		return err
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.array])), params.sqEntries)
+	r.sqes = unsafe.Slice((*ioUringSqe)(unsafe.Pointer(&r.sqesMem[0])), params.sqEntries)
+
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*ioUringCqe)(unsafe.Pointer(&r.cqRing[params.cqOff.cqes])), params.cqEntries)
+
+	return nil
+}
+
+// unmap releases the mappings of the ring
+func (r *ioUring) unmap() {
+	if r.sqesMem != nil {
+		_ = unix.Munmap(r.sqesMem)
+	}
+	if r.cqRing != nil && len(r.cqRing) > 0 && &r.cqRing[0] != &r.sqRing[0] {
+		_ = unix.Munmap(r.cqRing)
+	}
+	if r.sqRing != nil {
+		_ = unix.Munmap(r.sqRing)
+	}
+}
+
+// do submits the operation and waits for it, along with the operation linked to it
+func (r *ioUring) do(op *ioUringOp) {
+	r.ops <- op
+	for ; op != nil; op = op.linked {
+		<-op.done
+	}
+}
+
+// submitLoop submits the queued operations, every operation queued meanwhile is submitted by the same system call
+func (r *ioUring) submitLoop() {
+	defer r.wg.Done()
+
+	for op := range r.ops {
+		tail := *r.sqTail
+		tail = r.prepare(op, tail)
+
+	batch:
+		for tail-atomic.LoadUint32(r.sqHead) <= uint32(len(r.sqes))-2 {
+			select {
+			case op, ok := <-r.ops:
+				if !ok {
+					break batch
+				}
+				tail = r.prepare(op, tail)
+			default:
+				break batch
+			}
+		}
+
+		r.submit(tail)
+	}
+
+	// the completion loop of a broken ring has already stopped
+	if r.broken() {
+		return
+	}
+
+	// the last operation starts once every other one has completed, and stops the completion loop
+	stop := newIOUringOp(ioUringOpNop, -1, nil, 0)
+	stop.sqe.flags = ioUringSqeIODrain
+	r.slots <- struct{}{}
+	r.sqes[*r.sqTail&r.sqMask] = stop.sqe
+	r.sqArray[*r.sqTail&r.sqMask] = *r.sqTail & r.sqMask
+	r.submit(*r.sqTail + 1)
+}
+
+// prepare writes the submission queue entries of the operation and the operations linked to it from the tail
+// and returns the new tail, the operations are only visible to the kernel once the tail is published
+func (r *ioUring) prepare(op *ioUringOp, tail uint32) uint32 {
+	for ; op != nil; op = op.linked {
+		// wait until the completion queue has room for the operation
+		r.slots <- struct{}{}
+
+		// the operations are not submitted any more once the ring is broken
+		r.mu.Lock()
+		if errno := r.errno; errno != 0 {
+			r.mu.Unlock()
+			<-r.slots
+			op.finish(-int32(errno))
+			continue
+		}
+		op.sqe.userData = r.nextID
+		r.inflight[r.nextID] = op
+		r.nextID++
+		r.mu.Unlock()
+
+		index := tail & r.sqMask
+		r.sqes[index] = op.sqe
+		r.sqArray[index] = index
+		tail++
+	}
+
+	return tail
+}
+
+// submit publishes the tail of the submission queue and submits the entries to the kernel
+func (r *ioUring) submit(tail uint32) {
+	atomic.StoreUint32(r.sqTail, tail)
+
+	for {
+		toSubmit := tail - atomic.LoadUint32(r.sqHead)
+		if toSubmit == 0 {
+			return
+		}
+
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(toSubmit), 0, 0, 0, 0)
+		switch errno {
+		case 0, unix.EINTR, unix.EAGAIN, unix.EBUSY:
+			// the entries not consumed yet are submitted again
+		default:
+			// the ring is broken, the entries that cannot be submitted fail with the error
+			r.fail(atomic.LoadUint32(r.sqHead), tail, errno)
+			atomic.StoreUint32(r.sqTail, atomic.LoadUint32(r.sqHead))
+			return
+		}
+	}
+}
+
+// fail completes the operations of the submission queue entries from head to tail with the error
+func (r *ioUring) fail(head, tail uint32, errno unix.Errno) {
+	for ; head != tail; head++ {
+		r.complete(r.sqes[r.sqArray[head&r.sqMask]].userData, -int32(errno))
+	}
+}
+
+// completeLoop reaps the completion queue, until the operation stopping the ring has completed
+func (r *ioUring) completeLoop() {
+	defer r.wg.Done()
+
+	for {
+		head, tail := atomic.LoadUint32(r.cqHead), atomic.LoadUint32(r.cqTail)
+		if head == tail {
+			// wait for at least one completion
+			switch errno := ioUringWait(r.fd); errno {
+			case 0, unix.EINTR, unix.EAGAIN, unix.EBUSY:
+				continue
+			default:
+				// the completions cannot be reaped any more, so the ring is broken
+				r.abandon(errno)
+				return
+			}
+		}
+
+		stopped := false
+		for ; head != tail; head++ {
+			cqe := r.cqes[head&r.cqMask]
+			if cqe.userData == ioUringStopID {
+				stopped = true
+				<-r.slots
+				continue
+			}
+			r.complete(cqe.userData, cqe.res)
+		}
+		atomic.StoreUint32(r.cqHead, head)
+
+		if stopped {
+			return
+		}
+	}
+}
+
+// complete sets the result of the operation and wakes up the caller waiting for it
+func (r *ioUring) complete(userData uint64, res int32) {
+	r.mu.Lock()
+	op := r.inflight[userData]
+	delete(r.inflight, userData)
+	r.mu.Unlock()
+
+	<-r.slots
+	op.finish(res)
+}
+
+// abandon breaks the ring, and fails the operations in flight with the error without reaping their completions
+func (r *ioUring) abandon(errno unix.Errno) {
+	r.mu.Lock()
+	r.errno = errno
+	inflight := r.inflight
+	r.inflight = make(map[uint64]*ioUringOp)
+	r.mu.Unlock()
+
+	abandonedIOUringMu.Lock()
+	for _, op := range inflight {
+		abandonedIOUringOps = append(abandonedIOUringOps, op)
+	}
+	abandonedIOUringMu.Unlock()
+
+	for _, op := range inflight {
+		<-r.slots
+		op.res = -int32(errno)
+		close(op.done)
+	}
+}
+
+// URing is a file read and written through io_uring
+// the reads of concurrent callers are submitted to the kernel together, and so are the fsyncs of concurrent writers,
+// while the appends and their fsync are submitted as a single linked operation by WriteSync
+// the file is read and written with the standard file IO once the ring is broken
+type URing struct {
+	fd   *os.File
+	ring *ioUring
+
+	// size is the size of the data written to the file, where the next append starts
+	size atomic.Int64
+
+	// writeMu serializes the appends
+	writeMu sync.Mutex
+
+	// writeSeq counts the appends, syncedSeq is the last one known to be on disk
+	writeSeq  atomic.Uint64
+	syncMu    sync.Mutex
+	syncedSeq uint64
+
+	// preallocated indicates whether the file may have been allocated beyond the data
+	preallocated bool
+
+	closed atomic.Bool
+}
+
+// NewIOUringIOManager opens a file read and written through io_uring,
+// falling back to the standard file IO when the kernel does not support it
+func NewIOUringIOManager(fileName string) (IOManager, error) {
+	ring, err := acquireIOUring()
+	if err != nil {
+		return NewFileIOManager(fileName)
+	}
+
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, DataFilePermission)
+	if err != nil {
+		ring.release()
+		return nil, err
+	}
+
+	fileInfo, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		ring.release()
+		return nil, err
+	}
+
+	u := &URing{fd: fd, ring: ring}
+	u.size.Store(fileInfo.Size())
+
+	return u, nil
+}
+
+// pathError wraps the errno of a failed operation like the os package does
+func (u *URing) pathError(op string, err error) error {
+	return &os.PathError{Op: op, Path: u.fd.Name(), Err: err}
+}
+
+// Read reads the corresponding data from a given location in a file, up to the end of the data
+func (u *URing) Read(b []byte, offset int64) (int, error) {
+	if remaining := u.size.Load() - offset; remaining < int64(len(b)) {
+		if remaining <= 0 {
+			return 0, io.EOF
+		}
+
+		numBytes, err := u.Read(b[:remaining], offset)
+		if err == nil {
+			err = io.EOF
+		}
+		return numBytes, err
+	}
+
+	numBytes := 0
+	for numBytes < len(b) {
+		op := newIOUringOp(ioUringOpRead, int(u.fd.Fd()), b[numBytes:], offset+int64(numBytes))
+		u.ring.do(op)
+
+		n, err := op.result()
+		if err != nil {
+			// the ring is broken, the rest is read with the standard file IO
+			if u.ring.broken() {
+				n, err := u.fd.ReadAt(b[numBytes:], offset+int64(numBytes))
+				return numBytes + n, err
+			}
+			return numBytes, u.pathError("read", err)
+		}
+		if n == 0 {
+			return numBytes, io.EOF
+		}
+		numBytes += n
+	}
+
+	return numBytes, nil
+}
+
+// Write appends the given byte array to file
+func (u *URing) Write(b []byte) (int, error) {
+	u.writeMu.Lock()
+	defer u.writeMu.Unlock()
+
+	return u.write(b)
+}
+
+// write appends the given byte array to file, retrying the short writes
+func (u *URing) write(b []byte) (int, error) {
+	numBytes := 0
+	for numBytes < len(b) {
+		op := newIOUringOp(ioUringOpWrite, int(u.fd.Fd()), b[numBytes:], u.size.Load())
+		u.ring.do(op)
+
+		n, err := op.result()
+		if err != nil {
+			// the ring is broken, the rest is written with the standard file IO
+			if u.ring.broken() {
+				n, err := u.fd.WriteAt(b[numBytes:], u.size.Load())
+				numBytes += n
+				u.size.Add(int64(n))
+				if err != nil {
+					return numBytes, err
+				}
+				break
+			}
+			return numBytes, u.pathError("write", err)
+		}
+		if n == 0 {
+			return numBytes, u.pathError("write", io.ErrShortWrite)
+		}
+		numBytes += n
+		u.size.Add(int64(n))
+	}
+	u.writeSeq.Add(1)
+
+	return numBytes, nil
+}
+
+// WriteSync appends the given byte array to file and syncs it,
+// submitting the write and the fsync linked together so that they take a single round trip
+func (u *URing) WriteSync(b []byte) (int, error) {
+	u.writeMu.Lock()
+
+	fd := int(u.fd.Fd())
+	write := newIOUringOp(ioUringOpWrite, fd, b, u.size.Load())
+	write.sqe.flags = ioUringSqeIOLink
+	write.linked = newIOUringOp(ioUringOpFsync, fd, nil, 0)
+	u.ring.do(write)
+
+	n, err := write.result()
+	if err != nil {
+		if u.ring.broken() {
+			return u.writeSyncFallback(b)
+		}
+		u.writeMu.Unlock()
+		return 0, u.pathError("write", err)
+	}
+	u.size.Add(int64(n))
+	seq := u.writeSeq.Add(1)
+
+	// a short write cancels the fsync, the rest is written and synced on its own
+	if n < len(b) {
+		numBytes, err := u.write(b[n:])
+		u.writeMu.Unlock()
+		if err != nil {
+			return n + numBytes, err
+		}
+		return n + numBytes, u.Sync()
+	}
+	u.writeMu.Unlock()
+
+	if _, err := write.linked.result(); err != nil {
+		if u.ring.broken() {
+			return n, u.Sync()
+		}
+		return n, u.pathError("fsync", err)
+	}
+
+	u.syncMu.Lock()
+	u.syncedSeq = max(u.syncedSeq, seq)
+	u.syncMu.Unlock()
+
+	return n, nil
+}
+
+// writeSyncFallback writes and syncs the byte array with the standard file IO once the ring is broken,
+// the write lock is held by the caller and released here
+func (u *URing) writeSyncFallback(b []byte) (int, error) {
+	numBytes, err := u.write(b)
+	u.writeMu.Unlock()
+	if err != nil {
+		return numBytes, err
+	}
+
+	return numBytes, u.Sync()
+}
+
+// Sync forces any writes to sync to disk
+// the callers waiting for a sync in progress share the next one, which covers all their writes
+func (u *URing) Sync() error {
+	target := u.writeSeq.Load()
+
+	u.syncMu.Lock()
+	defer u.syncMu.Unlock()
+
+	// a sync completed meanwhile has covered the writes
+	if u.syncedSeq >= target {
+		return nil
+	}
+
+	target = u.writeSeq.Load()
+	op := newIOUringOp(ioUringOpFsync, int(u.fd.Fd()), nil, 0)
+	u.ring.do(op)
+	if _, err := op.result(); err != nil {
+		if !u.ring.broken() {
+			return u.pathError("fsync", err)
+		}
+
+		// the ring is broken, the file is synced with the standard file IO
+		if err := u.fd.Sync(); err != nil {
+			return err
+		}
+	}
+	u.syncedSeq = target
+
+	return nil
+}
+
+// Truncate discards the data after the given size
+func (u *URing) Truncate(size int64) error {
+	u.writeMu.Lock()
+	defer u.writeMu.Unlock()
+
+	if err := u.fd.Truncate(size); err != nil {
+		return err
+	}
+	u.size.Store(size)
+
+	return nil
+}
+
+// Preallocate allocates the file up to the given size for the data written afterward
+func (u *URing) Preallocate(size int64) error {
+	u.writeMu.Lock()
+	defer u.writeMu.Unlock()
+
+	if err := preallocate(u.fd, size); err != nil {
+		return err
+	}
+	u.preallocated = true
+
+	return nil
+}
+
+// Close drops the space preallocated after the data and closes the file, the ring is only released by the first call
+func (u *URing) Close() error {
+	if !u.closed.Swap(true) {
+		u.ring.release()
+	}
+
+	if u.preallocated {
+		if err := u.fd.Truncate(u.size.Load()); err != nil {
+			_ = u.fd.Close()
+			return err
+		}
+	}
+
+	return u.fd.Close()
+}
+
+// Size gets the size of file
+func (u *URing) Size() (int64, error) {
+	return u.size.Load(), nil
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fileio
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// newTestURing opens a file through io_uring, skipping the test if the kernel does not support it
+func newTestURing(t *testing.T, path string) *URing {
+	ioManager, err := NewIOUringIOManager(path)
+	assert.Nil(t, err)
+
+	uring, ok := ioManager.(*URing)
+	if !ok {
+		_ = ioManager.Close()
+		t.Skip("io_uring is not supported by the kernel")
+	}
+
+	return uring
+}
+
+func TestURing_Read(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "uring-read")
+	defer destroyFile(path)
+
+	uringIO := newTestURing(t, path)
+
+	// test for empty file
+	value1 := make([]byte, 24)
+	numBytes1, err := uringIO.Read(value1, 0)
+	assert.Equal(t, 0, numBytes1)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = uringIO.Write([]byte("cpp"))
+	assert.Nil(t, err)
+	_, err = uringIO.Write([]byte("java"))
+	assert.Nil(t, err)
+	_, err = uringIO.Write([]byte("golang"))
+	assert.Nil(t, err)
+
+	size, err := uringIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(13), size)
+
+	value2 := make([]byte, 4)
+	numBytes2, err := uringIO.Read(value2, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, numBytes2)
+	assert.Equal(t, []byte("java"), value2)
+
+	// a read beyond the end of file is partial
+	value3 := make([]byte, 10)
+	numBytes3, err := uringIO.Read(value3, 7)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 6, numBytes3)
+	assert.Equal(t, []byte("golang"), value3[:6])
+
+	assert.Nil(t, uringIO.Close())
+}
+
+func TestURing_ConcurrentRead(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "uring-concurrent")
+	defer destroyFile(path)
+
+	uringIO := newTestURing(t, path)
+	for i := 0; i < 1000; i++ {
+		_, err := uringIO.Write([]byte(fmt.Sprintf("record-%06d", i)))
+		assert.Nil(t, err)
+	}
+
+	// the reads of many goroutines are queued to the ring at once, beyond the size of the queues
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			buffer := make([]byte, 13)
+			for i := g; i < 1000; i += 16 {
+				_, err := uringIO.Read(buffer, int64(i*13))
+				assert.Nil(t, err)
+				assert.Equal(t, fmt.Sprintf("record-%06d", i), string(buffer))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	assert.Nil(t, uringIO.Close())
+}
+
+func TestURing_WriteSync(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "uring-write-sync")
+	defer destroyFile(path)
+
+	uringIO := newTestURing(t, path)
+	numBytes, err := uringIO.WriteSync([]byte("betadb"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, numBytes)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := uringIO.Write([]byte("!"))
+			assert.Nil(t, err)
+			assert.Nil(t, uringIO.Sync())
+		}()
+	}
+	wg.Wait()
+	assert.Nil(t, uringIO.Close())
+
+	// the writes continue after the data of the reopened file
+	uringIO = newTestURing(t, path)
+	_, err = uringIO.Write([]byte("golang"))
+	assert.Nil(t, err)
+	buffer := make([]byte, 24)
+	numBytes, err = uringIO.Read(buffer, 0)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "betadb!!!!!!!!golang", string(buffer[:numBytes]))
+	assert.Nil(t, uringIO.Close())
+}
+
+func TestURing_Truncate(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "uring-truncate")
+	defer destroyFile(path)
+
+	uringIO := newTestURing(t, path)
+	_, err := uringIO.Write([]byte("betadb-torn"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, uringIO.Truncate(6))
+	size, err := uringIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+
+	_, err = uringIO.Write([]byte("!"))
+	assert.Nil(t, err)
+	buffer := make([]byte, 7)
+	_, err = uringIO.Read(buffer, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("betadb!"), buffer)
+	assert.Nil(t, uringIO.Close())
+}
+
+func TestNewIOUringIOManager_Fallback(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "uring-fallback")
+	defer destroyFile(path)
+
+	// simulate a kernel without io_uring
+	setup := ioUringSetup
+	ioUringSetup = func(uint32) (*ioUring, error) { return nil, unix.ENOSYS }
+	defer func() { ioUringSetup = setup }()
+
+	ioManager, err := NewIOManager(path, IOUring)
+	assert.Nil(t, err)
+	assert.IsType(t, &FileIO{}, ioManager)
+
+	_, err = ioManager.Write([]byte("betadb"))
+	assert.Nil(t, err)
+	assert.Nil(t, ioManager.Close())
+}
+
+func TestURing_Preallocate(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "uring-preallocate")
+	defer destroyFile(path)
+
+	checkPreallocate(t, path, IOUring)
+}
+
+func TestURing_BrokenRing(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "uring-broken")
+	defer destroyFile(path)
+
+	// simulate a ring whose completions cannot be waited for any more
+	waiting, broken := make(chan struct{}), make(chan struct{})
+	wait := ioUringWait
+	ioUringWait = func(int) unix.Errno {
+		close(waiting)
+		<-broken
+		return unix.EBADF
+	}
+	defer func() { ioUringWait = wait }()
+
+	uringIO := newTestURing(t, path)
+	<-waiting
+
+	// the write in flight fails with the ring, and falls back to the standard file IO
+	written := make(chan error)
+	go func() {
+		_, err := uringIO.Write([]byte("cpp"))
+		written <- err
+	}()
+	for inflight := 0; inflight == 0; {
+		uringIO.ring.mu.Lock()
+		inflight = len(uringIO.ring.inflight)
+		uringIO.ring.mu.Unlock()
+	}
+	close(broken)
+	assert.Nil(t, <-written)
+	assert.True(t, uringIO.ring.broken())
+
+	// the operations after the ring is broken use the standard file IO as well
+	numBytes, err := uringIO.WriteSync([]byte("golang"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, numBytes)
+	_, err = uringIO.Write([]byte("java"))
+	assert.Nil(t, err)
+	assert.Nil(t, uringIO.Sync())
+
+	value := make([]byte, 13)
+	numBytes, err = uringIO.Read(value, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 13, numBytes)
+	assert.Equal(t, []byte("cppgolangjava"), value)
+	assert.Nil(t, uringIO.Close())
+
+	// a file opened afterward gets a new ring
+	ioUringWait = wait
+	uringIO = newTestURing(t, path)
+	assert.False(t, uringIO.ring.broken())
+	_, err = uringIO.Read(value, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("cppgolangjava"), value)
+	assert.Nil(t, uringIO.Close())
+}
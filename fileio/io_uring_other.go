@@ -0,0 +1,20 @@
+//go:build !linux
+
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fileio
+
+// NewIOUringIOManager falls back to the standard file IO, since io_uring is only available on Linux
+func NewIOUringIOManager(fileName string) (IOManager, error) {
+	return NewFileIOManager(fileName)
+}
@@ -13,49 +13,207 @@
 package fileio
 
 import (
-	"golang.org/x/exp/mmap"
+	"golang.org/x/sys/unix"
+	"io"
 	"os"
+	"sync"
 )
 
-// MMap defines the mapping for memory and file
+// mmapChunkSize is the size by which the file and its mapping grow,
+// so that the file is not remapped for every write
+const mmapChunkSize = 4 * 1024 * 1024
+
+// MMap is a file mapped into memory for both reads and writes
+// the file is preallocated in chunks beyond the data written, and truncated to the size of the data when closed
 type MMap struct {
-	readerAt *mmap.ReaderAt
+	fd *os.File
+
+	// data is the mapping of the file, which may be longer than the data written
+	data []byte
+
+	// size is the size of the data written to the file
+	size int64
+
+	// synced indicates whether the file has not grown since the last sync
+	synced bool
+
+	// mu protects the mapping, which is replaced when the file grows
+	mu sync.RWMutex
 }
 
+// NewMMapIOManager maps a file into memory, the mapping only grows beyond the file once it is written to
 func NewMMapIOManager(fileName string) (*MMap, error) {
-	_, err := os.OpenFile(fileName, os.O_CREATE, DataFilePermission)
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, DataFilePermission)
 	if err != nil {
 		return nil, err
 	}
 
-	readerAt, err := mmap.Open(fileName)
+	fileInfo, err := fd.Stat()
 	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+
+	m := &MMap{fd: fd, size: fileInfo.Size(), synced: true}
+	if err := m.remap(m.size); err != nil {
+		_ = fd.Close()
 		return nil, err
 	}
 
-	return &MMap{
-		readerAt: readerAt,
-	}, nil
+	return m, nil
 }
 
+// remap replaces the mapping with one of the given length
+func (m *MMap) remap(length int64) error {
+	if m.data != nil {
+		if err := unix.Munmap(m.data); err != nil {
+			return err
+		}
+		m.data = nil
+	}
+
+	// an empty mapping is not allowed
+	if length == 0 {
+		return nil
+	}
+
+	data, err := unix.Mmap(int(m.fd.Fd()), 0, int(length), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	m.data = data
+
+	return nil
+}
+
+// Read reads the corresponding data from a given location in a file
 func (m *MMap) Read(b []byte, offset int64) (int, error) {
-	return m.readerAt.ReadAt(b, offset)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if offset >= m.size {
+		return 0, io.EOF
+	}
+
+	numBytes := copy(b, m.data[offset:m.size])
+	if numBytes < len(b) {
+		return numBytes, io.EOF
+	}
+
+	return numBytes, nil
 }
 
-func (m *MMap) Write([]byte) (int, error) {
-	// TODO
-	panic("not implemented")
+// Write appends the given byte array to the data of the file, growing the file and its mapping if needed
+func (m *MMap) Write(b []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if end := m.size + int64(len(b)); end > int64(len(m.data)) {
+		// the file is extended before the mapping, since touching a mapping beyond the file fails
+		length := (end + mmapChunkSize - 1) / mmapChunkSize * mmapChunkSize
+		if err := m.fd.Truncate(length); err != nil {
+			return 0, err
+		}
+		if err := m.remap(length); err != nil {
+			return 0, err
+		}
+		m.synced = false
+	}
+
+	numBytes := copy(m.data[m.size:], b)
+	m.size += int64(numBytes)
+
+	return numBytes, nil
 }
 
+// Sync flushes the mapping to disk with msync, and the size of the file if it has grown since the last sync
 func (m *MMap) Sync() error {
-	// TODO
-	panic("not implemented")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.data != nil {
+		if err := unix.Msync(m.data, unix.MS_SYNC); err != nil {
+			return err
+		}
+	}
+
+	if !m.synced {
+		if err := m.fd.Sync(); err != nil {
+			return err
+		}
+		m.synced = true
+	}
+
+	return nil
+}
+
+// Truncate discards the data after the given size
+func (m *MMap) Truncate(size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.remap(0); err != nil {
+		return err
+	}
+	if err := m.fd.Truncate(size); err != nil {
+		return err
+	}
+	m.size = size
+
+	return m.remap(size)
 }
 
+// Preallocate allocates the file up to the given size, and maps it for the writes to come
+func (m *MMap) Preallocate(size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := preallocate(m.fd, size); err != nil {
+		return err
+	}
+
+	// the file is only extended if its file system supports preallocation
+	fileInfo, err := m.fd.Stat()
+	if err != nil {
+		return err
+	}
+	if fileInfo.Size() > int64(len(m.data)) {
+		if err := m.remap(fileInfo.Size()); err != nil {
+			return err
+		}
+		m.synced = false
+	}
+
+	return nil
+}
+
+// Close unmaps the file and truncates it to the size of the data
 func (m *MMap) Close() error {
-	return m.readerAt.Close()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.remap(0); err != nil {
+		return err
+	}
+
+	// drop the preallocated chunk after the data
+	fileInfo, err := m.fd.Stat()
+	if err != nil {
+		return err
+	}
+	if fileInfo.Size() != m.size {
+		if err := m.fd.Truncate(m.size); err != nil {
+			return err
+		}
+	}
+
+	return m.fd.Close()
 }
 
+// Size gets the size of the data written to the file
 func (m *MMap) Size() (int64, error) {
-	return int64(m.readerAt.Len()), nil
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.size, nil
 }
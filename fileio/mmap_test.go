@@ -55,3 +55,95 @@ func TestMMap_Read(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 2, numBytes2)
 }
+
+func TestMMap_Write(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "mmap-write")
+	defer destroyFile(path)
+
+	mmapIO, err := NewMMapIOManager(path)
+	assert.Nil(t, err)
+
+	numBytes, err := mmapIO.Write([]byte("betadb"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, numBytes)
+
+	// a write larger than a chunk grows the mapping more than once
+	value := make([]byte, mmapChunkSize+100)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	_, err = mmapIO.Write(value)
+	assert.Nil(t, err)
+
+	size, err := mmapIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(value)+6), size)
+
+	// the file is preallocated beyond the data
+	fileInfo, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2*mmapChunkSize), fileInfo.Size())
+
+	buffer := make([]byte, 6)
+	_, err = mmapIO.Read(buffer, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("betadb"), buffer)
+
+	buffer = make([]byte, 10)
+	numBytes, err = mmapIO.Read(buffer, size-5)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 5, numBytes)
+	assert.Equal(t, value[len(value)-5:], buffer[:5])
+
+	assert.Nil(t, mmapIO.Sync())
+
+	// the file is truncated to the data when closed
+	assert.Nil(t, mmapIO.Close())
+	fileInfo, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, size, fileInfo.Size())
+
+	// the writes continue after the data of the reopened file
+	mmapIO, err = NewMMapIOManager(path)
+	assert.Nil(t, err)
+	_, err = mmapIO.Write([]byte("golang"))
+	assert.Nil(t, err)
+	buffer = make([]byte, 12)
+	_, err = mmapIO.Read(buffer, size-6)
+	assert.Nil(t, err)
+	assert.Equal(t, append(value[len(value)-6:], "golang"...), buffer)
+	assert.Nil(t, mmapIO.Close())
+}
+
+func TestMMap_Truncate(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "mmap-truncate")
+	defer destroyFile(path)
+
+	mmapIO, err := NewMMapIOManager(path)
+	assert.Nil(t, err)
+	_, err = mmapIO.Write([]byte("betadb-torn"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, mmapIO.Truncate(6))
+	size, err := mmapIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+	fileInfo, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), fileInfo.Size())
+
+	_, err = mmapIO.Write([]byte("!"))
+	assert.Nil(t, err)
+	buffer := make([]byte, 7)
+	_, err = mmapIO.Read(buffer, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("betadb!"), buffer)
+	assert.Nil(t, mmapIO.Close())
+}
+
+func TestMMap_Preallocate(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "mmap-preallocate")
+	defer destroyFile(path)
+
+	checkPreallocate(t, path, MemoryMap)
+}
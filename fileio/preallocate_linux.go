@@ -0,0 +1,29 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fileio
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// preallocate allocates the blocks of the file up to the given size with fallocate, extending the file if needed
+// the file is left as it is if its file system does not support fallocate
+func preallocate(file *os.File, size int64) error {
+	err := unix.Fallocate(int(file.Fd()), 0, 0, size)
+	if err == unix.EOPNOTSUPP {
+		return nil
+	}
+
+	return err
+}
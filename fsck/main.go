@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/LiuShuoJiang/betadb"
+	"os"
+)
+
+const usage = `usage: fsck <command> <directory>
+
+commands:
+  check   validate every file of a closed data directory without modifying it
+  repair  salvage every readable record into a fresh directory next to the data directory
+  upgrade rewrite the data files written in the earlier format without a header
+`
+
+func main() {
+	flag.Usage = func() {
+		_, _ = fmt.Fprint(flag.CommandLine.Output(), usage)
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	command, directory := flag.Arg(0), flag.Arg(1)
+	switch command {
+	case "check":
+		os.Exit(check(directory))
+	case "repair":
+		os.Exit(repair(directory))
+	case "upgrade":
+		os.Exit(upgrade(directory))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// check prints the problems found in the directory, and returns a non-zero exit code if there is any
+func check(directory string) int {
+	report, err := betadb.Check(directory)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to check %s: %v\n", directory, err)
+		return 1
+	}
+
+	for _, issue := range report.Issues {
+		fmt.Println(issue)
+	}
+	fmt.Printf("checked %d files and %d records, found %d issues\n",
+		report.FileNum, report.RecordNum, len(report.Issues))
+
+	if !report.Healthy() {
+		return 1
+	}
+	return 0
+}
+
+// repair salvages the directory, and prints where the salvaged data is
+func repair(directory string) int {
+	report, err := betadb.Repair(directory)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to repair %s: %v\n", directory, err)
+		return 1
+	}
+
+	fmt.Printf("salvaged %d records and %d keys into %s, skipped %d unreadable bytes\n",
+		report.RecordNum, report.KeyNum, report.DirectoryPath, report.SkippedSize)
+	return 0
+}
+
+// upgrade rewrites the data files of the earlier format, and prints how many of them have been rewritten
+func upgrade(directory string) int {
+	report, err := betadb.Upgrade(directory)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to upgrade %s: %v\n", directory, err)
+		return 1
+	}
+
+	fmt.Printf("upgraded %d data files\n", report.FileNum)
+	return 0
+}
@@ -5,11 +5,10 @@ go 1.22
 require (
 	github.com/gofrs/flock v0.12.0
 	github.com/google/btree v1.1.2
-	github.com/plar/go-adaptive-radix-tree v1.0.5
 	github.com/stretchr/testify v1.9.0
 	github.com/tidwall/redcon v1.6.2
 	go.etcd.io/bbolt v1.3.10
-	golang.org/x/exp v0.0.0-20240707233637-46b078467d37
+	golang.org/x/sys v0.21.0
 )
 
 require (
@@ -19,6 +18,6 @@ require (
 	github.com/rogpeppe/go-internal v1.12.0 // indirect
 	github.com/tidwall/btree v1.1.0 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
-	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
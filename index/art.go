@@ -13,67 +13,67 @@
 package index
 
 import (
-	"bytes"
 	"github.com/LiuShuoJiang/betadb/data"
-	goART "github.com/plar/go-adaptive-radix-tree"
-	"sort"
 	"sync"
 )
 
-// AdaptiveRadixTree defines an ART index
+// AdaptiveRadixTree defines the radix tree index, which keeps the name of the ART index it replaced
 //
-// refer to [https://github.com/plar/go-adaptive-radix-tree]
+// unlike an ART, it is a plain radix tree with path compression, whose nodes keep as many children as they have
+// instead of growing through the fixed node sizes of an ART, and are copied on write.
+// go-adaptive-radix-tree could neither clone a tree nor seek to a key, so its iterators had to copy
+// the whole range up front, while the clones of this tree are taken in constant time and walked in batches
 type AdaptiveRadixTree struct {
-	tree goART.Tree
+	tree *radixTree
 	lock *sync.RWMutex
 }
 
 func NewART() *AdaptiveRadixTree {
 	return &AdaptiveRadixTree{
-		tree: goART.New(),
+		tree: newRadixTree(),
 		lock: new(sync.RWMutex),
 	}
 }
 
 func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	art.lock.Lock()
-	oldValue, _ := art.tree.Insert(key, pos)
+	oldItem := art.tree.put(&Item{key: key, pos: pos})
 	art.lock.Unlock()
 
-	if oldValue == nil {
+	if oldItem == nil {
 		return nil
 	}
 
-	return oldValue.(*data.LogRecordPos)
+	return oldItem.pos
 }
 
 func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
 	art.lock.RLock()
 	defer art.lock.RUnlock()
 
-	value, found := art.tree.Search(key)
-	if !found {
+	item := art.tree.get(key)
+	if item == nil {
 		return nil
 	}
 
-	return value.(*data.LogRecordPos)
+	return item.pos
 }
 
 func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	art.lock.Lock()
-	oldValue, deleted := art.tree.Delete(key)
+	oldItem := art.tree.delete(key)
 	art.lock.Unlock()
 
-	if oldValue == nil {
+	if oldItem == nil {
 		return nil, false
 	}
 
-	return oldValue.(*data.LogRecordPos), deleted
+	return oldItem.pos, true
 }
 
 func (art *AdaptiveRadixTree) Size() int {
 	art.lock.RLock()
-	size := art.tree.Size()
+	size := art.tree.size
 	art.lock.RUnlock()
 
 	return size
@@ -83,89 +83,36 @@ func (art *AdaptiveRadixTree) Close() error {
 	return nil
 }
 
-func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
-	art.lock.RLock()
-	defer art.lock.RUnlock()
-
-	return newARTIterator(art.tree, reverse)
-}
-
-// artIterator defines an ART index iterator
-type artIterator struct {
-	// currentIndex defines the current iterating position
-	currentIndex int
-
-	// reverse indicates iterating backwards or not
-	reverse bool
-
-	// values contains the key and position information
-	values []*Item
-}
-
-func newARTIterator(tree goART.Tree, reverse bool) *artIterator {
-	var index int
-	if reverse {
-		index = tree.Size() - 1
-	}
-
-	values := make([]*Item, tree.Size())
-	saveValues := func(node goART.Node) bool {
-		item := &Item{
-			key: node.Key(),
-			pos: node.Value().(*data.LogRecordPos),
-		}
-
-		values[index] = item
-
-		if reverse {
-			index--
-		} else {
-			index++
-		}
-		return true
-	}
-
-	tree.ForEach(saveValues)
-
-	return &artIterator{
-		currentIndex: 0,
-		reverse:      reverse,
-		values:       values,
-	}
-}
-
-func (arti *artIterator) Rewind() {
-	arti.currentIndex = 0
-}
+// Snapshot clones the ART in constant time, the nodes are shared and copied on write afterwards
+func (art *AdaptiveRadixTree) Snapshot() Indexer {
+	art.lock.Lock()
+	defer art.lock.Unlock()
 
-func (arti *artIterator) Seek(key []byte) {
-	if arti.reverse {
-		arti.currentIndex = sort.Search(len(arti.values), func(i int) bool {
-			return bytes.Compare(arti.values[i].key, key) <= 0
-		})
-	} else {
-		arti.currentIndex = sort.Search(len(arti.values), func(i int) bool {
-			return bytes.Compare(arti.values[i].key, key) >= 0
-		})
+	return &AdaptiveRadixTree{
+		tree: art.tree.clone(),
+		lock: new(sync.RWMutex),
 	}
 }
 
-func (arti *artIterator) Next() {
-	arti.currentIndex += 1
+func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
+	return art.RangeIterator(RangeOptions{Reverse: reverse})
 }
 
-func (arti *artIterator) Valid() bool {
-	return arti.currentIndex < len(arti.values)
-}
+// RangeIterator walks a clone of the tree taken in constant time, loading a small batch of items at a time
+// like the BTree iterator, so the memory used does not depend on the size of the range
+func (art *AdaptiveRadixTree) RangeIterator(options RangeOptions) Iterator {
+	art.lock.Lock()
+	tree := art.tree.clone()
+	art.lock.Unlock()
 
-func (arti *artIterator) Key() []byte {
-	return arti.values[arti.currentIndex].key
+	return newBTreeIterator(tree, options)
 }
 
-func (arti *artIterator) Value() *data.LogRecordPos {
-	return arti.values[arti.currentIndex].pos
-}
+// RangeKeys walks the tree itself under the read lock, so the nodes stay owned by the tree
+// and are modified in place afterward, while a clone would make the next writes copy them
+func (art *AdaptiveRadixTree) RangeKeys(options RangeOptions) [][]byte {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
 
-func (arti *artIterator) Close() {
-	arti.values = nil
+	return iteratorKeys(newBTreeIterator(art.tree, options))
 }
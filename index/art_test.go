@@ -14,7 +14,9 @@ package index
 
 import (
 	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/utils"
 	"github.com/stretchr/testify/assert"
+	"runtime"
 	"testing"
 )
 
@@ -92,3 +94,114 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestAdaptiveRadixTree_Snapshot(t *testing.T) {
+	art := NewART()
+	art.Put([]byte("cpp"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	art.Put([]byte("java"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	snapshot := art.Snapshot()
+	defer func() {
+		_ = snapshot.Close()
+	}()
+
+	// the writes after the snapshot are invisible to it
+	art.Put([]byte("cpp"), &data.LogRecordPos{Fid: 2, Offset: 30})
+	art.Put([]byte("golang"), &data.LogRecordPos{Fid: 2, Offset: 40})
+	art.Delete([]byte("java"))
+
+	assert.Equal(t, 2, snapshot.Size())
+	assert.Equal(t, int64(10), snapshot.Get([]byte("cpp")).Offset)
+	assert.NotNil(t, snapshot.Get([]byte("java")))
+	assert.Nil(t, snapshot.Get([]byte("golang")))
+}
+
+func TestAdaptiveRadixTree_RangeIterator(t *testing.T) {
+	art := NewART()
+	art.Put([]byte("apple"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	art.Put([]byte("banana"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	art.Put([]byte("bandana"), &data.LogRecordPos{Fid: 1, Offset: 30})
+	art.Put([]byte("bank"), &data.LogRecordPos{Fid: 1, Offset: 40})
+	art.Put([]byte("cherry"), &data.LogRecordPos{Fid: 1, Offset: 50})
+
+	collect := func(iter Iterator) []string {
+		defer iter.Close()
+
+		var keys []string
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	assert.Equal(t, []string{"banana", "bandana", "bank"}, collect(art.RangeIterator(RangeOptions{Prefix: []byte("ban")})))
+	assert.Equal(t, []string{"bank", "bandana", "banana"}, collect(art.RangeIterator(RangeOptions{Prefix: []byte("ban"), Reverse: true})))
+	assert.Equal(t, []string{"banana", "bandana"}, collect(art.RangeIterator(RangeOptions{LowerBound: []byte("b"), UpperBound: []byte("bank")})))
+	assert.Equal(t, []string{"bank", "cherry"}, collect(art.RangeIterator(RangeOptions{LowerBound: []byte("bank")})))
+	assert.Equal(t, []string{"apple"}, collect(art.RangeIterator(RangeOptions{UpperBound: []byte("b"), Reverse: true})))
+	assert.Nil(t, collect(art.RangeIterator(RangeOptions{Prefix: []byte("ban"), LowerBound: []byte("c")})))
+}
+
+func TestAdaptiveRadixTree_RangeKeys(t *testing.T) {
+	art := NewART()
+	art.Put([]byte("apple"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	art.Put([]byte("banana"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	art.Put([]byte("bank"), &data.LogRecordPos{Fid: 1, Offset: 30})
+	art.Put([]byte("cherry"), &data.LogRecordPos{Fid: 1, Offset: 40})
+
+	owner := art.tree.owner
+	keys := art.RangeKeys(RangeOptions{LowerBound: []byte("b"), UpperBound: []byte("c")})
+	assert.Equal(t, [][]byte{[]byte("banana"), []byte("bank")}, keys)
+
+	// no clone is taken, so the keys are deleted from the nodes in place
+	assert.Same(t, owner, art.tree.owner)
+	for _, key := range keys {
+		_, ok := art.Delete(key)
+		assert.True(t, ok)
+	}
+	assert.Same(t, owner, art.tree.owner)
+	assert.Equal(t, [][]byte{[]byte("apple"), []byte("cherry")}, art.RangeKeys(RangeOptions{}))
+}
+
+func TestAdaptiveRadixTree_RangeIteratorMemory(t *testing.T) {
+	art := NewART()
+	for i := 0; i < 100000; i++ {
+		art.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// the memory allocated to start iterating does not depend on the size of the range
+	allocated := func(options RangeOptions) uint64 {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+
+		iter := art.RangeIterator(options)
+		for i := 0; i < 10 && iter.Valid(); i++ {
+			iter.Next()
+		}
+		iter.Close()
+
+		runtime.ReadMemStats(&after)
+		return after.TotalAlloc - before.TotalAlloc
+	}
+	for _, reverse := range []bool{false, true} {
+		small := allocated(RangeOptions{
+			LowerBound: utils.GetTestKey(50000),
+			UpperBound: utils.GetTestKey(51000),
+			Reverse:    reverse,
+		})
+		assert.LessOrEqual(t, allocated(RangeOptions{Reverse: reverse}), small*2)
+		assert.LessOrEqual(t, allocated(RangeOptions{LowerBound: utils.GetTestKey(1), Reverse: reverse}), small*2)
+	}
+
+	// only a batch of items is loaded at a time
+	for _, reverse := range []bool{false, true} {
+		iter := art.Iterator(reverse).(*bTreeIterator)
+		count := 0
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			assert.LessOrEqual(t, len(iter.values), bTreeIteratorBatchSize)
+			count++
+		}
+		assert.Equal(t, 100000, count)
+		iter.Close()
+	}
+}
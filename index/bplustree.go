@@ -13,12 +13,19 @@
 package index
 
 import (
+	"bytes"
 	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/google/btree"
 	"go.etcd.io/bbolt"
+	"math"
+	"os"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
-const bPlusTreeIndexFileName = "bptree-index"
+// BPlusTreeIndexFileName is the name of the file of the B+ tree index in the data directory
+const BPlusTreeIndexFileName = "bptree-index"
 
 var indexBucketName = []byte("betadb-index")
 
@@ -27,6 +34,45 @@ var indexBucketName = []byte("betadb-index")
 // refer to [https://github.com/etcd-io/bbolt]
 type BPlusTree struct {
 	tree *bbolt.DB
+
+	// lock orders the writes with the reads of the snapshots, which combine the tree with the history
+	lock *sync.RWMutex
+
+	// size is the number of keys in the tree
+	size int
+
+	// version is the number of writes applied to the tree since it is opened
+	version uint64
+
+	// history keeps the positions overwritten by the writes while there are open snapshots,
+	// ordered by the key and then by the version of the write
+	history *btree.BTree
+
+	// historyQueue lists the records of the history in the order of the writes, to drop them once no snapshot needs them
+	historyQueue []*historyRecord
+
+	// snapshots counts the open snapshots of each version
+	snapshots map[uint64]int
+}
+
+// historyRecord is the position of a key before a write
+type historyRecord struct {
+	key []byte
+
+	// version is the version of the write
+	version uint64
+
+	// pos is the position before the write, null if the key did not exist
+	pos *data.LogRecordPos
+}
+
+// Less compares the current record with the right-hand side record, by the key and then by the version
+func (r *historyRecord) Less(rhs btree.Item) bool {
+	other := rhs.(*historyRecord)
+	if c := bytes.Compare(r.key, other.key); c != 0 {
+		return c < 0
+	}
+	return r.version < other.version
 }
 
 // NewBPlusTree initialize a new BPlusTree index
@@ -34,7 +80,7 @@ func NewBPlusTree(directoryPath string, syncWrites bool) *BPlusTree {
 	options := bbolt.DefaultOptions
 	options.NoSync = !syncWrites
 
-	bPTree, err := bbolt.Open(filepath.Join(directoryPath, bPlusTreeIndexFileName), 0644, options)
+	bPTree, err := bbolt.Open(filepath.Join(directoryPath, BPlusTreeIndexFileName), 0644, options)
 	if err != nil {
 		panic("failed to open BPlusTree!")
 	}
@@ -47,27 +93,77 @@ func NewBPlusTree(directoryPath string, syncWrites bool) *BPlusTree {
 		panic("failed to create buckets in BPlusTree!")
 	}
 
-	return &BPlusTree{tree: bPTree}
+	var size int
+	if err := bPTree.View(func(tx *bbolt.Tx) error {
+		size = tx.Bucket(indexBucketName).Stats().KeyN
+		return nil
+	}); err != nil {
+		panic("failed to get the size of BPlusTree")
+	}
+
+	return &BPlusTree{
+		tree:      bPTree,
+		lock:      new(sync.RWMutex),
+		size:      size,
+		history:   btree.New(32),
+		snapshots: make(map[uint64]int),
+	}
+}
+
+// ForEachBPlusTreeEntry reads every entry of the B+ tree index file in the directory without modifying it
+// the iteration stops once fn returns false, and os.ErrNotExist is returned if there is no index file
+func ForEachBPlusTreeEntry(directoryPath string, fn func(key []byte, pos *data.LogRecordPos) bool) error {
+	fileName := filepath.Join(directoryPath, BPlusTreeIndexFileName)
+	if _, err := os.Stat(fileName); err != nil {
+		return err
+	}
+
+	bPTree, err := bbolt.Open(fileName, 0644, &bbolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = bPTree.Close()
+	}()
+
+	return bPTree.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			if !fn(key, data.DecodeLogRecordPos(value)) {
+				break
+			}
+		}
+		return nil
+	})
 }
 
 func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
-	// no need to add lock here, since BPlusTree has used lock for us
-	var oldValue []byte
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
 
+	var oldPos *data.LogRecordPos
 	err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
-		oldValue = bucket.Get(key)
+		if oldValue := bucket.Get(key); len(oldValue) != 0 {
+			oldPos = data.DecodeLogRecordPos(oldValue)
+		}
 		return bucket.Put(key, data.EncodeLogRecordPos(pos))
 	})
 	if err != nil {
 		panic("failed to put the value in BPlusTree!")
 	}
 
-	if len(oldValue) == 0 {
-		return nil
+	if oldPos == nil {
+		bpt.size++
 	}
+	bpt.recordHistory(key, oldPos)
 
-	return data.DecodeLogRecordPos(oldValue)
+	return oldPos
 }
 
 func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
@@ -89,11 +185,14 @@ func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 }
 
 func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
-	var oldValue []byte
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
 
+	var oldPos *data.LogRecordPos
 	err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
-		if oldValue = bucket.Get(key); len(oldValue) != 0 {
+		if oldValue := bucket.Get(key); len(oldValue) != 0 {
+			oldPos = data.DecodeLogRecordPos(oldValue)
 			return bucket.Delete(key)
 		}
 
@@ -103,26 +202,80 @@ func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 		panic("failed to delete the value in BPlusTree")
 	}
 
-	if len(oldValue) == 0 {
+	if oldPos == nil {
 		return nil, false
 	}
 
-	return data.DecodeLogRecordPos(oldValue), true
+	bpt.size--
+	bpt.recordHistory(key, oldPos)
+
+	return oldPos, true
 }
 
 func (bpt *BPlusTree) Size() int {
-	var size int
+	bpt.lock.RLock()
+	defer bpt.lock.RUnlock()
 
-	err := bpt.tree.View(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(indexBucketName)
-		size = bucket.Stats().KeyN
-		return nil
+	return bpt.size
+}
+
+// recordHistory keeps the position of the key before the write for the open snapshots
+// must hold a mutex lock before accessing this method
+func (bpt *BPlusTree) recordHistory(key []byte, oldPos *data.LogRecordPos) {
+	bpt.version++
+	if len(bpt.snapshots) == 0 {
+		return
+	}
+
+	record := &historyRecord{
+		key:     append([]byte(nil), key...),
+		version: bpt.version,
+		pos:     oldPos,
+	}
+	bpt.history.ReplaceOrInsert(record)
+	bpt.historyQueue = append(bpt.historyQueue, record)
+}
+
+// historyAt finds the position of the key in the snapshot of the version,
+// which is kept by the first write to the key after the snapshot
+// found is false if the key has not been written since, must hold a mutex lock before accessing this method
+func (bpt *BPlusTree) historyAt(key []byte, version uint64) (pos *data.LogRecordPos, found bool) {
+	bpt.history.AscendGreaterOrEqual(&historyRecord{key: key, version: version + 1}, func(it btree.Item) bool {
+		record := it.(*historyRecord)
+		if bytes.Equal(record.key, key) {
+			pos, found = record.pos, true
+		}
+		return false
 	})
-	if err != nil {
-		panic("failed to get the size of BPlusTree")
+
+	return pos, found
+}
+
+// releaseSnapshot unregisters a snapshot, and drops the history that no open snapshot needs any more
+func (bpt *BPlusTree) releaseSnapshot(version uint64) {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+
+	if bpt.snapshots[version]--; bpt.snapshots[version] == 0 {
+		delete(bpt.snapshots, version)
+	}
+
+	if len(bpt.snapshots) == 0 {
+		bpt.history.Clear(false)
+		bpt.historyQueue = nil
+		return
 	}
 
-	return size
+	// a write is only needed by the snapshots older than it
+	oldestVersion := uint64(math.MaxUint64)
+	for version := range bpt.snapshots {
+		oldestVersion = min(oldestVersion, version)
+	}
+	for len(bpt.historyQueue) > 0 && bpt.historyQueue[0].version <= oldestVersion {
+		bpt.history.Delete(bpt.historyQueue[0])
+		bpt.historyQueue[0] = nil
+		bpt.historyQueue = bpt.historyQueue[1:]
+	}
 }
 
 func (bpt *BPlusTree) Close() error {
@@ -130,28 +283,255 @@ func (bpt *BPlusTree) Close() error {
 }
 
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
-	return newBPlusTreeIterator(bpt.tree, reverse)
+	return bpt.RangeIterator(RangeOptions{Reverse: reverse})
+}
+
+func (bpt *BPlusTree) RangeIterator(options RangeOptions) Iterator {
+	return newBPlusTreeIterator(bpt.tree, options)
+}
+
+func (bpt *BPlusTree) RangeKeys(options RangeOptions) [][]byte {
+	return iteratorKeys(bpt.RangeIterator(options))
+}
+
+// Snapshot returns a view of the B+ tree at the current version in constant time
+//
+// a long-lived read transaction of bbolt would block the writers from remapping the file,
+// so the snapshot reads the tree with short read transactions, and finds the positions written since
+// in the history that the writes keep while the snapshot is open
+func (bpt *BPlusTree) Snapshot() Indexer {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+
+	return bpt.newSnapshot(bpt.version, bpt.size)
+}
+
+// newSnapshot registers a snapshot of the version
+// must hold a mutex lock before accessing this method
+func (bpt *BPlusTree) newSnapshot(version uint64, size int) *bPlusTreeSnapshot {
+	bpt.snapshots[version]++
+
+	return &bPlusTreeSnapshot{
+		bpt:     bpt,
+		version: version,
+		size:    size,
+	}
+}
+
+// bPlusTreeSnapshot is a read-only view of a B+ tree at a version
+type bPlusTreeSnapshot struct {
+	bpt     *BPlusTree
+	version uint64
+
+	// size is the number of keys at the version
+	size int
+
+	closed bool
+	mu     sync.Mutex
+}
+
+func (s *bPlusTreeSnapshot) Put([]byte, *data.LogRecordPos) *data.LogRecordPos {
+	panic("the snapshot of BPlusTree is read-only!")
+}
+
+func (s *bPlusTreeSnapshot) Get(key []byte) *data.LogRecordPos {
+	s.bpt.lock.RLock()
+	defer s.bpt.lock.RUnlock()
+
+	if pos, found := s.bpt.historyAt(key, s.version); found {
+		return pos
+	}
+
+	var pos *data.LogRecordPos
+	err := s.bpt.tree.View(func(tx *bbolt.Tx) error {
+		if value := tx.Bucket(indexBucketName).Get(key); len(value) != 0 {
+			pos = data.DecodeLogRecordPos(value)
+		}
+		return nil
+	})
+	if err != nil {
+		panic("failed to get the value int BPlusTree!")
+	}
+
+	return pos
+}
+
+func (s *bPlusTreeSnapshot) Delete([]byte) (*data.LogRecordPos, bool) {
+	panic("the snapshot of BPlusTree is read-only!")
+}
+
+func (s *bPlusTreeSnapshot) Size() int {
+	return s.size
+}
+
+func (s *bPlusTreeSnapshot) Iterator(reverse bool) Iterator {
+	return s.RangeIterator(RangeOptions{Reverse: reverse})
+}
+
+// RangeIterator loads a small batch of items at a time like the BTree iterator,
+// each batch is read within a short read transaction
+func (s *bPlusTreeSnapshot) RangeIterator(options RangeOptions) Iterator {
+	return newBTreeIterator(s, options)
+}
+
+func (s *bPlusTreeSnapshot) RangeKeys(options RangeOptions) [][]byte {
+	return iteratorKeys(s.RangeIterator(options))
+}
+
+func (s *bPlusTreeSnapshot) Snapshot() Indexer {
+	s.bpt.lock.Lock()
+	defer s.bpt.lock.Unlock()
+
+	return s.bpt.newSnapshot(s.version, s.size)
+}
+
+// Close releases the history kept for the snapshot, it is safe to call Close more than once
+func (s *bPlusTreeSnapshot) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		s.bpt.releaseSnapshot(s.version)
+	}
+
+	return nil
+}
+
+// Ascend calls the iterator for every item in ascending order until it returns false
+func (s *bPlusTreeSnapshot) Ascend(iterator btree.ItemIterator) {
+	s.walk(nil, false, iterator)
+}
+
+// AscendGreaterOrEqual calls the iterator for every item greater than or equal to the pivot in ascending order
+func (s *bPlusTreeSnapshot) AscendGreaterOrEqual(pivot btree.Item, iterator btree.ItemIterator) {
+	s.walk(pivot.(*Item).key, false, iterator)
+}
+
+// Descend calls the iterator for every item in descending order until it returns false
+func (s *bPlusTreeSnapshot) Descend(iterator btree.ItemIterator) {
+	s.walk(nil, true, iterator)
+}
+
+// DescendLessOrEqual calls the iterator for every item less than or equal to the pivot in descending order
+func (s *bPlusTreeSnapshot) DescendLessOrEqual(pivot btree.Item, iterator btree.ItemIterator) {
+	s.walk(pivot.(*Item).key, true, iterator)
+}
+
+// walk merges the keys of the tree with the keys of the history from the pivot, a null pivot starts from the edge,
+// and calls the iterator for the items that exist in the snapshot
+func (s *bPlusTreeSnapshot) walk(pivot []byte, reverse bool, iterator btree.ItemIterator) {
+	s.bpt.lock.RLock()
+	defer s.bpt.lock.RUnlock()
+
+	err := s.bpt.tree.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(indexBucketName).Cursor()
+
+		var treeKey, treeValue []byte
+		switch {
+		case pivot == nil && reverse:
+			treeKey, treeValue = cursor.Last()
+		case pivot == nil:
+			treeKey, treeValue = cursor.First()
+		case reverse:
+			if treeKey, treeValue = cursor.Seek(pivot); treeKey == nil {
+				treeKey, treeValue = cursor.Last()
+			} else if !bytes.Equal(treeKey, pivot) {
+				treeKey, treeValue = cursor.Prev()
+			}
+		default:
+			treeKey, treeValue = cursor.Seek(pivot)
+		}
+		historyKey := s.nextHistoryKey(pivot, reverse, true)
+
+		for treeKey != nil || historyKey != nil {
+			// the next key is the smaller one of the two, or the larger one in reverse
+			key := treeKey
+			if key == nil || historyKey != nil && (bytes.Compare(historyKey, key) < 0) != reverse {
+				key = historyKey
+			}
+
+			var pos *data.LogRecordPos
+			if historyPos, found := s.bpt.historyAt(key, s.version); found {
+				pos = historyPos
+			} else if bytes.Equal(key, treeKey) {
+				pos = data.DecodeLogRecordPos(treeValue)
+			}
+			// the memory of bbolt is only valid within the transaction
+			key = append([]byte(nil), key...)
+
+			if bytes.Equal(key, treeKey) {
+				if reverse {
+					treeKey, treeValue = cursor.Prev()
+				} else {
+					treeKey, treeValue = cursor.Next()
+				}
+			}
+			if bytes.Equal(key, historyKey) {
+				historyKey = s.nextHistoryKey(key, reverse, false)
+			}
+
+			if pos != nil && !iterator(&Item{key: key, pos: pos}) {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic("failed to iterate over the snapshot of BPlusTree!")
+	}
+}
+
+// nextHistoryKey finds the next key in the history after the given key, or from it if inclusive is set
+// a null key starts from the edge, and a null result means there are no more keys
+// must hold a mutex lock before accessing this method
+func (s *bPlusTreeSnapshot) nextHistoryKey(key []byte, reverse bool, inclusive bool) []byte {
+	var next []byte
+	save := func(it btree.Item) bool {
+		next = it.(*historyRecord).key
+		return false
+	}
+
+	switch {
+	case key == nil && reverse:
+		s.bpt.history.Descend(save)
+	case key == nil:
+		s.bpt.history.Ascend(save)
+	case reverse && inclusive:
+		s.bpt.history.DescendLessOrEqual(&historyRecord{key: key, version: math.MaxUint64}, save)
+	case reverse:
+		// the versions start from one, so the record with version zero is before every record of the key
+		s.bpt.history.DescendLessOrEqual(&historyRecord{key: key}, save)
+	case inclusive:
+		s.bpt.history.AscendGreaterOrEqual(&historyRecord{key: key}, save)
+	default:
+		s.bpt.history.AscendGreaterOrEqual(&historyRecord{key: key, version: math.MaxUint64}, save)
+	}
+
+	return next
 }
 
 // bPlusTreeIterator wraps a BPlusTree iterator
 type bPlusTreeIterator struct {
 	tx           *bbolt.Tx
 	cursor       *bbolt.Cursor
+	keyRange     keyRange
 	reverse      bool
 	currentKey   []byte
 	currentValue []byte
 }
 
-func newBPlusTreeIterator(tree *bbolt.DB, reverse bool) *bPlusTreeIterator {
+func newBPlusTreeIterator(tree *bbolt.DB, options RangeOptions) *bPlusTreeIterator {
 	tx, err := tree.Begin(false)
 	if err != nil {
 		panic("failed to begin a transaction!")
 	}
 
 	bPlusIt := &bPlusTreeIterator{
-		tx:      tx,
-		cursor:  tx.Bucket(indexBucketName).Cursor(),
-		reverse: reverse,
+		tx:       tx,
+		cursor:   tx.Bucket(indexBucketName).Cursor(),
+		keyRange: newKeyRange(options),
+		reverse:  options.Reverse,
 	}
 
 	bPlusIt.Rewind() // initialize key and value first
@@ -161,14 +541,43 @@ func newBPlusTreeIterator(tree *bbolt.DB, reverse bool) *bPlusTreeIterator {
 
 func (bpti *bPlusTreeIterator) Rewind() {
 	if bpti.reverse {
-		bpti.currentKey, bpti.currentValue = bpti.cursor.Last()
+		if bpti.keyRange.upper == nil {
+			bpti.currentKey, bpti.currentValue = bpti.cursor.Last()
+		} else {
+			bpti.seekReverse(bpti.keyRange.upper)
+		}
 	} else {
-		bpti.currentKey, bpti.currentValue = bpti.cursor.First()
+		if bpti.keyRange.lower == nil {
+			bpti.currentKey, bpti.currentValue = bpti.cursor.First()
+		} else {
+			bpti.currentKey, bpti.currentValue = bpti.cursor.Seek(bpti.keyRange.lower)
+		}
 	}
 }
 
 func (bpti *bPlusTreeIterator) Seek(key []byte) {
+	if bpti.reverse && !bpti.keyRange.belowUpper(key) || !bpti.reverse && !bpti.keyRange.aboveLower(key) {
+		bpti.Rewind()
+		return
+	}
+
+	if bpti.reverse {
+		bpti.seekReverse(key)
+	} else {
+		bpti.currentKey, bpti.currentValue = bpti.cursor.Seek(key)
+	}
+}
+
+// seekReverse moves the cursor to the last key within the range that is less than or equal to the given key
+func (bpti *bPlusTreeIterator) seekReverse(key []byte) {
 	bpti.currentKey, bpti.currentValue = bpti.cursor.Seek(key)
+
+	if bpti.currentKey == nil {
+		// every key is less than the given key
+		bpti.currentKey, bpti.currentValue = bpti.cursor.Last()
+	} else if !bytes.Equal(bpti.currentKey, key) || !bpti.keyRange.belowUpper(bpti.currentKey) {
+		bpti.currentKey, bpti.currentValue = bpti.cursor.Prev()
+	}
 }
 
 func (bpti *bPlusTreeIterator) Next() {
@@ -180,7 +589,7 @@ func (bpti *bPlusTreeIterator) Next() {
 }
 
 func (bpti *bPlusTreeIterator) Valid() bool {
-	return len(bpti.currentKey) != 0
+	return len(bpti.currentKey) != 0 && bpti.keyRange.contains(bpti.currentKey)
 }
 
 func (bpti *bPlusTreeIterator) Key() []byte {
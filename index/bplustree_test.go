@@ -13,10 +13,13 @@
 package index
 
 import (
+	"fmt"
 	"github.com/LiuShuoJiang/betadb/data"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
 )
 
@@ -139,3 +142,211 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestBPlusTree_Snapshot(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-snapshot")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	tree := NewBPlusTree(path, false)
+	tree.Put([]byte("cpp"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	tree.Put([]byte("java"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	snapshot := tree.Snapshot()
+
+	// the writes after the snapshot are invisible to it
+	tree.Put([]byte("cpp"), &data.LogRecordPos{Fid: 2, Offset: 30})
+	tree.Put([]byte("golang"), &data.LogRecordPos{Fid: 2, Offset: 40})
+
+	assert.Equal(t, 2, snapshot.Size())
+	assert.Equal(t, int64(10), snapshot.Get([]byte("cpp")).Offset)
+	assert.Nil(t, snapshot.Get([]byte("golang")))
+	assert.Equal(t, int64(30), tree.Get([]byte("cpp")).Offset)
+
+	var count int
+	iter := snapshot.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 2, count)
+
+	err := snapshot.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, tree.Size())
+}
+
+func TestBPlusTree_SnapshotHistory(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-snapshot-history")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	tree := NewBPlusTree(path, false)
+	defer func() {
+		_ = tree.Close()
+	}()
+
+	random := rand.New(rand.NewSource(1))
+	expected := make(map[string]int64)
+	var snapshots []Indexer
+	var snapshotExpected []map[string]int64
+
+	check := func(snapshot Indexer, expected map[string]int64) {
+		assert.Equal(t, len(expected), snapshot.Size())
+
+		var keys []string
+		for key, offset := range expected {
+			keys = append(keys, key)
+			pos := snapshot.Get([]byte(key))
+			if assert.NotNil(t, pos) {
+				assert.Equal(t, offset, pos.Offset)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, reverse := range []bool{false, true} {
+			var iterated []string
+			iter := snapshot.Iterator(reverse)
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				iterated = append(iterated, string(iter.Key()))
+				assert.Equal(t, expected[string(iter.Key())], iter.Value().Offset)
+			}
+			iter.Close()
+
+			if reverse {
+				for i, j := 0, len(iterated)-1; i < j; i, j = i+1, j-1 {
+					iterated[i], iterated[j] = iterated[j], iterated[i]
+				}
+			}
+			assert.Equal(t, keys, iterated)
+		}
+	}
+
+	for i := 0; i < 3000; i++ {
+		key := []byte(fmt.Sprintf("key-%03d", random.Intn(300)))
+		if random.Intn(3) == 0 {
+			tree.Delete(key)
+			delete(expected, string(key))
+		} else {
+			tree.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+			expected[string(key)] = int64(i)
+		}
+
+		if i%300 == 0 {
+			expectedCopy := make(map[string]int64, len(expected))
+			for key, offset := range expected {
+				expectedCopy[key] = offset
+			}
+			snapshots = append(snapshots, tree.Snapshot())
+			snapshotExpected = append(snapshotExpected, expectedCopy)
+		}
+
+		// the history needed by the older snapshots is kept once a snapshot is released
+		if i%700 == 0 && len(snapshots) > 1 {
+			_ = snapshots[1].Close()
+			snapshots = append(snapshots[:1], snapshots[2:]...)
+			snapshotExpected = append(snapshotExpected[:1], snapshotExpected[2:]...)
+		}
+	}
+
+	check(tree, expected)
+	for i, snapshot := range snapshots {
+		check(snapshot, snapshotExpected[i])
+	}
+
+	// the history is dropped once every snapshot is released
+	assert.Greater(t, tree.history.Len(), 0)
+	for _, snapshot := range snapshots {
+		assert.Nil(t, snapshot.Close())
+	}
+	assert.Equal(t, 0, tree.history.Len())
+	assert.Nil(t, tree.historyQueue)
+
+	// the writers are not blocked by the iterators of the snapshots
+	snapshot := tree.Snapshot()
+	iter := snapshot.Iterator(false)
+	for i := 0; i < 10000; i++ {
+		tree.Put([]byte(fmt.Sprintf("new-key-%05d", i)), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+	}
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, len(expected), count)
+	assert.Nil(t, snapshot.Close())
+}
+
+func TestBPlusTree_RangeIterator(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-range-iter")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	tree := NewBPlusTree(path, false)
+	tree.Put([]byte("apple"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	tree.Put([]byte("banana"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	tree.Put([]byte("bandana"), &data.LogRecordPos{Fid: 1, Offset: 30})
+	tree.Put([]byte("bank"), &data.LogRecordPos{Fid: 1, Offset: 40})
+	tree.Put([]byte("cherry"), &data.LogRecordPos{Fid: 1, Offset: 50})
+
+	collect := func(iter Iterator) []string {
+		defer iter.Close()
+
+		var keys []string
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	assert.Equal(t, []string{"banana", "bandana", "bank"}, collect(tree.RangeIterator(RangeOptions{Prefix: []byte("ban")})))
+	assert.Equal(t, []string{"bank", "bandana", "banana"}, collect(tree.RangeIterator(RangeOptions{Prefix: []byte("ban"), Reverse: true})))
+	assert.Equal(t, []string{"banana", "bandana"}, collect(tree.RangeIterator(RangeOptions{LowerBound: []byte("b"), UpperBound: []byte("bank")})))
+	assert.Equal(t, []string{"bandana", "banana", "apple"}, collect(tree.RangeIterator(RangeOptions{UpperBound: []byte("bank"), Reverse: true})))
+	assert.Nil(t, collect(tree.RangeIterator(RangeOptions{Prefix: []byte("ban"), LowerBound: []byte("c")})))
+
+	// reverse seek lands on the last key less than or equal to the target
+	iter := tree.RangeIterator(RangeOptions{Reverse: true})
+	iter.Seek([]byte("bb"))
+	assert.Equal(t, []byte("bank"), iter.Key())
+	iter.Seek([]byte("zzz"))
+	assert.Equal(t, []byte("cherry"), iter.Key())
+	iter.Seek([]byte("a"))
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
+
+func TestForEachBPlusTreeEntry(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-foreach")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	err := ForEachBPlusTreeEntry(path, func(key []byte, pos *data.LogRecordPos) bool {
+		return true
+	})
+	assert.True(t, os.IsNotExist(err))
+
+	tree := NewBPlusTree(path, false)
+	tree.Put([]byte("cpp"), &data.LogRecordPos{Fid: 1, Offset: 10, Size: 20})
+	tree.Put([]byte("golang"), &data.LogRecordPos{Fid: 2, Offset: 30, Size: 40})
+	assert.Nil(t, tree.Close())
+
+	entries := make(map[string]*data.LogRecordPos)
+	err = ForEachBPlusTreeEntry(path, func(key []byte, pos *data.LogRecordPos) bool {
+		entries[string(key)] = pos
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]*data.LogRecordPos{
+		"cpp":    {Fid: 1, Offset: 10, Size: 20},
+		"golang": {Fid: 2, Offset: 30, Size: 40},
+	}, entries)
+}
@@ -16,7 +16,6 @@ import (
 	"bytes"
 	"github.com/LiuShuoJiang/betadb/data"
 	"github.com/google/btree"
-	"sort"
 	"sync"
 )
 
@@ -53,7 +52,10 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
 
+	// the merge reads the index concurrently with the writers
+	bt.lock.RLock()
 	bTreeItem := bt.tree.Get(it)
+	bt.lock.RUnlock()
 	if bTreeItem == nil {
 		return nil
 	}
@@ -76,6 +78,9 @@ func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 }
 
 func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+
 	return bt.tree.Len()
 }
 
@@ -83,71 +88,159 @@ func (bt *BTree) Close() error {
 	return nil
 }
 
+// Snapshot clones the BTree lazily, the nodes are shared and copied on write afterwards
+func (bt *BTree) Snapshot() Indexer {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+
+	return &BTree{
+		tree: bt.tree.Clone(),
+		lock: new(sync.RWMutex),
+	}
+}
+
 func (bt *BTree) Iterator(reverse bool) Iterator {
+	return bt.RangeIterator(RangeOptions{Reverse: reverse})
+}
+
+func (bt *BTree) RangeIterator(options RangeOptions) Iterator {
 	if bt.tree == nil {
 		return nil
 	}
 
+	// cloning is O(1), the iterator then reads a frozen tree while the writers copy on write
+	bt.lock.Lock()
+	tree := bt.tree.Clone()
+	bt.lock.Unlock()
+
+	return newBTreeIterator(tree, options)
+}
+
+// RangeKeys walks the tree itself under the read lock, no clone is taken
+func (bt *BTree) RangeKeys(options RangeOptions) [][]byte {
 	bt.lock.RLock()
 	defer bt.lock.RUnlock()
 
-	return newBTreeIterator(bt.tree, reverse)
+	return iteratorKeys(newBTreeIterator(bt.tree, options))
 }
 
+// bTreeIteratorBatchSize is the number of items fetched from the tree at a time
+const bTreeIteratorBatchSize = 128
+
+// orderedTree is the ordered set of items walked by bTreeIterator, implemented by btree.BTree and radixTree
+type orderedTree interface {
+	Ascend(iterator btree.ItemIterator)
+	AscendGreaterOrEqual(pivot btree.Item, iterator btree.ItemIterator)
+	Descend(iterator btree.ItemIterator)
+	DescendLessOrEqual(pivot btree.Item, iterator btree.ItemIterator)
+}
+
+// bTreeIterator walks a cloned BTree or ART lazily, loading a small batch of items at a time
 type bTreeIterator struct {
-	// currentIndex defines the current iterating index position
+	// tree is the frozen clone of the index being iterated
+	tree orderedTree
+
+	// keyRange limits the keys to be iterated
+	keyRange keyRange
+
+	// currentIndex defines the current iterating index position within the batch
 	currentIndex int
 
 	// reverse determines whether we are iterating backwards
 	reverse bool
 
-	// values stores the key and positional indexing information
+	// values stores the current batch of the key and positional indexing information
 	values []*Item
+
+	// exhausted indicates there are no more items after the current batch
+	exhausted bool
 }
 
-func newBTreeIterator(tree *btree.BTree, reverse bool) *bTreeIterator {
-	var idx int
-	values := make([]*Item, tree.Len())
+func newBTreeIterator(tree orderedTree, options RangeOptions) *bTreeIterator {
+	bti := &bTreeIterator{
+		tree:     tree,
+		keyRange: newKeyRange(options),
+		reverse:  options.Reverse,
+		values:   make([]*Item, 0, bTreeIteratorBatchSize),
+	}
+
+	bti.Rewind()
+
+	return bti
+}
+
+// load fetches the next batch of items starting from the pivot
+//
+// a null pivot starts from the edge of the tree, skipPivot excludes the item equal to the pivot
+func (bti *bTreeIterator) load(pivot *Item, skipPivot bool) {
+	bti.values = bti.values[:0]
+	bti.currentIndex = 0
+	bti.exhausted = true
 
-	// put all the data into the array
 	saveValues := func(it btree.Item) bool {
-		values[idx] = it.(*Item)
-		idx++
-		return true
-	}
+		item := it.(*Item)
 
-	if reverse {
-		tree.Descend(saveValues)
-	} else {
-		tree.Ascend(saveValues)
+		if skipPivot && bytes.Equal(item.key, pivot.key) {
+			return true
+		}
+
+		if bti.reverse && !bti.keyRange.aboveLower(item.key) ||
+			!bti.reverse && !bti.keyRange.belowUpper(item.key) {
+			return false
+		}
+
+		if len(bti.values) == bTreeIteratorBatchSize {
+			bti.exhausted = false
+			return false
+		}
+
+		bti.values = append(bti.values, item)
+		return true
 	}
 
-	return &bTreeIterator{
-		currentIndex: 0,
-		reverse:      reverse,
-		values:       values,
+	switch {
+	case bti.reverse && pivot == nil:
+		bti.tree.Descend(saveValues)
+	case bti.reverse:
+		bti.tree.DescendLessOrEqual(pivot, saveValues)
+	case pivot == nil:
+		bti.tree.Ascend(saveValues)
+	default:
+		bti.tree.AscendGreaterOrEqual(pivot, saveValues)
 	}
 }
 
 func (bti *bTreeIterator) Rewind() {
-	bti.currentIndex = 0
+	if bti.reverse {
+		if bti.keyRange.upper == nil {
+			bti.load(nil, false)
+		} else {
+			bti.load(&Item{key: bti.keyRange.upper}, true)
+		}
+	} else {
+		if bti.keyRange.lower == nil {
+			bti.load(nil, false)
+		} else {
+			bti.load(&Item{key: bti.keyRange.lower}, false)
+		}
+	}
 }
 
 func (bti *bTreeIterator) Seek(key []byte) {
-	if bti.reverse {
-		// use binary search
-		bti.currentIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) <= 0
-		})
-	} else {
-		bti.currentIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) >= 0
-		})
+	if bti.reverse && !bti.keyRange.belowUpper(key) || !bti.reverse && !bti.keyRange.aboveLower(key) {
+		bti.Rewind()
+		return
 	}
+
+	bti.load(&Item{key: key}, false)
 }
 
 func (bti *bTreeIterator) Next() {
 	bti.currentIndex += 1
+
+	if bti.currentIndex == len(bti.values) && !bti.exhausted {
+		bti.load(bti.values[len(bti.values)-1], true)
+	}
 }
 
 func (bti *bTreeIterator) Valid() bool {
@@ -163,5 +256,6 @@ func (bti *bTreeIterator) Value() *data.LogRecordPos {
 }
 
 func (bti *bTreeIterator) Close() {
+	bti.tree = nil
 	bti.values = nil
 }
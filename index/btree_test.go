@@ -13,6 +13,7 @@
 package index
 
 import (
+	"fmt"
 	"github.com/LiuShuoJiang/betadb/data"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -128,3 +129,90 @@ func TestBTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter6.Key())
 	}
 }
+
+func TestBTree_Snapshot(t *testing.T) {
+	bt := NewBTree()
+	bt.Put([]byte("cpp"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt.Put([]byte("java"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	snapshot := bt.Snapshot()
+	defer func() {
+		_ = snapshot.Close()
+	}()
+
+	// the writes after the snapshot are invisible to it
+	bt.Put([]byte("cpp"), &data.LogRecordPos{Fid: 2, Offset: 30})
+	bt.Put([]byte("golang"), &data.LogRecordPos{Fid: 2, Offset: 40})
+	bt.Delete([]byte("java"))
+
+	assert.Equal(t, 2, snapshot.Size())
+	assert.Equal(t, int64(10), snapshot.Get([]byte("cpp")).Offset)
+	assert.NotNil(t, snapshot.Get([]byte("java")))
+	assert.Nil(t, snapshot.Get([]byte("golang")))
+
+	assert.Equal(t, 2, bt.Size())
+	assert.Equal(t, int64(30), bt.Get([]byte("cpp")).Offset)
+}
+
+func TestBTree_RangeIterator(t *testing.T) {
+	bt := NewBTree()
+	for i := 0; i < 1000; i++ {
+		bt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	bt.Put([]byte("other"), &data.LogRecordPos{Fid: 1, Offset: 1000})
+
+	// (1) the prefix spans several batches and stops at its end
+	iter1 := bt.RangeIterator(RangeOptions{Prefix: []byte("key-")})
+	var count int
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%04d", count)), iter1.Key())
+		count++
+	}
+	iter1.Close()
+	assert.Equal(t, 1000, count)
+
+	// (2) lower bound is inclusive, upper bound is exclusive
+	iter2 := bt.RangeIterator(RangeOptions{LowerBound: []byte("key-0100"), UpperBound: []byte("key-0300")})
+	count = 0
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		count++
+	}
+	assert.Equal(t, 200, count)
+
+	iter2.Seek([]byte("key-0299"))
+	assert.Equal(t, []byte("key-0299"), iter2.Key())
+	iter2.Next()
+	assert.False(t, iter2.Valid())
+
+	iter2.Seek([]byte("key-0000"))
+	assert.Equal(t, []byte("key-0100"), iter2.Key())
+	iter2.Close()
+
+	// (3) reverse iteration within the bounds
+	iter3 := bt.RangeIterator(RangeOptions{LowerBound: []byte("key-0100"), UpperBound: []byte("key-0300"), Reverse: true})
+	assert.Equal(t, []byte("key-0299"), iter3.Key())
+	count = 0
+	for ; iter3.Valid(); iter3.Next() {
+		count++
+	}
+	assert.Equal(t, 200, count)
+
+	iter3.Seek([]byte("key-0150"))
+	assert.Equal(t, []byte("key-0150"), iter3.Key())
+	iter3.Seek([]byte("zzz"))
+	assert.Equal(t, []byte("key-0299"), iter3.Key())
+	iter3.Close()
+
+	// (4) the writes after creating the iterator are invisible to it
+	iter4 := bt.RangeIterator(RangeOptions{Prefix: []byte("key-")})
+	for i := 0; i < 1000; i++ {
+		bt.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+	}
+	count = 0
+	for iter4.Rewind(); iter4.Valid(); iter4.Next() {
+		count++
+	}
+	iter4.Close()
+	assert.Equal(t, 1000, count)
+	assert.Equal(t, 1, bt.Size())
+}
@@ -15,6 +15,7 @@ package index
 import (
 	"bytes"
 	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/utils"
 	"github.com/google/btree"
 )
 
@@ -36,6 +37,17 @@ type Indexer interface {
 	// Iterator defines an iterator to iterator over the index
 	Iterator(reverse bool) Iterator
 
+	// RangeIterator defines an iterator over the keys within the given range
+	RangeIterator(options RangeOptions) Iterator
+
+	// RangeKeys returns a copy of the keys within the given range, read without cloning the index
+	// so that the index can be modified afterward without copying the nodes that a clone would share
+	RangeKeys(options RangeOptions) [][]byte
+
+	// Snapshot returns a read-only point-in-time view of the index
+	// the snapshot must be closed to free the resources when it is no longer used
+	Snapshot() Indexer
+
 	// Close closes the index
 	Close() error
 }
@@ -46,7 +58,7 @@ const (
 	// Btree indicates btree index
 	Btree IndexType = iota + 1
 
-	// ART indicates Adaptive Radix Tree index
+	// ART indicates the radix tree index, named after the Adaptive Radix Tree it used to be
 	ART
 
 	// BPTree indicates b+tree index
@@ -79,6 +91,84 @@ func (i *Item) Less(rhs btree.Item) bool {
 	return bytes.Compare(i.key, rhs.(*Item).key) == -1
 }
 
+// RangeOptions defines the key range of an index iterator
+type RangeOptions struct {
+	// Prefix limits the iteration to the keys with the given prefix
+	Prefix []byte
+
+	// LowerBound is the inclusive lower bound of the keys, null means unbounded
+	LowerBound []byte
+
+	// UpperBound is the exclusive upper bound of the keys, null means unbounded
+	UpperBound []byte
+
+	// Reverse indicates whether to traverse in reverse direction
+	Reverse bool
+}
+
+// iteratorKeys collects a copy of every key of the iterator and closes it
+func iteratorKeys(iterator Iterator) [][]byte {
+	defer iterator.Close()
+
+	var keys [][]byte
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys = append(keys, append([]byte(nil), iterator.Key()...))
+	}
+
+	return keys
+}
+
+// keyRange is the normalized key range [lower, upper) combining the prefix and the bounds
+type keyRange struct {
+	lower []byte
+	upper []byte
+}
+
+func newKeyRange(options RangeOptions) keyRange {
+	r := keyRange{lower: options.LowerBound, upper: options.UpperBound}
+
+	if len(options.Prefix) > 0 {
+		if r.lower == nil || bytes.Compare(options.Prefix, r.lower) > 0 {
+			r.lower = options.Prefix
+		}
+
+		if end := utils.PrefixEnd(options.Prefix); end != nil && (r.upper == nil || bytes.Compare(end, r.upper) < 0) {
+			r.upper = end
+		}
+	}
+
+	return r
+}
+
+// belowUpper checks whether the key is less than the upper bound
+func (r keyRange) belowUpper(key []byte) bool {
+	return r.upper == nil || bytes.Compare(key, r.upper) < 0
+}
+
+// aboveLower checks whether the key is greater than or equal to the lower bound
+func (r keyRange) aboveLower(key []byte) bool {
+	return r.lower == nil || bytes.Compare(key, r.lower) >= 0
+}
+
+// contains checks whether the key is within the range
+func (r keyRange) contains(key []byte) bool {
+	return r.aboveLower(key) && r.belowUpper(key)
+}
+
+// commonPrefix returns the longest prefix shared by every key within the range
+func (r keyRange) commonPrefix() []byte {
+	if r.lower == nil || r.upper == nil {
+		return nil
+	}
+
+	var n int
+	for n < len(r.lower) && n < len(r.upper) && r.lower[n] == r.upper[n] {
+		n++
+	}
+
+	return r.lower[:n]
+}
+
 // Iterator defines a generic index iterator
 type Iterator interface {
 	// Rewind returns to the start (first item) of the iterator
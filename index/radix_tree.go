@@ -0,0 +1,345 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package index
+
+import (
+	"bytes"
+	"github.com/google/btree"
+	"sort"
+)
+
+// radixPathSize is the initial capacity of the key built while walking the tree
+const radixPathSize = 64
+
+// radixTree is a radix tree of the items with path compression, whose nodes keep as many children as they have
+//
+// the nodes are shared by the clones of the tree, and copied by a tree before it modifies them,
+// so a clone is taken in constant time and stays unchanged afterward
+type radixTree struct {
+	root *radixNode
+	size int
+
+	// owner marks the nodes that the tree may modify in place
+	owner *radixOwner
+}
+
+// radixOwner identifies the tree that created a node
+type radixOwner struct {
+	// the field gives every owner a distinct address
+	_ byte
+}
+
+type radixNode struct {
+	// prefix is the part of the keys consumed by the node, starting with the label of the node in its parent
+	prefix []byte
+
+	// item is the item whose key ends at the node, null if there is none
+	item *Item
+
+	// labels are the first bytes of the prefixes of the children in ascending order
+	labels   []byte
+	children []*radixNode
+
+	owner *radixOwner
+}
+
+func newRadixTree() *radixTree {
+	owner := new(radixOwner)
+	return &radixTree{
+		root:  &radixNode{owner: owner},
+		owner: owner,
+	}
+}
+
+// clone returns a copy of the tree in constant time, both trees copy the shared nodes before modifying them
+func (t *radixTree) clone() *radixTree {
+	t.owner = new(radixOwner)
+	return &radixTree{
+		root:  t.root,
+		size:  t.size,
+		owner: new(radixOwner),
+	}
+}
+
+// writable returns the node itself if the tree owns it, otherwise a copy owned by the tree
+func (t *radixTree) writable(n *radixNode) *radixNode {
+	if n.owner == t.owner {
+		return n
+	}
+
+	return &radixNode{
+		prefix:   n.prefix,
+		item:     n.item,
+		labels:   append([]byte(nil), n.labels...),
+		children: append([]*radixNode(nil), n.children...),
+		owner:    t.owner,
+	}
+}
+
+// child finds the position of the child with the label, or the position to insert it at
+func (n *radixNode) child(label byte) (int, bool) {
+	i := sort.Search(len(n.labels), func(i int) bool {
+		return n.labels[i] >= label
+	})
+	return i, i < len(n.labels) && n.labels[i] == label
+}
+
+func (n *radixNode) insertChild(i int, child *radixNode) {
+	n.labels = append(n.labels, 0)
+	copy(n.labels[i+1:], n.labels[i:])
+	n.labels[i] = child.prefix[0]
+
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = child
+}
+
+func (n *radixNode) removeChild(i int) {
+	n.labels = append(n.labels[:i], n.labels[i+1:]...)
+	copy(n.children[i:], n.children[i+1:])
+	n.children[len(n.children)-1] = nil
+	n.children = n.children[:len(n.children)-1]
+}
+
+// get gets the item of the key, null if the key does not exist
+func (t *radixTree) get(key []byte) *Item {
+	n := t.root
+	for {
+		if !bytes.HasPrefix(key, n.prefix) {
+			return nil
+		}
+		key = key[len(n.prefix):]
+		if len(key) == 0 {
+			return n.item
+		}
+
+		i, ok := n.child(key[0])
+		if !ok {
+			return nil
+		}
+		n = n.children[i]
+	}
+}
+
+// put inserts the item, and returns the item of the same key that it replaces
+func (t *radixTree) put(item *Item) *Item {
+	root, old := t.insert(t.root, item, 0)
+	t.root = root
+	if old == nil {
+		t.size++
+	}
+
+	return old
+}
+
+// insert inserts the item below the node, whose prefix starts at the given depth of the key,
+// and returns the node replacing it
+func (t *radixTree) insert(n *radixNode, item *Item, depth int) (*radixNode, *Item) {
+	key := item.key[depth:]
+	common := commonPrefixLength(n.prefix, key)
+
+	// the node is split at the end of the common prefix
+	if common < len(n.prefix) {
+		parent := &radixNode{prefix: n.prefix[:common], owner: t.owner}
+		child := t.writable(n)
+		child.prefix = n.prefix[common:]
+		parent.insertChild(0, child)
+
+		if common == len(key) {
+			parent.item = item
+		} else {
+			i, _ := parent.child(key[common])
+			parent.insertChild(i, &radixNode{prefix: key[common:], item: item, owner: t.owner})
+		}
+		return parent, nil
+	}
+
+	n = t.writable(n)
+	depth += len(n.prefix)
+	if depth == len(item.key) {
+		old := n.item
+		n.item = item
+		return n, old
+	}
+
+	i, ok := n.child(item.key[depth])
+	if !ok {
+		n.insertChild(i, &radixNode{prefix: item.key[depth:], item: item, owner: t.owner})
+		return n, nil
+	}
+
+	child, old := t.insert(n.children[i], item, depth)
+	n.children[i] = child
+	return n, old
+}
+
+// delete deletes the item of the key, and returns it
+func (t *radixTree) delete(key []byte) *Item {
+	root, old := t.remove(t.root, key, 0)
+	if old != nil {
+		t.root = root
+		t.size--
+	}
+
+	return old
+}
+
+// remove removes the item of the key below the node, whose prefix starts at the given depth of the key,
+// and returns the node replacing it, null if the node is no longer needed
+func (t *radixTree) remove(n *radixNode, key []byte, depth int) (*radixNode, *Item) {
+	if !bytes.HasPrefix(key[depth:], n.prefix) {
+		return n, nil
+	}
+	depth += len(n.prefix)
+
+	var old *Item
+	if depth == len(key) {
+		if n.item == nil {
+			return n, nil
+		}
+		old = n.item
+		n = t.writable(n)
+		n.item = nil
+	} else {
+		i, ok := n.child(key[depth])
+		if !ok {
+			return n, nil
+		}
+		child, removed := t.remove(n.children[i], key, depth)
+		if removed == nil {
+			return n, nil
+		}
+
+		old = removed
+		n = t.writable(n)
+		if child == nil {
+			n.removeChild(i)
+		} else {
+			n.children[i] = child
+		}
+	}
+
+	// the root is kept, the other nodes without an item are merged into their only child or removed
+	if len(n.prefix) == 0 || n.item != nil || len(n.children) > 1 {
+		return n, old
+	}
+	if len(n.children) == 0 {
+		return nil, old
+	}
+
+	child := t.writable(n.children[0])
+	child.prefix = append(append([]byte(nil), n.prefix...), child.prefix...)
+	return child, old
+}
+
+// Ascend calls the iterator for every item in ascending order until it returns false
+func (t *radixTree) Ascend(iterator btree.ItemIterator) {
+	t.root.ascend(make([]byte, 0, radixPathSize), nil, iterator)
+}
+
+// AscendGreaterOrEqual calls the iterator for every item greater than or equal to the pivot in ascending order
+func (t *radixTree) AscendGreaterOrEqual(pivot btree.Item, iterator btree.ItemIterator) {
+	t.root.ascend(make([]byte, 0, radixPathSize), pivot.(*Item).key, iterator)
+}
+
+// Descend calls the iterator for every item in descending order until it returns false
+func (t *radixTree) Descend(iterator btree.ItemIterator) {
+	t.root.descend(make([]byte, 0, radixPathSize), nil, iterator)
+}
+
+// DescendLessOrEqual calls the iterator for every item less than or equal to the pivot in descending order
+func (t *radixTree) DescendLessOrEqual(pivot btree.Item, iterator btree.ItemIterator) {
+	t.root.descend(make([]byte, 0, radixPathSize), pivot.(*Item).key, iterator)
+}
+
+// ascend visits the items below the node in ascending order, skipping the subtrees below the pivot
+// path is the key consumed before the node, and a null pivot visits every item
+func (n *radixNode) ascend(path, pivot []byte, iterator btree.ItemIterator) bool {
+	path = append(path, n.prefix...)
+
+	if pivot != nil {
+		c := bytes.Compare(path, pivot[:min(len(path), len(pivot))])
+		if c < 0 {
+			return true
+		}
+		if c > 0 || len(path) >= len(pivot) {
+			pivot = nil
+		}
+	}
+
+	// the key of the node is less than the pivot if the pivot is still needed
+	if n.item != nil && pivot == nil && !iterator(n.item) {
+		return false
+	}
+
+	start := 0
+	if pivot != nil {
+		start, _ = n.child(pivot[len(path)])
+	}
+	for _, child := range n.children[start:] {
+		if !child.ascend(path, pivot, iterator) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// descend visits the items below the node in descending order, skipping the subtrees above the pivot
+// path is the key consumed before the node, and a null pivot visits every item
+func (n *radixNode) descend(path, pivot []byte, iterator btree.ItemIterator) bool {
+	path = append(path, n.prefix...)
+
+	if pivot != nil {
+		c := bytes.Compare(path, pivot[:min(len(path), len(pivot))])
+		switch {
+		case c > 0:
+			return true
+		case c < 0:
+			pivot = nil
+		case len(path) >= len(pivot):
+			// only the key of the node may be less than or equal to the pivot
+			if len(path) == len(pivot) && n.item != nil {
+				return iterator(n.item)
+			}
+			return true
+		}
+	}
+
+	end := len(n.children)
+	if pivot != nil {
+		i, ok := n.child(pivot[len(path)])
+		end = i
+		if ok {
+			end = i + 1
+		}
+	}
+	for i := end - 1; i >= 0; i-- {
+		if !n.children[i].descend(path, pivot, iterator) {
+			return false
+		}
+	}
+
+	if n.item != nil {
+		return iterator(n.item)
+	}
+	return true
+}
+
+func commonPrefixLength(a, b []byte) int {
+	var n int
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
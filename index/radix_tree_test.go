@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package index
+
+import (
+	"bytes"
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/google/btree"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// collectRadixTree collects the keys visited by the walk until limit keys are collected
+func collectRadixTree(walk func(iterator btree.ItemIterator), limit int) []string {
+	var keys []string
+	walk(func(it btree.Item) bool {
+		keys = append(keys, string(it.(*Item).key))
+		return len(keys) < limit
+	})
+	return keys
+}
+
+func TestRadixTree(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	randomKey := func() []byte {
+		// the keys share many prefixes to split and merge the nodes
+		key := make([]byte, 1+random.Intn(6))
+		for i := range key {
+			key[i] = "abc"[random.Intn(3)]
+		}
+		return key
+	}
+
+	tree := newRadixTree()
+	expected := make(map[string]int64)
+	var clones []*radixTree
+	var cloneExpected []map[string]int64
+
+	check := func(tree *radixTree, expected map[string]int64) {
+		assert.Equal(t, len(expected), tree.size)
+
+		keys := make([]string, 0, len(expected))
+		for key, offset := range expected {
+			keys = append(keys, key)
+			item := tree.get([]byte(key))
+			if assert.NotNil(t, item) {
+				assert.Equal(t, offset, item.pos.Offset)
+			}
+		}
+		sort.Strings(keys)
+		reversed := make([]string, len(keys))
+		for i, key := range keys {
+			reversed[len(keys)-1-i] = key
+		}
+
+		assert.Equal(t, keys, append([]string{}, collectRadixTree(tree.Ascend, len(keys)+1)...))
+		assert.Equal(t, reversed, append([]string{}, collectRadixTree(tree.Descend, len(keys)+1)...))
+
+		pivot := randomKey()
+		var ascending, descending []string
+		for _, key := range keys {
+			if bytes.Compare([]byte(key), pivot) >= 0 {
+				ascending = append(ascending, key)
+			}
+		}
+		for _, key := range reversed {
+			if bytes.Compare([]byte(key), pivot) <= 0 {
+				descending = append(descending, key)
+			}
+		}
+		assert.Equal(t, ascending, collectRadixTree(func(iterator btree.ItemIterator) {
+			tree.AscendGreaterOrEqual(&Item{key: pivot}, iterator)
+		}, len(keys)+1))
+		assert.Equal(t, descending, collectRadixTree(func(iterator btree.ItemIterator) {
+			tree.DescendLessOrEqual(&Item{key: pivot}, iterator)
+		}, len(keys)+1))
+	}
+
+	for i := 0; i < 5000; i++ {
+		key := randomKey()
+		if random.Intn(3) == 0 {
+			_, ok := expected[string(key)]
+			assert.Equal(t, ok, tree.delete(key) != nil)
+			delete(expected, string(key))
+		} else {
+			_, ok := expected[string(key)]
+			assert.Equal(t, ok, tree.put(&Item{key: key, pos: &data.LogRecordPos{Offset: int64(i)}}) != nil)
+			expected[string(key)] = int64(i)
+		}
+
+		// the clones are not changed by the writes afterward, and their own writes do not change the tree
+		if i%500 == 0 {
+			clone := tree.clone()
+			cloneMap := make(map[string]int64, len(expected))
+			for key, offset := range expected {
+				cloneMap[key] = offset
+			}
+			clone.put(&Item{key: []byte("clone"), pos: &data.LogRecordPos{Offset: -1}})
+			cloneMap["clone"] = -1
+
+			clones = append(clones, clone)
+			cloneExpected = append(cloneExpected, cloneMap)
+		}
+		if i%100 == 0 {
+			check(tree, expected)
+		}
+	}
+
+	check(tree, expected)
+	for i, clone := range clones {
+		check(clone, cloneExpected[i])
+	}
+}
@@ -13,7 +13,7 @@
 package betadb
 
 import (
-	"bytes"
+	"github.com/LiuShuoJiang/betadb/data"
 	"github.com/LiuShuoJiang/betadb/index"
 )
 
@@ -22,15 +22,42 @@ type Iterator struct {
 	indexIter index.Iterator
 	db        *Database
 	options   IteratorOptions
+
+	// txn is the transaction that owns the iterator, nil for the database iterator
+	txn *Txn
+
+	// dataFiles are the data files pinned by the iterator, nil if it reads from a snapshot
+	dataFiles map[uint32]*data.DataFile
 }
 
 // NewIterator initializes the Iterator struct
+// the iterator reads from the snapshot given by the options if there is one
 func (db *Database) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
+	rangeOptions := index.RangeOptions{
+		Prefix:     opts.Prefix,
+		LowerBound: opts.LowerBound,
+		UpperBound: opts.UpperBound,
+		Reverse:    opts.Reverse,
+	}
+
+	if opts.Snapshot != nil {
+		return &Iterator{
+			db:        db,
+			indexIter: opts.Snapshot.index.RangeIterator(rangeOptions),
+			options:   opts,
+		}
+	}
+
+	// pin the data files together with creating the index iterator,
+	// so that the positions stay readable even if the files are compacted in the meantime
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	return &Iterator{
 		db:        db,
-		indexIter: indexIter,
+		indexIter: db.index.RangeIterator(rangeOptions),
 		options:   opts,
+		dataFiles: db.pinDataFiles(),
 	}
 }
 
@@ -64,28 +91,43 @@ func (it *Iterator) Key() []byte {
 }
 
 // Value gets the current iterating value data by byte array
+// it returns ErrKeysOnlyIterator if the iterator is created with KeysOnly
 func (it *Iterator) Value() ([]byte, error) {
+	if it.options.KeysOnly {
+		return nil, ErrKeysOnlyIterator
+	}
+
 	logRecordPos := it.indexIter.Value()
-	it.db.mu.RLock()
-	defer it.db.mu.RUnlock()
+	if it.txn != nil {
+		it.txn.trackRead(it.indexIter.Key())
+	}
 
-	return it.db.getValueByPosition(logRecordPos)
+	if it.options.Snapshot != nil {
+		return it.options.Snapshot.getValueByPosition(logRecordPos)
+	}
+
+	return it.db.readValueFromFile(it.dataFiles[logRecordPos.Fid], logRecordPos)
 }
 
 // Close closes the iterator to free resources
 func (it *Iterator) Close() {
 	it.indexIter.Close()
-}
 
-func (it *Iterator) skipToNext() {
-	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
-		return
+	if it.dataFiles != nil {
+		it.db.mu.Lock()
+		it.db.unpinDataFiles(it.dataFiles)
+		it.db.mu.Unlock()
+
+		it.dataFiles = nil
 	}
+}
 
+// skipToNext skips the expired keys
+// the index iterator already stops at the end of the prefix and the bounds
+func (it *Iterator) skipToNext() {
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
-		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:prefixLen]) == 0 {
+		// expired keys are treated as missing
+		if !isExpired(it.indexIter.Value().Expire) {
 			break
 		}
 	}
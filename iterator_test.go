@@ -115,3 +115,66 @@ func TestIterator_MultipleValues(t *testing.T) {
 	}
 	iter3.Close()
 }
+
+func TestIterator_Bounds(t *testing.T) {
+	options := DefaultOptions
+	dir, _ := os.MkdirTemp("", "betadb-iterator-bounds")
+	options.DirectoryPath = dir
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for _, key := range []string{"apple", "banana", "bandana", "bank", "cherry"} {
+		err = db.Put([]byte(key), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+
+	collect := func(opts IteratorOptions) []string {
+		iter := db.NewIterator(opts)
+		defer iter.Close()
+
+		var keys []string
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	iterOpts := DefaultIteratorOptions
+	iterOpts.LowerBound = []byte("b")
+	iterOpts.UpperBound = []byte("bank")
+	assert.Equal(t, []string{"banana", "bandana"}, collect(iterOpts))
+
+	iterOpts.Reverse = true
+	assert.Equal(t, []string{"bandana", "banana"}, collect(iterOpts))
+
+	// the prefix narrows the bounds further
+	iterOpts = DefaultIteratorOptions
+	iterOpts.Prefix = []byte("ban")
+	iterOpts.LowerBound = []byte("band")
+	assert.Equal(t, []string{"bandana", "bank"}, collect(iterOpts))
+}
+
+func TestIterator_KeysOnly(t *testing.T) {
+	options := DefaultOptions
+	dir, _ := os.MkdirTemp("", "betadb-iterator-keys")
+	options.DirectoryPath = dir
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+
+	iterOpts := DefaultIteratorOptions
+	iterOpts.KeysOnly = true
+	iter := db.NewIterator(iterOpts)
+	defer iter.Close()
+
+	iter.Rewind()
+	assert.True(t, iter.Valid())
+	assert.Equal(t, utils.GetTestKey(1), iter.Key())
+
+	_, err = iter.Value()
+	assert.Equal(t, ErrKeysOnlyIterator, err)
+}
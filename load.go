@@ -0,0 +1,276 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"github.com/LiuShuoJiang/betadb/data"
+	"io"
+	"sync"
+)
+
+// RecoveryReport reports a torn write discarded from the tail of the active file at startup
+type RecoveryReport struct {
+	// FileID is the id of the active file
+	FileID uint32
+
+	// Offset is where the discarded bytes started, which is the size of the file after the recovery
+	Offset int64
+
+	// DiscardedSize is the number of bytes discarded
+	DiscardedSize int64
+
+	// Err is the error returned when reading the discarded record, such as io.ErrUnexpectedEOF for a record cut off
+	// by the end of the file, null if the discarded bytes do not even start with a record header,
+	// like the zeros of the space preallocated after the data
+	Err error
+}
+
+// dataFileRecords are the decoded records of a data file
+type dataFileRecords struct {
+	records []*data.TransactionRecord
+
+	// size is the offset right after the last record of the data file
+	size int64
+
+	// tornSize is the number of bytes of the torn write at the tail of the active file
+	tornSize int64
+
+	// tornErr is the error returned when reading the torn write
+	tornErr error
+
+	err error
+}
+
+// loadDataFileRecords decodes the data files with a pool of LoadConcurrency workers,
+// and passes the records of each data file to fn in the order of the given data files
+// a worker moves on to the next file as soon as it is done, so a slow file only holds up the files behind it
+func (db *Database) loadDataFileRecords(dataFiles []*data.DataFile,
+	fn func(dataFile *data.DataFile, decoded *dataFileRecords) error) error {
+	concurrency := db.options.LoadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	type decodeJob struct {
+		dataFile *data.DataFile
+		result   chan *dataFileRecords
+	}
+	jobs := make(chan decodeJob)
+	// the results are queued in the order of the files,
+	// which also bounds the number of files decoded ahead of the one being applied
+	results := make(chan chan *dataFileRecords, concurrency)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				job.result <- db.decodeDataFile(job.dataFile)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, dataFile := range dataFiles {
+			result := make(chan *dataFileRecords, 1)
+			select {
+			case results <- result:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- decodeJob{dataFile: dataFile, result: result}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// the data files must not be closed while they are still being decoded
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	for _, dataFile := range dataFiles {
+		decoded := <-<-results
+		if decoded.err != nil {
+			return decoded.err
+		}
+		if err := fn(dataFile, decoded); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeDataFile reads every record of a data file, from its own hint file if the data file is immutable and has one
+// the values are dropped except for the end keys of the range tombstones
+// the records of an immutable file end with the file, while those of the active file end at least where the manifest
+// has recorded, and the records written afterward end at the first one that cannot be read,
+// which is left out of the records along with the rest of the file as a torn write
+// any record that cannot be read before the end fails the decoding
+func (db *Database) decodeDataFile(dataFile *data.DataFile) *dataFileRecords {
+	decoded := &dataFileRecords{}
+	appendRecord := func(logRecord *data.LogRecord, pos *data.LogRecordPos) {
+		decoded.records = append(decoded.records, &data.TransactionRecord{Record: logRecord, Pos: pos})
+	}
+
+	if dataFile != db.activeFile {
+		loaded, err := db.loadIndexFromDataHintFile(dataFile, appendRecord)
+		if err != nil || loaded {
+			decoded.err = err
+			return decoded
+		}
+	}
+
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		decoded.err = err
+		return decoded
+	}
+	dataEnd := fileSize
+	if dataFile == db.activeFile {
+		dataEnd = db.manifest.dataEnd(dataFile.FileID)
+	}
+
+	var readErr error
+	var offset int64 = data.DataFileHeaderSize
+	for offset < dataEnd || dataFile == db.activeFile {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			// a record that passes its checksum but cannot be decrypted is never torn
+			if offset < dataEnd || err == data.ErrEncryptionKeyNotFound || err == data.ErrDecryptionFailed {
+				if err == io.EOF {
+					err = ErrDataFileCorrupted
+				}
+				decoded.err = err
+				return decoded
+			}
+
+			// an empty header, like the zeros preallocated after the data, reads as the end of file
+			if err != io.EOF {
+				readErr = err
+			}
+			break
+		}
+
+		// the key is copied so that the value it is read with can be released
+		record := &data.LogRecord{
+			Key:    append([]byte(nil), logRecord.Key...),
+			Type:   logRecord.Type,
+			Expire: logRecord.Expire,
+		}
+		if logRecord.Type == data.LogRecordRangeDeleted {
+			record.Value = logRecord.Value
+		}
+
+		appendRecord(record, &data.LogRecordPos{
+			Fid:    dataFile.FileID,
+			Offset: offset,
+			Size:   uint32(size),
+			Expire: logRecord.Expire,
+		})
+
+		// increment offset and start reading from the new position next time
+		offset += size
+	}
+
+	decoded.size = offset
+	if dataFile == db.activeFile {
+		decoded.tornSize = max(fileSize-offset, 0)
+		decoded.tornErr = readErr
+	}
+
+	return decoded
+}
+
+// recoverActiveFileEnd finds the end of the data of the active file for the B+ tree index, which never loads it
+// the records written after the end recorded in the manifest are read up to the first one that cannot be read,
+// which is truncated as a torn write along with the rest of the file
+func (db *Database) recoverActiveFileEnd() error {
+	fileSize, err := db.activeFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+
+	decoded := &dataFileRecords{size: db.manifest.dataEnd(db.activeFile.FileID)}
+	if decoded.size > fileSize {
+		return ErrDataFileCorrupted
+	}
+
+	for {
+		_, size, err := db.activeFile.ReadLogRecord(decoded.size)
+		if err != nil {
+			if err == data.ErrEncryptionKeyNotFound || err == data.ErrDecryptionFailed {
+				return err
+			}
+			if err != io.EOF {
+				decoded.tornErr = err
+			}
+			break
+		}
+		decoded.size += size
+	}
+
+	decoded.tornSize = fileSize - decoded.size
+	if decoded.tornSize > 0 {
+		if err := db.recoverTornTail(decoded); err != nil {
+			return err
+		}
+		db.dropTornPositions(decoded.size)
+	}
+	db.activeFile.WriteOffset = decoded.size
+
+	return nil
+}
+
+// dropTornPositions deletes the keys of the B+ tree index pointing past the end of the data of the active file,
+// which were indexed before their records reached the disk
+func (db *Database) dropTornPositions(dataEnd int64) {
+	var tornKeys [][]byte
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.Fid == db.activeFile.FileID && pos.Offset >= dataEnd {
+			tornKeys = append(tornKeys, append([]byte(nil), iterator.Key()...))
+		}
+	}
+	iterator.Close()
+
+	for _, key := range tornKeys {
+		db.index.Delete(key)
+	}
+}
+
+// recoverTornTail truncates the torn write from the tail of the active file and reports it
+func (db *Database) recoverTornTail(decoded *dataFileRecords) error {
+	// the file is truncated through its IO manager, which may have mapped it into memory
+	if err := db.activeFile.Truncate(decoded.size); err != nil {
+		return err
+	}
+
+	if db.options.OnRecovery != nil {
+		db.options.OnRecovery(RecoveryReport{
+			FileID:        db.activeFile.FileID,
+			Offset:        decoded.size,
+			DiscardedSize: decoded.tornSize,
+			Err:           decoded.tornErr,
+		})
+	}
+
+	return nil
+}
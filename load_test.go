@@ -0,0 +1,330 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/fileio"
+	"github.com/LiuShuoJiang/betadb/utils"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDatabase_LoadConcurrency tests for loading the index with different concurrency levels
+func TestDatabase_LoadConcurrency(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+	options.DataFileSize = 8 * 1024
+	options.LoadConcurrency = 1
+
+	db, err := Open(options)
+	assert.Nil(t, err)
+
+	// overwrite, delete and commit batches across many data files
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 500; i++ {
+			err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+			assert.Nil(t, err)
+		}
+
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		for i := round * 100; i < round*100+100; i++ {
+			assert.Nil(t, wb.Delete(utils.GetTestKey(i)))
+		}
+		assert.Nil(t, wb.Put(utils.GetTestKey(1000+round), []byte("batch")))
+		assert.Nil(t, wb.Commit())
+	}
+	err = db.DeleteRange(utils.GetTestKey(450), utils.GetTestKey(480))
+	assert.Nil(t, err)
+
+	expected := make(map[string][]byte)
+	err = db.Fold(func(key []byte, value []byte) bool {
+		expected[string(key)] = value
+		return true
+	})
+	assert.Nil(t, err)
+	stat := db.Stat()
+	assert.True(t, stat.DataFileNum > 10)
+	assert.Nil(t, db.Close())
+
+	check := func(options Options) {
+		db, err := Open(options)
+		assert.Nil(t, err)
+		defer func() {
+			_ = db.Close()
+		}()
+
+		actual := make(map[string][]byte)
+		err = db.Fold(func(key []byte, value []byte) bool {
+			actual[string(key)] = value
+			return true
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+		assert.Equal(t, stat.ReclaimableSize, db.Stat().ReclaimableSize)
+	}
+
+	for _, concurrency := range []int{0, 1, 3, 8} {
+		options.LoadConcurrency = concurrency
+		check(options)
+	}
+
+	// decode the data files themselves instead of their hint files
+	entries, err := os.ReadDir(directory)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), data.HintFileNameSuffix) {
+			assert.Nil(t, os.Remove(filepath.Join(directory, entry.Name())))
+		}
+	}
+	options.LoadConcurrency = 4
+	check(options)
+
+	assert.Nil(t, os.RemoveAll(directory))
+}
+
+// TestDatabase_RecoverTornTail tests for discarding a torn write at the tail of the active file
+func TestDatabase_RecoverTornTail(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+
+	var reports []RecoveryReport
+	options.OnRecovery = func(report RecoveryReport) {
+		reports = append(reports, report)
+	}
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	size := db.activeFile.WriteOffset
+	assert.Nil(t, db.Close())
+
+	appendToFile := func(b []byte) {
+		file, err := os.OpenFile(data.GetDataFileName(directory, 0), os.O_WRONLY|os.O_APPEND, 0644)
+		assert.Nil(t, err)
+		_, err = file.Write(b)
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+	}
+
+	// a record cut off by a crash
+	record, recordSize := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("torn"), nonTransactionSeqNo),
+		Value: utils.RandomValue(64),
+	})
+	appendToFile(record[:recordSize/2])
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, []RecoveryReport{{FileID: 0, Offset: size, DiscardedSize: recordSize / 2, Err: io.ErrUnexpectedEOF}}, reports)
+	assert.Equal(t, size, db.activeFile.WriteOffset)
+	assert.Equal(t, 100, len(db.ListKeys()))
+
+	// the next record is appended right after the last valid one
+	assert.Nil(t, db.Put([]byte("after"), []byte("recovery")))
+	size = db.activeFile.WriteOffset
+	assert.Nil(t, db.Close())
+
+	// a complete record with a broken CRC followed by the zeros left by the file system
+	record[recordSize-1]++
+	appendToFile(record)
+	appendToFile(make([]byte, 4096))
+
+	reports = nil
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, size, reports[0].Offset)
+	assert.Equal(t, recordSize+4096, reports[0].DiscardedSize)
+	assert.Equal(t, data.ErrInvalidCRC, reports[0].Err)
+
+	val, err := db.Get([]byte("after"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("recovery"), val)
+	_, err = db.Get([]byte("torn"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, db.Close())
+
+	// a corrupted record followed by other records is not a torn write
+	file, err := os.OpenFile(data.GetDataFileName(directory, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte("corrupted"), data.DataFileHeaderSize+30)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	reports = nil
+	_, err = Open(options)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	assert.Empty(t, reports)
+}
+
+// TestDatabase_PreallocateDataFiles tests for finding the end of the data of a preallocated active file after a crash
+func TestDatabase_PreallocateDataFiles(t *testing.T) {
+	testCases := []struct {
+		indexType IndexerType
+		ioType    fileio.FileIOType
+	}{
+		{BTree, fileio.StandardFileIO},
+		{BTree, fileio.MemoryMap},
+		{BTree, fileio.IOUring},
+		{BTree, fileio.DirectIO},
+		{BPlusTree, fileio.StandardFileIO},
+	}
+
+	for _, testCase := range testCases {
+		options := DefaultOptions
+		directory, _ := os.MkdirTemp("", "betadb-preallocate")
+		options.DirectoryPath = directory
+		options.DataFileSize = 64 * 1024
+		options.IndexType = testCase.indexType
+		options.IOType = testCase.ioType
+		options.PreallocateDataFiles = true
+
+		var reports []RecoveryReport
+		options.OnRecovery = func(report RecoveryReport) {
+			reports = append(reports, report)
+		}
+
+		db, err := Open(options)
+		assert.Nil(t, err)
+		for i := 0; i < 5000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+		}
+		assert.Greater(t, len(db.olderFiles), 1)
+
+		fileSize := func(directory string, fileID uint32) int64 {
+			fileInfo, err := os.Stat(data.GetDataFileName(directory, fileID))
+			assert.Nil(t, err)
+			return fileInfo.Size()
+		}
+
+		// the active file is allocated up to the data file size, while the immutable files end with their data
+		activeFileID, dataEnd := db.activeFile.FileID, db.activeFile.WriteOffset
+		assert.Equal(t, options.DataFileSize, fileSize(directory, activeFileID))
+		for fileID, dataFile := range db.olderFiles {
+			assert.Equal(t, dataFile.WriteOffset, fileSize(directory, fileID))
+		}
+
+		// copy the directory of the open database, as if it had crashed
+		crashDirectory, _ := os.MkdirTemp("", "betadb-preallocate-crash")
+		assert.Nil(t, utils.CopyDirectory(directory, crashDirectory, []string{fileLockName}))
+		assert.Nil(t, db.Close())
+		assert.Equal(t, dataEnd, fileSize(directory, activeFileID))
+
+		// the records are read up to the preallocated space, which is discarded and allocated again
+		crashOptions := options
+		crashOptions.DirectoryPath = crashDirectory
+		db, err = Open(crashOptions)
+		assert.Nil(t, err)
+		assert.Equal(t, []RecoveryReport{{FileID: activeFileID, Offset: dataEnd, DiscardedSize: options.DataFileSize - dataEnd}},
+			reports)
+		assert.Equal(t, dataEnd, db.activeFile.WriteOffset)
+		assert.Equal(t, options.DataFileSize, fileSize(crashDirectory, activeFileID))
+		for i := 0; i < 5000; i++ {
+			value, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), value)
+		}
+
+		// the next record is appended right after the data
+		assert.Nil(t, db.Put([]byte("after"), []byte("recovery")))
+		dataEnd = db.activeFile.WriteOffset
+		assert.Nil(t, db.Close())
+		assert.Equal(t, dataEnd, fileSize(crashDirectory, activeFileID))
+
+		// the end of the data recorded by Close is read without any recovery
+		reports = nil
+		db, err = Open(crashOptions)
+		assert.Nil(t, err)
+		assert.Empty(t, reports)
+		assert.Equal(t, 5001, len(db.ListKeys()))
+		value, err := db.Get([]byte("after"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("recovery"), value)
+		assert.Nil(t, db.Close())
+
+		assert.Nil(t, os.RemoveAll(directory))
+		assert.Nil(t, os.RemoveAll(crashDirectory))
+	}
+}
+
+// TestDatabase_LoadDataEnd tests for reading the records up to the end of the data rather than up to zeros
+func TestDatabase_LoadDataEnd(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+	options.DataFileSize = 64 * 1024
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 5000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Greater(t, len(db.olderFiles), 0)
+	assert.Nil(t, db.Close())
+
+	// zeros in the middle of an immutable file are not taken for the end of the file
+	// the file is loaded without its hint file
+	assert.Nil(t, os.Remove(data.GetHintFileName(directory, 0)))
+	file, err := os.OpenFile(data.GetDataFileName(directory, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt(make([]byte, 64), data.DataFileHeaderSize)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	_, err = Open(options)
+	assert.Equal(t, ErrDataFileCorrupted, err)
+}
+
+// TestDatabase_LoadPastDataEnd tests for loading the records written after the end of the data recorded in the manifest
+func TestDatabase_LoadPastDataEnd(t *testing.T) {
+	for _, indexType := range []IndexerType{BTree, BPlusTree} {
+		options := DefaultOptions
+		directory, _ := os.MkdirTemp("", "betadb-data-end")
+		options.DirectoryPath = directory
+		options.IndexType = indexType
+
+		db, err := Open(options)
+		assert.Nil(t, err)
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+		}
+
+		// copy the directory of the open database, as if it had crashed before recording the end of the data
+		crashDirectory, _ := os.MkdirTemp("", "betadb-data-end-crash")
+		assert.Nil(t, utils.CopyDirectory(directory, crashDirectory, []string{fileLockName}))
+		destroyDB(db)
+
+		crashOptions := options
+		crashOptions.DirectoryPath = crashDirectory
+		db, err = Open(crashOptions)
+		assert.Nil(t, err)
+		assert.Equal(t, 100, len(db.ListKeys()))
+		for i := 0; i < 100; i++ {
+			value, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), value)
+		}
+		destroyDB(db)
+	}
+}
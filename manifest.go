@@ -0,0 +1,456 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/LiuShuoJiang/betadb/data"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+const (
+	manifestEditKey = "manifest.edit"
+
+	// maxManifestSize is the size beyond which the manifest is rewritten as a single edit at startup
+	maxManifestSize = 1024 * 1024
+)
+
+// manifestTag identifies a field of a manifest edit
+type manifestTag = byte
+
+const (
+	// manifestIndexType sets the index type
+	manifestIndexType manifestTag = iota + 1
+
+	// manifestAddFile adds a live data file
+	manifestAddFile
+
+	// manifestDeleteFile deletes a data file replaced by a merge
+	manifestDeleteFile
+
+	// manifestSeqNo sets the latest transaction sequence number
+	manifestSeqNo
+
+	// manifestSeqNoFileID and manifestSeqNoOffset set where the records written after the sequence number start
+	manifestSeqNoFileID
+	manifestSeqNoOffset
+
+	// manifestNonMergeFileID sets the file id that has not participated in the latest merge
+	manifestNonMergeFileID
+
+	// manifestDataEndFileID and manifestDataEndOffset set where the data of the active file is known to end
+	manifestDataEndFileID
+	manifestDataEndOffset
+)
+
+var errInvalidManifestEdit = errors.New("invalid manifest edit")
+
+// manifestField is a field of a manifest edit
+type manifestField struct {
+	tag   manifestTag
+	value uint64
+}
+
+// manifest is the state of the data directory recorded by the append-only MANIFEST file
+// every edit is written as a single log record, so that it is applied entirely or not at all
+type manifest struct {
+	directoryPath string
+	file          *data.DataFile
+
+	// indexType is the index type of the database
+	indexType IndexerType
+
+	// fileIDs are the ids of the live data files
+	fileIDs map[uint32]struct{}
+
+	// seqNo is the latest transaction sequence number recorded
+	// the records with a later sequence number may only be found from seqNoFileID and seqNoOffset onwards
+	seqNo       uint64
+	seqNoFileID uint32
+	seqNoOffset int64
+
+	// hasMerge indicates whether a merge has been installed, with the file id that has not participated in it
+	hasMerge       bool
+	nonMergeFileID uint32
+
+	// hasDataEnd indicates whether the end of the data of the active file has been recorded,
+	// which is recorded when the active file is created and when the database is closed,
+	// the records written afterward may only be found after it
+	hasDataEnd    bool
+	dataEndFileID uint32
+	dataEndOffset int64
+}
+
+// openManifest opens the manifest of the data directory and replays its edits, null is returned if there is none
+func openManifest(directoryPath string) (*manifest, error) {
+	fileName := filepath.Join(directoryPath, data.ManifestFileName)
+	fileInfo, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manifestFile, err := data.OpenManifestFile(directoryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{
+		directoryPath: directoryPath,
+		file:          manifestFile,
+		fileIDs:       make(map[uint32]struct{}),
+	}
+
+	var offset int64 = 0
+	for offset < fileInfo.Size() {
+		record, size, err := manifestFile.ReadLogRecord(offset)
+		if err != nil {
+			break
+		}
+
+		fields, err := decodeManifestEdit(record.Value)
+		if err != nil {
+			_ = manifestFile.Close()
+			return nil, err
+		}
+		m.apply(fields)
+		offset += size
+	}
+
+	// an edit torn by a crash has never taken effect, it is discarded
+	if offset < fileInfo.Size() {
+		torn, err := manifestFile.IsTornTail(offset)
+		if err == nil && !torn {
+			err = ErrDataDirectoryCorrupted
+		}
+		if err == nil {
+			err = manifestFile.Truncate(offset)
+		}
+		if err != nil {
+			_ = manifestFile.Close()
+			return nil, err
+		}
+	}
+	manifestFile.WriteOffset = offset
+
+	return m, nil
+}
+
+// writeManifest writes a new manifest made of a single edit in place of the existing one
+func writeManifest(directoryPath string, fields []manifestField) (*manifest, error) {
+	tempFileName := filepath.Join(directoryPath, data.ManifestTempFileName)
+	if err := os.RemoveAll(tempFileName); err != nil {
+		return nil, err
+	}
+
+	tempFile, err := data.OpenManifestTempFile(directoryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &data.LogRecord{Key: []byte(manifestEditKey), Value: encodeManifestEdit(fields)}
+	encRecord, _ := data.EncodeLogRecord(record)
+	if err := tempFile.Write(encRecord); err != nil {
+		_ = tempFile.Close()
+		return nil, err
+	}
+	if err := tempFile.Sync(); err != nil {
+		_ = tempFile.Close()
+		return nil, err
+	}
+	if err := tempFile.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tempFileName, filepath.Join(directoryPath, data.ManifestFileName)); err != nil {
+		return nil, err
+	}
+
+	return openManifest(directoryPath)
+}
+
+// append writes an edit to the manifest and applies it once it is on disk
+func (m *manifest) append(fields ...manifestField) error {
+	record := &data.LogRecord{Key: []byte(manifestEditKey), Value: encodeManifestEdit(fields)}
+	encRecord, _ := data.EncodeLogRecord(record)
+	if err := m.file.Write(encRecord); err != nil {
+		return err
+	}
+	if err := m.file.Sync(); err != nil {
+		return err
+	}
+
+	m.apply(fields)
+	return nil
+}
+
+func (m *manifest) apply(fields []manifestField) {
+	for _, field := range fields {
+		switch field.tag {
+		case manifestIndexType:
+			m.indexType = IndexerType(field.value)
+		case manifestAddFile:
+			m.fileIDs[uint32(field.value)] = struct{}{}
+		case manifestDeleteFile:
+			delete(m.fileIDs, uint32(field.value))
+		case manifestSeqNo:
+			m.seqNo = field.value
+		case manifestSeqNoFileID:
+			m.seqNoFileID = uint32(field.value)
+		case manifestSeqNoOffset:
+			m.seqNoOffset = int64(field.value)
+		case manifestNonMergeFileID:
+			m.hasMerge = true
+			m.nonMergeFileID = uint32(field.value)
+		case manifestDataEndFileID:
+			m.hasDataEnd = true
+			m.dataEndFileID = uint32(field.value)
+		case manifestDataEndOffset:
+			m.dataEndOffset = int64(field.value)
+		}
+	}
+}
+
+// snapshot returns a single edit that rebuilds the current state
+func (m *manifest) snapshot() []manifestField {
+	fields := []manifestField{{manifestIndexType, uint64(uint8(m.indexType))}}
+	for _, fileID := range m.sortedFileIDs() {
+		fields = append(fields, manifestField{manifestAddFile, uint64(fileID)})
+	}
+	fields = append(fields, seqNoManifestFields(m.seqNo, m.seqNoFileID, m.seqNoOffset)...)
+	if m.hasMerge {
+		fields = append(fields, manifestField{manifestNonMergeFileID, uint64(m.nonMergeFileID)})
+	}
+	if m.hasDataEnd {
+		fields = append(fields, dataEndManifestFields(m.dataEndFileID, m.dataEndOffset)...)
+	}
+
+	return fields
+}
+
+// dataEnd returns the offset up to which the data of the active file is known to be written
+// the records after it were written since the end was recorded, and may be torn by a crash
+func (m *manifest) dataEnd(fileID uint32) int64 {
+	if m.hasDataEnd && m.dataEndFileID == fileID {
+		return max(m.dataEndOffset, data.DataFileHeaderSize)
+	}
+
+	return data.DataFileHeaderSize
+}
+
+// sortedFileIDs returns the ids of the live data files in ascending order
+func (m *manifest) sortedFileIDs() []uint32 {
+	fileIDs := make([]uint32, 0, len(m.fileIDs))
+	for fileID := range m.fileIDs {
+		fileIDs = append(fileIDs, fileID)
+	}
+	sort.Slice(fileIDs, func(i, j int) bool {
+		return fileIDs[i] < fileIDs[j]
+	})
+
+	return fileIDs
+}
+
+// compact rewrites the manifest as a single edit once it has grown too large
+func (m *manifest) compact() (*manifest, error) {
+	if m.file.WriteOffset <= maxManifestSize {
+		return m, nil
+	}
+
+	if err := m.close(); err != nil {
+		return nil, err
+	}
+	return writeManifest(m.directoryPath, m.snapshot())
+}
+
+func (m *manifest) close() error {
+	return m.file.Close()
+}
+
+// seqNoManifestFields returns the fields recording the sequence number
+// and the position where the records written afterwards start
+func seqNoManifestFields(seqNo uint64, fileID uint32, offset int64) []manifestField {
+	return []manifestField{
+		{manifestSeqNo, seqNo},
+		{manifestSeqNoFileID, uint64(fileID)},
+		{manifestSeqNoOffset, uint64(offset)},
+	}
+}
+
+// dataEndManifestFields returns the fields recording where the data of the active file ends
+func dataEndManifestFields(fileID uint32, offset int64) []manifestField {
+	return []manifestField{
+		{manifestDataEndFileID, uint64(fileID)},
+		{manifestDataEndOffset, uint64(offset)},
+	}
+}
+
+func encodeManifestEdit(fields []manifestField) []byte {
+	buffer := make([]byte, 0, len(fields)*(1+binary.MaxVarintLen64))
+	for _, field := range fields {
+		buffer = append(buffer, field.tag)
+		buffer = binary.AppendUvarint(buffer, field.value)
+	}
+
+	return buffer
+}
+
+func decodeManifestEdit(buffer []byte) ([]manifestField, error) {
+	var fields []manifestField
+	for len(buffer) > 0 {
+		tag := buffer[0]
+		if tag < manifestIndexType || tag > manifestDataEndOffset {
+			return nil, errInvalidManifestEdit
+		}
+
+		value, n := binary.Uvarint(buffer[1:])
+		if n <= 0 {
+			return nil, errInvalidManifestEdit
+		}
+
+		fields = append(fields, manifestField{tag, value})
+		buffer = buffer[1+n:]
+	}
+
+	return fields, nil
+}
+
+// migrateManifest creates the manifest of a data directory written without one,
+// from its data files and the seq-no and merge-finished files used by the earlier versions
+func (db *Database) migrateManifest() error {
+	directoryPath := db.options.DirectoryPath
+	fileIDs, err := listDataFileIDs(directoryPath)
+	if err != nil {
+		return err
+	}
+
+	fields := []manifestField{{manifestIndexType, uint64(uint8(db.options.IndexType))}}
+	for _, fileID := range fileIDs {
+		fields = append(fields, manifestField{manifestAddFile, uint64(fileID)})
+	}
+
+	// the seq-no file is written by Close, so no record has been written after it
+	// without it, the sequence number is recovered from the very first record
+	seqNoFileName := filepath.Join(directoryPath, data.SeqNoFileName)
+	if _, err := os.Stat(seqNoFileName); err == nil && len(fileIDs) > 0 {
+		seqNo, err := readLegacySeqNo(directoryPath)
+		if err != nil {
+			return err
+		}
+
+		lastFileID := fileIDs[len(fileIDs)-1]
+		fileInfo, err := os.Stat(data.GetDataFileName(directoryPath, lastFileID))
+		if err != nil {
+			return err
+		}
+		fields = append(fields, seqNoManifestFields(seqNo, lastFileID, fileInfo.Size())...)
+	}
+
+	mergeFinFileName := filepath.Join(directoryPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinFileName); err == nil {
+		nonMergeFileID, err := db.getNonMergeFileID(directoryPath)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, manifestField{manifestNonMergeFileID, uint64(nonMergeFileID)})
+	}
+
+	if db.manifest, err = writeManifest(directoryPath, fields); err != nil {
+		return err
+	}
+
+	// the state of the earlier files is in the manifest from now on
+	if err := os.RemoveAll(seqNoFileName); err != nil {
+		return err
+	}
+	return os.RemoveAll(mergeFinFileName)
+}
+
+// checkIndexType records the index type in the manifest if it has been switched
+// the B+ tree index is persisted, so it cannot be switched to once there are data files indexed otherwise
+func (db *Database) checkIndexType() error {
+	if db.manifest.indexType == db.options.IndexType {
+		return nil
+	}
+	if db.options.IndexType == BPlusTree && len(db.manifest.fileIDs) > 0 {
+		return ErrIndexTypeMismatch
+	}
+
+	return db.manifest.append(manifestField{manifestIndexType, uint64(uint8(db.options.IndexType))})
+}
+
+// readLegacySeqNo reads the sequence number from the seq-no file used by the earlier versions
+func readLegacySeqNo(directoryPath string) (uint64, error) {
+	seqNoFile, err := data.OpenSeqNoFile(directoryPath)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = seqNoFile.Close()
+	}()
+
+	record, _, err := seqNoFile.ReadLogRecord(0)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(string(record.Value), 10, 64)
+}
+
+// recoverSeqNo recovers the latest transaction sequence number for the B+ tree index, which never loads the data files
+// the records written after the sequence number recorded in the manifest are scanned for a later one
+func (db *Database) recoverSeqNo() error {
+	db.seqNo = db.manifest.seqNo
+
+	for _, fid := range db.fileIDs {
+		fileID := uint32(fid)
+		if fileID < db.manifest.seqNoFileID {
+			continue
+		}
+
+		dataFile := db.olderFiles[fileID]
+		if fileID == db.activeFile.FileID {
+			dataFile = db.activeFile
+		}
+
+		var offset int64 = data.DataFileHeaderSize
+		if fileID == db.manifest.seqNoFileID {
+			offset = max(offset, db.manifest.seqNoOffset)
+		}
+
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err != nil {
+				// the scan stops at the end of the file, or at a record torn by a crash
+				if err == io.EOF || err == io.ErrUnexpectedEOF || err == data.ErrInvalidCRC {
+					break
+				}
+				return err
+			}
+
+			if _, seqNo := parseLogRecordKey(logRecord.Key); seqNo > db.seqNo {
+				db.seqNo = seqNo
+			}
+			offset += size
+		}
+	}
+
+	return nil
+}
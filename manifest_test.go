@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// TestDatabase_ManifestRecoverSeqNo tests for recovering the sequence number of the B+ tree index after a crash
+func TestDatabase_ManifestRecoverSeqNo(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-manifest")
+	options.DirectoryPath = directory
+	options.IndexType = BPlusTree
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+		assert.Nil(t, wb.Commit())
+	}
+	assert.Equal(t, uint64(3), db.seqNo)
+
+	// copy the directory of the open database, as if it had crashed
+	crashDirectory, _ := os.MkdirTemp("", "betadb-manifest-crash")
+	defer func() {
+		_ = os.RemoveAll(crashDirectory)
+	}()
+	assert.Nil(t, utils.CopyDirectory(directory, crashDirectory, []string{fileLockName}))
+
+	crashOptions := options
+	crashOptions.DirectoryPath = crashDirectory
+	db2, err := Open(crashOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), db2.seqNo)
+
+	// transactions can be used without the sequence number saved by Close
+	wb := db2.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(3), utils.RandomValue(24)))
+	assert.Nil(t, wb.Commit())
+	assert.Equal(t, uint64(4), db2.seqNo)
+	assert.Nil(t, db2.Close())
+
+	db2, err = Open(crashOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), db2.seqNo)
+	assert.Equal(t, 4, len(db2.ListKeys()))
+	assert.Nil(t, db2.Close())
+}
+
+// TestDatabase_ManifestMigrate tests for opening a data directory written without a manifest
+func TestDatabase_ManifestMigrate(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-manifest")
+	options.DirectoryPath = directory
+	options.DataFileSize = 32 * 1024
+	options.DataFileMergeRatio = 0
+
+	db, err := Open(options)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	for i := 1000; i < 1100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(2000), utils.RandomValue(64)))
+	assert.Nil(t, wb.Commit())
+	seqNo, nonMergeFileID := db.seqNo, db.manifest.nonMergeFileID
+	assert.Nil(t, db.Close())
+
+	// replace the manifest with the seq-no and merge-finished files of the earlier versions
+	writeRecords := func(file *data.DataFile, records ...*data.LogRecord) {
+		for _, record := range records {
+			encRecord, _ := data.EncodeLogRecord(record)
+			assert.Nil(t, file.Write(encRecord))
+		}
+		assert.Nil(t, file.Close())
+	}
+	assert.Nil(t, os.Remove(filepath.Join(directory, data.ManifestFileName)))
+	seqNoFile, err := data.OpenSeqNoFile(directory)
+	assert.Nil(t, err)
+	writeRecords(seqNoFile, &data.LogRecord{Key: []byte(seqNoKey), Value: []byte(strconv.FormatUint(seqNo, 10))})
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(directory)
+	assert.Nil(t, err)
+	writeRecords(mergeFinishedFile,
+		&data.LogRecord{Key: []byte(mergeFinishedKey), Value: []byte(strconv.Itoa(int(nonMergeFileID)))})
+
+	db, err = Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 601, len(db.ListKeys()))
+	assert.Equal(t, seqNo, db.seqNo)
+	assert.True(t, db.manifest.hasMerge)
+	assert.Equal(t, nonMergeFileID, db.manifest.nonMergeFileID)
+
+	for _, fileName := range []string{data.SeqNoFileName, data.MergeFinishedFileName} {
+		_, err := os.Stat(filepath.Join(directory, fileName))
+		assert.True(t, os.IsNotExist(err))
+	}
+	_, err = os.Stat(filepath.Join(directory, data.ManifestFileName))
+	assert.Nil(t, err)
+}
+
+// TestDatabase_ManifestTornEdit tests for discarding an edit torn by a crash
+func TestDatabase_ManifestTornEdit(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-manifest")
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+	assert.Nil(t, db.Close())
+
+	fileName := filepath.Join(directory, data.ManifestFileName)
+	fileInfo, err := os.Stat(fileName)
+	assert.Nil(t, err)
+
+	encRecord, size := data.EncodeLogRecord(&data.LogRecord{
+		Key:   []byte(manifestEditKey),
+		Value: encodeManifestEdit([]manifestField{{manifestAddFile, 1}}),
+	})
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write(encRecord[:size-1])
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	db, err = Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(db.ListKeys()))
+	assert.Equal(t, []uint32{0}, db.manifest.sortedFileIDs())
+	assert.Equal(t, fileInfo.Size(), db.manifest.file.WriteOffset)
+}
+
+// TestDatabase_ManifestIndexType tests for switching the index type of a data directory
+func TestDatabase_ManifestIndexType(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-manifest")
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+	assert.Nil(t, db.Close())
+
+	// the B+ tree index would miss the existing data
+	options.IndexType = BPlusTree
+	_, err = Open(options)
+	assert.Equal(t, ErrIndexTypeMismatch, err)
+
+	// the in-memory indices are rebuilt from the data files
+	options.IndexType = ART
+	db, err = Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, ART, db.manifest.indexType)
+	assert.Equal(t, 1, len(db.ListKeys()))
+}
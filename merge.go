@@ -13,6 +13,7 @@
 package betadb
 
 import (
+	"context"
 	"github.com/LiuShuoJiang/betadb/data"
 	"github.com/LiuShuoJiang/betadb/utils"
 	"io"
@@ -21,20 +22,92 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	mergeDirectoryName = "-merge"
 	mergeFinishedKey   = "merge.finished"
+	mergedFileNumKey   = "merged.file.num"
+
+	// mergeProgressBytes is the number of bytes processed between two progress reports within a file
+	mergeProgressBytes = 4 * 1024 * 1024
+
+	// mergeThrottleMinDelay is the shortest delay worth sleeping for when throttling a merge
+	mergeThrottleMinDelay = 10 * time.Millisecond
 )
 
+// MergeProgress reports how far a merge has gone
+type MergeProgress struct {
+	// FilesProcessed is the number of data files that have been merged
+	FilesProcessed int
+	// TotalFiles is the number of data files to be merged
+	TotalFiles int
+	// BytesProcessed is the number of bytes that have been read from the data files
+	BytesProcessed int64
+	// TotalBytes is the total size of the data files to be merged
+	TotalBytes int64
+}
+
+// mergeThrottle limits the number of bytes read by a merge per second
+type mergeThrottle struct {
+	bytesPerSecond int64
+	start          time.Time
+	bytes          int64
+}
+
+func newMergeThrottle(bytesPerSecond int64) *mergeThrottle {
+	return &mergeThrottle{
+		bytesPerSecond: bytesPerSecond,
+		start:          time.Now(),
+	}
+}
+
+// wait accounts for n more bytes, and sleeps until they fit in the rate limit
+// it returns the error of the context once the context is done
+func (mt *mergeThrottle) wait(ctx context.Context, n int64) error {
+	if mt.bytesPerSecond <= 0 {
+		return ctx.Err()
+	}
+
+	mt.bytes += n
+	expected := time.Duration(float64(mt.bytes) / float64(mt.bytesPerSecond) * float64(time.Second))
+	delay := expected - time.Since(mt.start)
+	if delay < mergeThrottleMinDelay {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 // Merge cleans the invalid data, and generate hint file
 func (db *Database) Merge() error {
+	return db.MergeWithContext(context.Background(), DefaultMergeOptions)
+}
+
+// MergeWithContext is the same as Merge, but it can be cancelled by the context,
+// throttled and monitored through the merge options
+//
+// a cancelled merge removes its partial merge directory and returns the error of the context
+func (db *Database) MergeWithContext(ctx context.Context, options MergeOptions) error {
 	// if the database is null, return directly
 	if db.activeFile == nil {
 		return nil
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// ========== hold the lock
 	db.mu.Lock()
 
@@ -73,7 +146,9 @@ func (db *Database) Merge() error {
 
 	db.isMerging = true
 	defer func() {
+		db.mu.Lock()
 		db.isMerging = false
+		db.mu.Unlock()
 	}()
 
 	// sync current active file
@@ -84,6 +159,7 @@ func (db *Database) Merge() error {
 	}
 	// convert the current active file to the old data file
 	db.olderFiles[db.activeFile.FileID] = db.activeFile
+	db.writeDataHintFile()
 
 	// open a new active file
 	if err := db.setActiveDataFile(); err != nil {
@@ -108,6 +184,22 @@ func (db *Database) Merge() error {
 		return filesToBeMerged[i].FileID < filesToBeMerged[j].FileID
 	})
 
+	progress := MergeProgress{TotalFiles: len(filesToBeMerged)}
+	for _, dataFile := range filesToBeMerged {
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		progress.TotalBytes += size
+	}
+
+	reportProgress := func() {
+		if options.OnProgress != nil {
+			options.OnProgress(progress)
+		}
+	}
+	throttle := newMergeThrottle(options.BytesPerSecond)
+
 	mergePath := db.getMergePath()
 
 	// if the directory exists, it means that a merge has happened, delete it
@@ -121,25 +213,52 @@ func (db *Database) Merge() error {
 		return err
 	}
 
+	// remove the partial merge directory if the merge fails or is cancelled before it finishes
+	mergeFinished := false
+	defer func() {
+		if !mergeFinished {
+			_ = os.RemoveAll(mergePath)
+		}
+	}()
+
 	// construct a new temporary Database instance
 	mergeOptions := db.options
 	mergeOptions.DirectoryPath = mergePath
 	// set SyncWrites to false to improve efficiency
 	mergeOptions.SyncWrites = false
+	// the temporary instance never merges by itself
+	mergeOptions.AutoMergeInterval = 0
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
 		return err
 	}
+	// the merged files are indexed by the hint index file instead
+	mergeDB.writeDataHints = false
+	defer func() {
+		// the temporary instance is closed before installing, unless the merge fails
+		if mergeDB != nil {
+			_ = mergeDB.Close()
+		}
+	}()
 
 	// open hint file
 	hintFile, err := data.OpenHintFile(mergePath)
 	if err != nil {
 		return err
 	}
+	hintFile.Keyring = db.keyring
+	defer func() {
+		_ = hintFile.Close()
+	}()
+
+	// droppedRecords are the live records dropped for being expired or by the compaction filter,
+	// they are removed from the memory index when the merge is installed
+	var droppedRecords []*data.TransactionRecord
 
 	// iterate and process every data file
 	for _, dataFile := range filesToBeMerged {
-		var offset int64 = 0
+		var offset int64 = data.DataFileHeaderSize
+		progress.BytesProcessed += offset
 
 		for {
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
@@ -150,13 +269,48 @@ func (db *Database) Merge() error {
 				return err
 			}
 
+			// stop as soon as the merge is cancelled, and keep the I/O rate within the limit
+			if err := throttle.wait(ctx, size); err != nil {
+				return err
+			}
+
+			progress.BytesProcessed += size
+			if progress.BytesProcessed/mergeProgressBytes != (progress.BytesProcessed-size)/mergeProgressBytes {
+				reportProgress()
+			}
+
 			// parse the actual key
 			readKey, _ := parseLogRecordKey(logRecord.Key)
 			logRecordPos := db.index.Get(readKey)
 
 			// compare with the index position in memory
-			// and overwrite if valid
+			// and overwrite if valid, the expired records and the records rejected by the filter are dropped
 			if logRecordPos != nil && logRecordPos.Fid == dataFile.FileID && logRecordPos.Offset == offset {
+				keep := !isExpired(logRecord.Expire)
+				if keep && db.options.CompactionFilter != nil {
+					// the filter sees the original value, a compressed value is otherwise copied as stored
+					value, err := db.decompressValue(logRecord)
+					if err != nil {
+						return err
+					}
+
+					var newValue []byte
+					keep, newValue = db.options.CompactionFilter(readKey, value)
+					if keep && newValue != nil {
+						logRecord.Value = newValue
+						logRecord.Compressed = false
+					}
+				}
+
+				if !keep {
+					droppedRecords = append(droppedRecords, &data.TransactionRecord{
+						Record: &data.LogRecord{Key: readKey},
+						Pos:    logRecordPos,
+					})
+					offset += size
+					continue
+				}
+
 				// clear the transaction marking
 				logRecord.Key = logRecordKeyWithSeq(readKey, nonTransactionSeqNo)
 				pos, err := mergeDB.appendLogRecord(logRecord)
@@ -173,6 +327,9 @@ func (db *Database) Merge() error {
 			// add offset
 			offset += size
 		}
+
+		progress.FilesProcessed++
+		reportProgress()
 	}
 
 	// sync the data
@@ -183,36 +340,187 @@ func (db *Database) Merge() error {
 		return err
 	}
 
+	var mergedFileNum uint32 = 0
+	if mergeDB.activeFile != nil {
+		mergedFileNum = mergeDB.activeFile.FileID + 1
+	}
+
+	err = mergeDB.Close()
+	mergeDB = nil
+	if err != nil {
+		return err
+	}
+
 	// write the file indicating merge has finished
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
 
-	// construct the merge-finished record
-	mergeFinishedRecord := &data.LogRecord{
-		Key:   []byte(mergeFinishedKey),
-		Value: []byte(strconv.Itoa(int(nonMergeFileID))),
+	// construct the merge-finished record, followed by the number of merged data files
+	mergeFinishedRecords := []*data.LogRecord{
+		{
+			Key:   []byte(mergeFinishedKey),
+			Value: []byte(strconv.Itoa(int(nonMergeFileID))),
+		},
+		{
+			Key:   []byte(mergedFileNumKey),
+			Value: []byte(strconv.Itoa(int(mergedFileNum))),
+		},
+	}
+
+	for _, record := range mergeFinishedRecords {
+		encodeRecord, _ := data.EncodeLogRecord(record)
+		if err := mergeFinishedFile.Write(encodeRecord); err != nil {
+			return err
+		}
 	}
+	if err := mergeFinishedFile.Sync(); err != nil {
+		return err
+	}
+	mergeFinished = true
+
+	// from now on, the merge is completed by the next Open even if the installation is interrupted
+	return db.installMerge(mergePath, droppedRecords)
+}
 
-	encodeRecord, _ := data.EncodeLogRecord(mergeFinishedRecord)
-	if err := mergeFinishedFile.Write(encodeRecord); err != nil {
+// installMerge installs the merged data files while the database stays open
+//
+// the merged files replace every data file below the non-merge file id, and the memory index is pointed to them.
+// the replaced files are kept open for the snapshots and iterators that still read them
+func (db *Database) installMerge(mergePath string, droppedRecords []*data.TransactionRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	defer func() {
+		_ = os.RemoveAll(mergePath)
+	}()
+
+	// the pinned files are kept open before they are replaced
+	nonMergeFileID, err := db.getNonMergeFileID(mergePath)
+	if err != nil {
 		return err
 	}
-	if err := mergeFinishedFile.Sync(); err != nil {
+	for fileID, dataFile := range db.olderFiles {
+		if fileID >= nonMergeFileID {
+			continue
+		}
+		if err := db.holdPinnedFile(dataFile); err != nil {
+			return err
+		}
+	}
+
+	nonMergeFileID, mergedFileNum, err := db.installMergeFiles(mergePath)
+	if err != nil {
+		return err
+	}
+
+	// open the merged data files
+	mergedFiles := make(map[uint32]*data.DataFile, mergedFileNum)
+	for fileID := uint32(0); fileID < mergedFileNum; fileID++ {
+		dataFile, err := data.OpenDataFile(db.options.DirectoryPath, fileID, db.options.IOType)
+		if err != nil {
+			return err
+		}
+		dataFile.Keyring = db.keyring
+		dataFile.BlockCache = db.blockCache
+		if err := db.cacheDataFile(dataFile, db.options.IOType, false); err != nil {
+			_ = dataFile.Close()
+			return err
+		}
+		mergedFiles[fileID] = dataFile
+	}
+
+	// the keys still located in the replaced files have not been written since the merge started,
+	// so their merged records are the latest ones
+	hintFile, err := data.OpenHintFile(db.options.DirectoryPath)
+	if err != nil {
 		return err
 	}
+	hintFile.Keyring = db.keyring
+	defer func() {
+		_ = hintFile.Close()
+	}()
+
+	var reclaimRecords []*data.LogRecordPos
+	var offset int64 = 0
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if oldPos := db.index.Get(logRecord.Key); oldPos != nil && oldPos.Fid < nonMergeFileID {
+			db.index.Put(logRecord.Key, pos)
+		} else {
+			// the key has been overwritten or deleted during the merge
+			reclaimRecords = append(reclaimRecords, pos)
+		}
+
+		offset += size
+	}
+
+	// the dropped records are gone with the replaced files
+	for _, record := range droppedRecords {
+		pos := db.index.Get(record.Record.Key)
+		if pos != nil && pos.Fid == record.Pos.Fid && pos.Offset == record.Pos.Offset {
+			db.index.Delete(record.Record.Key)
+		}
+	}
+
+	// replace the data files and their reclaimable sizes
+	for fileID, dataFile := range db.olderFiles {
+		if fileID >= nonMergeFileID {
+			continue
+		}
+
+		delete(db.olderFiles, fileID)
+		if err := db.retireDataFile(dataFile); err != nil {
+			return err
+		}
+
+		db.reclaimSize -= db.fileReclaimSize[fileID]
+		delete(db.fileReclaimSize, fileID)
+	}
+
+	for fileID, dataFile := range mergedFiles {
+		db.olderFiles[fileID] = dataFile
+	}
+
+	// the cached values of the replaced files are found at other positions in the merged ones
+	if db.valueCache != nil {
+		db.valueCache.invalidate(func(fid uint32) bool {
+			return fid < nonMergeFileID
+		})
+	}
+
+	for _, pos := range reclaimRecords {
+		db.addReclaimable(pos)
+	}
 
 	return nil
 }
 
 func (db *Database) getMergePath() string {
-	directory := path.Dir(path.Clean(db.options.DirectoryPath))
-	base := path.Base(db.options.DirectoryPath)
-	return filepath.Join(directory, base+mergeDirectoryName)
+	return siblingDirectoryPath(db.options.DirectoryPath, mergeDirectoryName)
+}
+
+// siblingDirectoryPath returns the path of the directory next to the data directory with the given suffix
+func siblingDirectoryPath(directoryPath string, suffix string) string {
+	directory := path.Dir(path.Clean(directoryPath))
+	base := path.Base(directoryPath)
+	return filepath.Join(directory, base+suffix)
 }
 
 // loadMergeFiles loads the merge data directory
+// it completes a merge that has finished but has not been installed, for example because of a crash
 func (db *Database) loadMergeFiles() error {
 	mergePath := db.getMergePath()
 	// if the merge directory does not exist, return directly
@@ -224,63 +532,126 @@ func (db *Database) loadMergeFiles() error {
 		_ = os.RemoveAll(mergePath)
 	}()
 
-	directoryEntries, err := os.ReadDir(mergePath)
+	// find the file indicating merge has finished
+	// in order to check whether merge has been processed
+	mergeFinFileName := filepath.Join(mergePath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinFileName); os.IsNotExist(err) {
+		// if merge has not finished, return directly
+		return nil
+	}
+
+	_, _, err := db.installMergeFiles(mergePath)
+	return err
+}
+
+// installMergeFiles moves the merged data files into the data directory in place of the merged ones
+//
+// every step can be repeated, so the next Open completes an installation interrupted by a crash.
+// the manifest edit replacing the merged files is written last, which completes the installation
+func (db *Database) installMergeFiles(mergePath string) (uint32, uint32, error) {
+	nonMergeFileID, err := db.getNonMergeFileID(mergePath)
 	if err != nil {
-		return err
+		return 0, 0, err
 	}
 
-	// find the file indicating merge has finished
-	// in order to check whether merge has been processed
-	var mergeFinished bool
-	var mergeFileNames []string
-	for _, entry := range directoryEntries {
-		if entry.Name() == data.MergeFinishedFileName {
-			mergeFinished = true
+	mergedFileNum, err := db.getMergedFileNum(mergePath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for fileID := uint32(0); fileID < nonMergeFileID; fileID++ {
+		// the hint file of a compacted data file is no longer valid
+		if err := os.RemoveAll(data.GetHintFileName(db.options.DirectoryPath, fileID)); err != nil {
+			return 0, 0, err
 		}
 
-		if entry.Name() == data.SeqNoFileName {
+		srcPath := data.GetDataFileName(mergePath, fileID)
+		destPath := data.GetDataFileName(db.options.DirectoryPath, fileID)
+
+		if fileID >= mergedFileNum {
+			// delete the old data files that are not replaced by a merged one
+			if err := os.RemoveAll(destPath); err != nil {
+				return 0, 0, err
+			}
 			continue
 		}
-		if entry.Name() == fileLockName {
-			continue
+
+		// a merged data file that is no longer there has been moved already
+		if _, err := os.Stat(srcPath); err == nil {
+			if err := os.Rename(srcPath, destPath); err != nil {
+				return 0, 0, err
+			}
 		}
+	}
 
-		mergeFileNames = append(mergeFileNames, entry.Name())
+	// move the hint file
+	srcPath := filepath.Join(mergePath, data.HintFileName)
+	if _, err := os.Stat(srcPath); err == nil {
+		if err := os.Rename(srcPath, filepath.Join(db.options.DirectoryPath, data.HintFileName)); err != nil {
+			return 0, 0, err
+		}
 	}
 
-	// if merge has not finished, return directly
-	if !mergeFinished {
-		return nil
+	// record the merged files in place of the replaced ones
+	var fields []manifestField
+	for fileID := range db.manifest.fileIDs {
+		if fileID >= mergedFileNum && fileID < nonMergeFileID {
+			fields = append(fields, manifestField{manifestDeleteFile, uint64(fileID)})
+		}
+	}
+	for fileID := uint32(0); fileID < mergedFileNum; fileID++ {
+		fields = append(fields, manifestField{manifestAddFile, uint64(fileID)})
+	}
+	fields = append(fields, manifestField{manifestNonMergeFileID, uint64(nonMergeFileID)})
+	if err := db.manifest.append(fields...); err != nil {
+		return 0, 0, err
 	}
 
-	nonMergeFileID, err := db.getNonMergeFileID(mergePath)
+	return nonMergeFileID, mergedFileNum, nil
+}
+
+// getMergedFileNum gets the number of data files produced by the merge
+func (db *Database) getMergedFileNum(mergePath string) (uint32, error) {
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
 
-	// delete old data files
-	var fileID uint32 = 0
-	for ; fileID < nonMergeFileID; fileID++ {
-		fileName := data.GetDataFileName(db.options.DirectoryPath, fileID)
+	// the number follows the non-merge file id
+	_, size, err := mergeFinishedFile.ReadLogRecord(0)
+	if err != nil {
+		return 0, err
+	}
 
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
-				return err
-			}
+	record, _, err := mergeFinishedFile.ReadLogRecord(size)
+	if err == nil {
+		mergedFileNum, err := strconv.Atoi(string(record.Value))
+		if err != nil {
+			return 0, err
 		}
+		return uint32(mergedFileNum), nil
+	}
+	if err != io.EOF {
+		return 0, err
 	}
 
-	// move the new data files into the data directory
-	for _, fileName := range mergeFileNames {
-		srcPath := filepath.Join(mergePath, fileName)
-		destPath := filepath.Join(db.options.DirectoryPath, fileName)
+	// the earlier merges do not record the number, count the merged data files instead
+	directoryEntries, err := os.ReadDir(mergePath)
+	if err != nil {
+		return 0, err
+	}
 
-		if err := os.Rename(srcPath, destPath); err != nil {
-			return err
+	var mergedFileNum uint32 = 0
+	for _, entry := range directoryEntries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			mergedFileNum++
 		}
 	}
 
-	return nil
+	return mergedFileNum, nil
 }
 
 func (db *Database) getNonMergeFileID(directoryPath string) (uint32, error) {
@@ -288,6 +659,9 @@ func (db *Database) getNonMergeFileID(directoryPath string) (uint32, error) {
 	if err != nil {
 		return 0, err
 	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
 
 	record, _, err := mergeFinishedFile.ReadLogRecord(0)
 	if err != nil {
@@ -315,6 +689,7 @@ func (db *Database) loadIndexFromHintFile() error {
 	if err != nil {
 		return err
 	}
+	hintFile.Keyring = db.keyring
 
 	// read index from file
 	var offset int64 = 0
@@ -330,9 +705,64 @@ func (db *Database) loadIndexFromHintFile() error {
 
 		// decode to get the actual positional index
 		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+
+		// a merged file compacted afterwards is loaded from its own hint file instead
+		if _, ok := db.hintFileIDs[pos.Fid]; !ok {
+			db.index.Put(logRecord.Key, pos)
+		}
 		offset += size
 	}
 
 	return nil
 }
+
+// loadIndexFromDataHintFile loads the indices of a data file from its own hint file
+// every record is passed to fn in the order of the data file, and false is returned if there is no hint file
+func (db *Database) loadIndexFromDataHintFile(dataFile *data.DataFile,
+	fn func(logRecord *data.LogRecord, pos *data.LogRecordPos)) (bool, error) {
+	if _, ok := db.hintFileIDs[dataFile.FileID]; !ok {
+		return false, nil
+	}
+
+	hintFile, err := data.OpenDataHintFile(db.options.DirectoryPath, dataFile.FileID)
+	if err != nil {
+		return false, err
+	}
+	hintFile.Keyring = db.keyring
+	defer func() {
+		_ = hintFile.Close()
+	}()
+
+	var offset int64 = 0
+	for {
+		hintRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return false, err
+		}
+
+		pos := data.DecodeLogRecordPos(hintRecord.Value)
+		logRecord := &data.LogRecord{
+			Key:    hintRecord.Key,
+			Type:   hintRecord.Type,
+			Expire: pos.Expire,
+		}
+
+		// the end key of a range tombstone is only stored in the data file
+		if hintRecord.Type == data.LogRecordRangeDeleted {
+			record, _, err := dataFile.ReadLogRecord(pos.Offset)
+			if err != nil {
+				return false, err
+			}
+			logRecord.Value = record.Value
+		}
+
+		fn(logRecord, pos)
+		offset += size
+	}
+
+	return true, nil
+}
@@ -13,11 +13,15 @@
 package betadb
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"github.com/LiuShuoJiang/betadb/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"sync"
 	"testing"
+	"time"
 )
 
 // TestDatabase_MergeNull tests for merging without any data
@@ -241,3 +245,392 @@ func TestDatabase_MergeWhenWrite(t *testing.T) {
 		assert.NotNil(t, val)
 	}
 }
+
+// TestDatabase_MergeExpired tests that merging drops the expired data
+func TestDatabase_MergeExpired(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DataFileSize = 32 * 1024 * 1024
+	options.DataFileMergeRatio = 0
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(1024), time.Millisecond*100)
+		assert.Nil(t, err)
+	}
+	for i := 10000; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	time.Sleep(time.Millisecond * 200)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// restart database
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(options)
+	defer func() {
+		_ = db2.Close()
+	}()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 10000, db2.index.Size())
+	assert.Equal(t, 10000, len(db2.ListKeys()))
+
+	_, err = db2.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(10000))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDatabase_MergeRangeDeleted(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DataFileSize = 32 * 1024 * 1024
+	options.DataFileMergeRatio = 0
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 50000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	err = db.DeleteRange(utils.GetTestKey(0), utils.GetTestKey(40000))
+	assert.Nil(t, err)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// restart database
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(options)
+	defer func() {
+		_ = db2.Close()
+	}()
+
+	assert.Nil(t, err)
+	keys := db2.ListKeys()
+	assert.Equal(t, 10000, len(keys))
+
+	_, err = db2.Get(utils.GetTestKey(40000))
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(39999))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDatabase_MergeInstallLive(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DataFileSize = 4 * 1024 * 1024
+	options.DataFileMergeRatio = 0
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 15000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.PutWithTTL(utils.GetTestKey(20000), utils.RandomValue(1024), time.Millisecond*10)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 20)
+
+	// an iterator and a snapshot taken before the merge keep reading the replaced files
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	defer iterator.Close()
+	snapshot := db.NewSnapshot()
+	defer snapshot.Release()
+
+	stat := db.Stat()
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// the merged files are installed without reopening the database
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	newStat := db.Stat()
+	assert.Less(t, newStat.DiskSize, stat.DiskSize/2)
+	assert.Less(t, newStat.DataFileNum, stat.DataFileNum)
+	assert.Equal(t, int64(0), newStat.ReclaimableSize)
+	assert.Equal(t, 5000, db.index.Size())
+
+	for i := 15000; i < 20000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+
+	var count int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		_, err := iterator.Value()
+		assert.Nil(t, err)
+		count++
+	}
+	assert.Equal(t, 5000, count)
+
+	val, err := snapshot.Get(utils.GetTestKey(15000))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// the writes after the merge are kept after a restart
+	err = db.Put(utils.GetTestKey(30000), utils.RandomValue(1024))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(15000))
+	assert.Nil(t, err)
+
+	iterator.Close()
+	snapshot.Release()
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(options)
+	defer func() {
+		_ = db2.Close()
+	}()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 5000, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(15000))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(30000))
+	assert.Nil(t, err)
+}
+
+func TestDatabase_MergeThenCompact(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DataFileSize = 1024 * 1024
+	options.DataFileMergeRatio = 0
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 5000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// make the first merged file garbage and compact it
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("new-value"))
+		assert.Nil(t, err)
+	}
+	err = db.Compact(0.5)
+	assert.Nil(t, err)
+
+	// restart database, the compacted merged file is loaded from its own hint file
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(options)
+	defer func() {
+		_ = db2.Close()
+	}()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 5000, len(db2.ListKeys()))
+
+	for i := 0; i < 5000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		if i < 1000 {
+			assert.Equal(t, []byte("new-value"), val)
+		}
+	}
+}
+
+// TestDatabase_MergeWithContextCancel tests for cancelling a merge in the middle
+func TestDatabase_MergeWithContextCancel(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DataFileSize = 1024 * 1024
+	options.DataFileMergeRatio = 0
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 5000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 2500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// a cancelled context stops the merge before it starts
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.MergeWithContext(ctx, DefaultMergeOptions)
+	assert.Equal(t, context.Canceled, err)
+
+	// cancel the merge after the first data file
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	mergeOptions := DefaultMergeOptions
+	mergeOptions.OnProgress = func(progress MergeProgress) {
+		if progress.FilesProcessed > 0 {
+			cancel()
+		}
+	}
+	err = db.MergeWithContext(ctx, mergeOptions)
+	assert.Equal(t, context.Canceled, err)
+
+	// the partial merge directory is removed, and the data is intact
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 2500, len(db.ListKeys()))
+
+	// the database can still be merged and reopened
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 2500, len(db.ListKeys()))
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(utils.GetTestKey(4999))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+// TestDatabase_MergeWithContextProgress tests for the progress report and the rate limit of a merge
+func TestDatabase_MergeWithContextProgress(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DataFileSize = 256 * 1024
+	options.DataFileMergeRatio = 0
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+
+	var progresses []MergeProgress
+	mergeOptions := MergeOptions{
+		BytesPerSecond: 4 * 1024 * 1024,
+		OnProgress: func(progress MergeProgress) {
+			progresses = append(progresses, progress)
+		},
+	}
+
+	start := time.Now()
+	err = db.MergeWithContext(context.Background(), mergeOptions)
+	assert.Nil(t, err)
+
+	// about 1MB of data at 4MB per second
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	assert.NotEmpty(t, progresses)
+	last := progresses[len(progresses)-1]
+	assert.True(t, last.TotalFiles > 1)
+	assert.Equal(t, last.TotalFiles, last.FilesProcessed)
+	assert.Equal(t, last.TotalBytes, last.BytesProcessed)
+	for i := 1; i < len(progresses); i++ {
+		assert.True(t, progresses[i].BytesProcessed >= progresses[i-1].BytesProcessed)
+	}
+
+	assert.Equal(t, 1000, len(db.ListKeys()))
+}
+
+// TestDatabase_MergeCompactionFilter tests for dropping and rewriting records with the compaction filter
+func TestDatabase_MergeCompactionFilter(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DataFileSize = 256 * 1024
+	options.DataFileMergeRatio = 0
+	options.DirectoryPath = directory
+	options.CompactionFilter = func(key, value []byte) (bool, []byte) {
+		if bytes.HasPrefix(key, []byte("session-")) {
+			return false, nil
+		}
+		if bytes.HasPrefix(value, []byte("v1:")) {
+			return true, append([]byte("v2:"), value[3:]...)
+		}
+		return true, nil
+	}
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put([]byte(fmt.Sprintf("session-%d", i)), utils.RandomValue(128))
+		assert.Nil(t, err)
+		err = db.Put([]byte(fmt.Sprintf("user-%d", i)), []byte(fmt.Sprintf("v1:%d", i)))
+		assert.Nil(t, err)
+		err = db.Put([]byte(fmt.Sprintf("item-%d", i)), []byte(fmt.Sprintf("item value %d", i)))
+		assert.Nil(t, err)
+	}
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	check := func(db *Database) {
+		assert.Equal(t, 2000, len(db.ListKeys()))
+
+		_, err := db.Get([]byte("session-1"))
+		assert.Equal(t, ErrKeyNotFound, err)
+
+		val, err := db.Get([]byte("user-1"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v2:1"), val)
+
+		val, err = db.Get([]byte("item-999"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("item value 999"), val)
+	}
+
+	// the live database is repointed to the rewritten records
+	check(db)
+
+	// the hint file is consistent with the rewritten records
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check(db)
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"context"
+	"errors"
+	"github.com/LiuShuoJiang/betadb/utils"
+	"time"
+)
+
+// AutoMergeResult reports a merge started by the background merge worker
+type AutoMergeResult struct {
+	// StartTime is the time when the merge started
+	StartTime time.Time
+
+	// Duration is how long the merge took
+	Duration time.Duration
+
+	// ReclaimableSize is the number of reclaimable bytes when the merge started
+	ReclaimableSize int64
+
+	// DiskSize is the size of the data directory when the merge started
+	DiskSize int64
+
+	// Err is the error returned by the merge, null if it succeeded
+	Err error
+}
+
+// contains checks whether the time of day of t is within the window
+func (w *MergeWindow) contains(t time.Time) bool {
+	year, month, day := t.Date()
+	offset := t.Sub(time.Date(year, month, day, 0, 0, 0, 0, t.Location()))
+
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+
+	// the window wraps around midnight
+	return offset >= w.Start || offset < w.End
+}
+
+// end returns when the window containing t closes
+func (w *MergeWindow) end(t time.Time) time.Time {
+	year, month, day := t.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+
+	// the window wraps around midnight, and t is before midnight
+	if w.Start > w.End && t.Sub(midnight) >= w.Start {
+		midnight = time.Date(year, month, day+1, 0, 0, 0, 0, t.Location())
+	}
+
+	return midnight.Add(w.End)
+}
+
+// startMergeWorker starts the background merge worker if it is enabled by the options
+func (db *Database) startMergeWorker() {
+	if db.options.AutoMergeInterval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	db.mergeWorkerCancel = cancel
+	db.mergeWorkerDone = make(chan struct{})
+
+	go db.runMergeWorker(ctx)
+}
+
+// stopMergeWorker stops the background merge worker, cancelling the running merge and waiting for it to exit
+func (db *Database) stopMergeWorker() {
+	if db.mergeWorkerCancel == nil {
+		return
+	}
+
+	db.mergeWorkerCancel()
+	<-db.mergeWorkerDone
+
+	db.mergeWorkerCancel = nil
+}
+
+func (db *Database) runMergeWorker(ctx context.Context) {
+	defer close(db.mergeWorkerDone)
+
+	ticker := time.NewTicker(db.options.AutoMergeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			db.autoMerge(ctx, now)
+		}
+	}
+}
+
+// autoMerge merges the data files if the window, the reclaimable size and the merge ratio allow it
+func (db *Database) autoMerge(ctx context.Context, now time.Time) {
+	window := db.options.AutoMergeWindow
+	if window != nil && !window.contains(now) {
+		return
+	}
+
+	reclaimableSize, diskSize, ok := db.needAutoMerge()
+	if !ok {
+		return
+	}
+
+	// the merge is stopped once the window closes, reporting context.DeadlineExceeded
+	if window != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, window.end(now))
+		defer cancel()
+	}
+
+	err := db.MergeWithContext(ctx, DefaultMergeOptions)
+	if err == ErrMergeRatioUnreached || err == ErrMergeIsInProgress || errors.Is(err, context.Canceled) {
+		// the state has changed since the check, a merge has been started by hand, or the database is closing
+		return
+	}
+
+	if db.options.OnAutoMerge != nil {
+		db.options.OnAutoMerge(AutoMergeResult{
+			StartTime:       now,
+			Duration:        time.Since(now),
+			ReclaimableSize: reclaimableSize,
+			DiskSize:        diskSize,
+			Err:             err,
+		})
+	}
+}
+
+// needAutoMerge checks whether the reclaimable size has crossed both the minimum size and the merge ratio
+func (db *Database) needAutoMerge() (int64, int64, bool) {
+	db.mu.RLock()
+	reclaimableSize := db.reclaimSize
+	db.mu.RUnlock()
+
+	if reclaimableSize == 0 || reclaimableSize < db.options.AutoMergeMinReclaimSize {
+		return 0, 0, false
+	}
+
+	diskSize, err := utils.DirectorySize(db.options.DirectoryPath)
+	if err != nil || diskSize == 0 {
+		return 0, 0, false
+	}
+
+	if float32(reclaimableSize)/float32(diskSize) < db.options.DataFileMergeRatio {
+		return 0, 0, false
+	}
+
+	return reclaimableSize, diskSize, true
+}
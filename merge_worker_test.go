@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"context"
+	"github.com/LiuShuoJiang/betadb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMergeWindow_Contains(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)
+
+	window1 := &MergeWindow{Start: 2 * time.Hour, End: 4 * time.Hour}
+	assert.True(t, window1.contains(day.Add(2*time.Hour)))
+	assert.True(t, window1.contains(day.Add(3*time.Hour)))
+	assert.False(t, window1.contains(day.Add(4*time.Hour)))
+	assert.False(t, window1.contains(day.Add(23*time.Hour)))
+
+	// the window wraps around midnight
+	window2 := &MergeWindow{Start: 22 * time.Hour, End: 2 * time.Hour}
+	assert.True(t, window2.contains(day.Add(23*time.Hour)))
+	assert.True(t, window2.contains(day.Add(time.Hour)))
+	assert.False(t, window2.contains(day.Add(12*time.Hour)))
+
+	// the window closes on the same day, or on the next day if it wraps around midnight
+	assert.Equal(t, day.Add(4*time.Hour), window1.end(day.Add(3*time.Hour)))
+	assert.Equal(t, day.AddDate(0, 0, 1).Add(2*time.Hour), window2.end(day.Add(23*time.Hour)))
+	assert.Equal(t, day.Add(2*time.Hour), window2.end(day.Add(time.Hour)))
+}
+
+func TestDatabase_AutoMerge(t *testing.T) {
+	results := make(chan AutoMergeResult, 1)
+
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-auto-merge")
+	options.DataFileSize = 32 * 1024 * 1024
+	options.DataFileMergeRatio = 0.5
+	options.DirectoryPath = directory
+	options.AutoMergeInterval = time.Millisecond * 20
+	options.AutoMergeMinReclaimSize = 1024 * 1024
+	options.OnAutoMerge = func(result AutoMergeResult) {
+		results <- result
+	}
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 15000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	select {
+	case result := <-results:
+		assert.Nil(t, result.Err)
+		assert.Greater(t, result.ReclaimableSize, options.AutoMergeMinReclaimSize)
+	case <-time.After(time.Second * 10):
+		t.Fatal("background merge did not run")
+	}
+
+	// restart database
+	err = db.Close()
+	assert.Nil(t, err)
+
+	options.AutoMergeInterval = 0
+	db2, err := Open(options)
+	defer func() {
+		_ = db2.Close()
+	}()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 5000, len(db2.ListKeys()))
+}
+
+func TestDatabase_AutoMergeWindow(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-auto-merge")
+	options.DataFileMergeRatio = 0
+	options.DirectoryPath = directory
+	options.AutoMergeInterval = time.Millisecond * 10
+	// an empty window never opens
+	options.AutoMergeWindow = &MergeWindow{Start: time.Hour, End: time.Hour}
+	options.OnAutoMerge = func(result AutoMergeResult) {
+		t.Error("background merge ran outside of its window")
+	}
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+		err = db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDatabase_AutoMergeWindowEnd(t *testing.T) {
+	var results []AutoMergeResult
+
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-auto-merge")
+	options.DataFileMergeRatio = 0
+	options.DirectoryPath = directory
+	options.OnAutoMerge = func(result AutoMergeResult) {
+		results = append(results, result)
+	}
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+		err = db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// the window closes right after the merge is started
+	now := time.Now()
+	year, month, day := now.Date()
+	offset := now.Sub(time.Date(year, month, day, 0, 0, 0, 0, now.Location()))
+	if offset+time.Millisecond >= 24*time.Hour {
+		t.Skip("the window would wrap around midnight")
+	}
+	db.options.AutoMergeWindow = &MergeWindow{Start: 0, End: offset + time.Millisecond}
+	time.Sleep(time.Millisecond * 2)
+
+	db.autoMerge(context.Background(), now)
+	assert.Equal(t, 1, len(results))
+	assert.ErrorIs(t, results[0].Err, context.DeadlineExceeded)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+}
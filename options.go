@@ -12,7 +12,11 @@
 
 package betadb
 
-import "os"
+import (
+	"github.com/LiuShuoJiang/betadb/fileio"
+	"os"
+	"time"
+)
 
 type Options struct {
 	// DataDirectoryPath is the path to the data directory
@@ -33,8 +37,89 @@ type Options struct {
 	// MMapAtStartUp indicates whether to use mmap to load the data file at startup
 	MMapAtStartUp bool
 
+	// IOType is the IO type of the data files once the database is open, default standard file IO
+	// fileio.MemoryMap maps the data files into memory for the reads and the writes to the active file
+	// fileio.IOUring batches the reads of concurrent Get calls and submits the appends together with their fsync,
+	// falling back to the standard file IO when the kernel does not support io_uring
+	// fileio.DirectIO bypasses the page cache with O_DIRECT, keeping the hot blocks in the block cache instead
+	IOType fileio.FileIOType
+
+	// PreallocateDataFiles allocates each active file up to DataFileSize with fallocate when it is created or reopened,
+	// reducing the fragmentation and the metadata updates of the appends, default false
+	// the space after the data is dropped when the file becomes immutable or the database is closed
+	PreallocateDataFiles bool
+
+	// BlockCacheSize is the number of bytes of the data file blocks cached in memory with fileio.DirectIO,
+	// zero disables the block cache
+	BlockCacheSize int64
+
+	// MaxOpenFiles is the maximum number of data files kept open, zero keeps every data file open
+	// the other data files are closed from the least recently read one and reopened when they are read again,
+	// while the active file and the files being read are always open, so the limit may be exceeded for a while
+	MaxOpenFiles int
+
+	// ValueCacheSize is the number of bytes of the values read from the data files cached in memory,
+	// so that the hot keys are read without any IO or checksum, zero disables the value cache
+	ValueCacheSize int64
+
+	// LoadConcurrency is the number of data files decoded in parallel when loading the index at startup
+	// zero or one loads the data files one by one, which is the default
+	LoadConcurrency int
+
+	// OnRecovery is called when a torn write is discarded from the tail of the active file at startup, default null
+	OnRecovery func(report RecoveryReport)
+
 	// DataFileMergeRatio indicates the threshold of the data file size to the merge size
 	DataFileMergeRatio float32
+
+	// AutoMergeInterval is the interval to check whether a background merge is needed
+	// the default zero value disables the background merge
+	AutoMergeInterval time.Duration
+
+	// AutoMergeWindow limits the background merge to a time of day, default null means any time
+	AutoMergeWindow *MergeWindow
+
+	// AutoMergeMinReclaimSize is the minimum number of reclaimable bytes to start a background merge
+	AutoMergeMinReclaimSize int64
+
+	// OnAutoMerge is called with the result after each background merge, default null
+	OnAutoMerge func(result AutoMergeResult)
+
+	// CompactionFilter is called for every live record copied by a merge, default null keeps all records
+	CompactionFilter CompactionFilter
+
+	// Compression compresses the values written to the data files, default null stores them uncompressed
+	// the values compressed by another compressor can still be read if it is one of the built-in compressors
+	Compression Compressor
+
+	// CompressionMinSize is the size in bytes below which the values are stored uncompressed
+	CompressionMinSize int
+
+	// EncryptionKey encrypts the records written with AES-GCM, it is 16, 24 or 32 bytes for AES-128, AES-192 or AES-256
+	// default null writes the records in plaintext
+	EncryptionKey []byte
+
+	// OldEncryptionKeys are the keys that the records written earlier may still be encrypted with
+	// a merge re-encrypts the records it copies with EncryptionKey, after which the old keys are no longer needed
+	OldEncryptionKeys [][]byte
+
+	// EncryptKeys encrypts the keys in the data and hint files as well as the values,
+	// it is not supported by the B+ tree index, which stores the keys in plaintext
+	EncryptKeys bool
+}
+
+// CompactionFilter decides whether a live record is kept by a merge
+// a kept record is rewritten with newValue unless newValue is null
+type CompactionFilter func(key, value []byte) (keep bool, newValue []byte)
+
+// MergeWindow defines a time-of-day window as the offsets from the local midnight
+// a window whose Start is after its End wraps around midnight
+type MergeWindow struct {
+	// Start is the inclusive start of the window
+	Start time.Duration
+
+	// End is the exclusive end of the window
+	End time.Duration
 }
 
 // IteratorOptions defines the index iterator configuration options
@@ -45,6 +130,28 @@ type IteratorOptions struct {
 	// Reverse indicates whether to traverse in reverse direction
 	// the default value is false, which means forward traversal
 	Reverse bool
+
+	// LowerBound is the inclusive lower bound of the iterated keys, default null means unbounded
+	LowerBound []byte
+
+	// UpperBound is the exclusive upper bound of the iterated keys, default null means unbounded
+	UpperBound []byte
+
+	// KeysOnly indicates the iterator only visits the keys without reading the values from the data files
+	KeysOnly bool
+
+	// Snapshot denotes the snapshot to iterate over, default null means iterating over the latest data
+	Snapshot *Snapshot
+}
+
+// MergeOptions defines the merge configuration options
+type MergeOptions struct {
+	// BytesPerSecond limits how many bytes are read from the data files per second
+	// the default zero value means unlimited
+	BytesPerSecond int64
+
+	// OnProgress is called with the progress after each data file and every few megabytes, default null
+	OnProgress func(progress MergeProgress)
 }
 
 // WriteBatchOptions defines batch writing configuration options
@@ -62,7 +169,8 @@ const (
 	// BTree indicates btree index
 	BTree IndexerType = iota + 1
 
-	// ART indicates Adaptive Radix Tree index
+	// ART indicates the radix tree index, named after the Adaptive Radix Tree it used to be
+	// it is a plain radix tree with path compression whose nodes are copied on write
 	ART
 
 	// BPlusTree indicates b+tree index
@@ -70,18 +178,47 @@ const (
 )
 
 var DefaultOptions = Options{
-	DirectoryPath:      os.TempDir(),
-	DataFileSize:       256 * 1024 * 1024, // 256MB
-	SyncWrites:         false,
-	BytesPerSync:       0,
-	IndexType:          BTree,
-	MMapAtStartUp:      true,
-	DataFileMergeRatio: 0.5,
+	DirectoryPath:        os.TempDir(),
+	DataFileSize:         256 * 1024 * 1024, // 256MB
+	SyncWrites:           false,
+	BytesPerSync:         0,
+	IndexType:            BTree,
+	MMapAtStartUp:        true,
+	IOType:               fileio.StandardFileIO,
+	BlockCacheSize:       32 * 1024 * 1024, // 32MB
+	MaxOpenFiles:         0,
+	ValueCacheSize:       0,
+	PreallocateDataFiles: false,
+	LoadConcurrency:      1,
+	OnRecovery:           nil,
+	DataFileMergeRatio:   0.5,
+
+	AutoMergeInterval:       0,
+	AutoMergeWindow:         nil,
+	AutoMergeMinReclaimSize: 0,
+	OnAutoMerge:             nil,
+	CompactionFilter:        nil,
+
+	Compression:        nil,
+	CompressionMinSize: 256,
+
+	EncryptionKey:     nil,
+	OldEncryptionKeys: nil,
+	EncryptKeys:       false,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
-	Prefix:  nil,
-	Reverse: false,
+	Prefix:     nil,
+	Reverse:    false,
+	LowerBound: nil,
+	UpperBound: nil,
+	KeysOnly:   false,
+	Snapshot:   nil,
+}
+
+var DefaultMergeOptions = MergeOptions{
+	BytesPerSecond: 0,
+	OnProgress:     nil,
 }
 
 var DefaultWriteBatchOptions = WriteBatchOptions{
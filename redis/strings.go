@@ -14,6 +14,7 @@ package redis
 
 import (
 	"encoding/binary"
+	"github.com/LiuShuoJiang/betadb"
 	"time"
 )
 
@@ -45,10 +46,12 @@ func (r *RedisDataStructure) Set(key []byte, ttl time.Duration, value []byte) er
 	copy(encodeValue[:index], buffer[:index])
 	copy(encodeValue[index:], value)
 
-	return r.db.Put(key, encodeValue)
+	// also hand the ttl to the storage engine, so that merge can reclaim the expired value
+	return r.db.PutWithTTL(key, encodeValue, ttl)
 }
 
 // Get implements the get command for String data type
+// an expired key returns betadb.ErrKeyNotFound like a missing one
 func (r *RedisDataStructure) Get(key []byte) ([]byte, error) {
 	encodeValue, err := r.db.Get(key)
 	if err != nil {
@@ -65,9 +68,9 @@ func (r *RedisDataStructure) Get(key []byte) ([]byte, error) {
 	expire, numBytes := binary.Varint(encodeValue[index:])
 	index += numBytes
 
-	// check if the data has expired
+	// check if the data has expired, which the storage engine only checks for the values set with a ttl
 	if expire > 0 && expire <= time.Now().UnixNano() {
-		return nil, nil
+		return nil, betadb.ErrKeyNotFound
 	}
 
 	return encodeValue[index:], nil
@@ -45,6 +45,13 @@ func TestRedisDataStructure_Get(t *testing.T) {
 
 	_, err = rds.Get(utils.GetTestKey(3))
 	assert.Equal(t, betadb.ErrKeyNotFound, err)
+
+	// an expired key is missing as well
+	err = rds.Set(utils.GetTestKey(4), time.Millisecond*10, utils.RandomValue(128))
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 20)
+	_, err = rds.Get(utils.GetTestKey(4))
+	assert.Equal(t, betadb.ErrKeyNotFound, err)
 }
 
 func TestRedisDataStructure_Del(t *testing.T) {
@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"bytes"
+	"errors"
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/fileio"
+	"github.com/LiuShuoJiang/betadb/index"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const repairDirectoryName = "-repair"
+
+// RepairReport reports the result of Repair
+type RepairReport struct {
+	// DirectoryPath is the fresh directory holding the salvaged data
+	DirectoryPath string
+
+	// RecordNum is the number of records salvaged from the data files
+	RecordNum int64
+
+	// SkippedSize is the number of unreadable bytes skipped in the data files
+	SkippedSize int64
+
+	// KeyNum is the number of keys in the repaired database
+	KeyNum int
+}
+
+// Repair salvages every readable record of a closed data directory into a fresh directory next to it
+// the unreadable bytes are skipped until the next record passing its CRC check,
+// and the records are replayed like Open does before the live keys are written into the fresh directory
+// the original directory is left untouched, and the operator swaps the directories once satisfied
+func Repair(directoryPath string) (*RepairReport, error) {
+	fileLock, err := lockDirectory(directoryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = fileLock.Unlock()
+	}()
+
+	report := &RepairReport{DirectoryPath: siblingDirectoryPath(directoryPath, repairDirectoryName)}
+	if _, err := os.Stat(report.DirectoryPath); err == nil {
+		return nil, ErrRepairDirectoryExists
+	}
+
+	fileIDs, err := listDataFileIDs(directoryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dataFiles := make(map[uint32]*data.DataFile, len(fileIDs))
+	defer func() {
+		for _, dataFile := range dataFiles {
+			_ = dataFile.Close()
+		}
+	}()
+
+	// the positions of the live keys, like the memory index built by Open
+	livePositions := make(map[string]*data.LogRecordPos)
+	transactionRecords := make(map[uint64][]*data.TransactionRecord)
+	keysEncrypted := false
+
+	for _, fileID := range fileIDs {
+		dataFile, err := data.OpenDataFile(directoryPath, fileID, fileio.StandardFileIO)
+		if errors.Is(err, data.ErrDataFileHeaderMissing) || errors.Is(err, data.ErrInvalidDataFileHeader) {
+			// the records of a data file without a valid header are salvaged from its beginning
+			dataFile, err = data.OpenLegacyDataFile(directoryPath, fileID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		dataFiles[fileID] = dataFile
+
+		err = salvageDataFile(dataFile, report, func(logRecord *data.LogRecord, pos *data.LogRecordPos) {
+			realKey, seqNo := parseLogRecordKey(logRecord.Key)
+			switch {
+			case logRecord.Encrypted && len(logRecord.Key) == 0:
+				// the encrypted values are copied as stored, but the live keys cannot be told without the keys
+				keysEncrypted = true
+			case logRecord.Type == data.LogRecordRangeDeleted:
+				for key := range livePositions {
+					if bytes.Compare([]byte(key), realKey) >= 0 &&
+						(len(logRecord.Value) == 0 || bytes.Compare([]byte(key), logRecord.Value) < 0) {
+						delete(livePositions, key)
+					}
+				}
+			case seqNo == nonTransactionSeqNo:
+				salvageRecord(livePositions, realKey, logRecord.Type, pos)
+			case logRecord.Type == data.LogRecordTxnFinished:
+				for _, txnRecord := range transactionRecords[seqNo] {
+					salvageRecord(livePositions, txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+				}
+				delete(transactionRecords, seqNo)
+			default:
+				transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
+					Record: &data.LogRecord{Key: realKey, Type: logRecord.Type},
+					Pos:    pos,
+				})
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if keysEncrypted {
+		return nil, ErrKeysEncrypted
+	}
+
+	// keep the index type, since a B+ tree index is never rebuilt from the data files
+	options := DefaultOptions
+	options.DirectoryPath = report.DirectoryPath
+	options.SyncWrites = false
+	if _, err := os.Stat(filepath.Join(directoryPath, index.BPlusTreeIndexFileName)); err == nil {
+		options.IndexType = BPlusTree
+	}
+
+	repairedDB, err := Open(options)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = repairedDB.Close()
+	}()
+
+	keys := make([]string, 0, len(livePositions))
+	for key := range livePositions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		pos := livePositions[key]
+		if isExpired(pos.Expire) {
+			continue
+		}
+
+		logRecord, _, err := dataFiles[pos.Fid].ReadLogRecord(pos.Offset)
+		if err != nil {
+			return nil, err
+		}
+		// the value is copied as stored, so that a compressed or encrypted value stays so
+		if err := repairedDB.putLogRecord([]byte(key), &data.LogRecord{
+			Key:        logRecordKeyWithSeq([]byte(key), nonTransactionSeqNo),
+			Value:      logRecord.Value,
+			Type:       data.LogRecordNormal,
+			Expire:     pos.Expire,
+			Compressed: logRecord.Compressed,
+			Encrypted:  logRecord.Encrypted,
+		}); err != nil {
+			return nil, err
+		}
+		report.KeyNum++
+	}
+
+	if err := repairedDB.Sync(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// salvageRecord applies a committed record to the positions of the live keys
+func salvageRecord(livePositions map[string]*data.LogRecordPos, key []byte, recordType data.LogRecordType,
+	pos *data.LogRecordPos) {
+	if recordType == data.LogRecordNormal {
+		livePositions[string(key)] = pos
+	} else {
+		delete(livePositions, string(key))
+	}
+}
+
+// salvageDataFile passes every readable record of a data file to fn in order
+// an unreadable byte is skipped, until a record passing its CRC check is found
+func salvageDataFile(dataFile *data.DataFile, report *RepairReport,
+	fn func(logRecord *data.LogRecord, pos *data.LogRecordPos)) error {
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+
+	var offset int64 = 0
+	if dataFile.Header != nil {
+		offset = data.DataFileHeaderSize
+	}
+	for offset < fileSize {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF && err != data.ErrInvalidCRC {
+				return err
+			}
+
+			offset++
+			report.SkippedSize++
+			continue
+		}
+
+		report.RecordNum++
+		fn(logRecord, &data.LogRecordPos{
+			Fid:    dataFile.FileID,
+			Offset: offset,
+			Size:   uint32(size),
+			Expire: logRecord.Expire,
+		})
+		offset += size
+	}
+
+	return nil
+}
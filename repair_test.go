@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRepair tests for salvaging the readable records of a damaged data directory
+func TestRepair(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb")
+	options.DirectoryPath = directory
+	options.DataFileSize = 32 * 1024
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("old value")))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.DeleteRange(utils.GetTestKey(900), nil))
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch"), []byte("committed")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(100)))
+	assert.Nil(t, wb.Commit())
+
+	// an interrupted transaction is not salvaged
+	db.mu.Lock()
+	_, err = db.appendLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("txn"), 114),
+		Value: []byte("uncommitted"),
+	})
+	db.mu.Unlock()
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("ttl"), []byte("value")))
+	assert.Nil(t, db.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour))
+	assert.Nil(t, db.Close())
+
+	// corrupt the first data file, which only holds the old values
+	file, err := os.OpenFile(data.GetDataFileName(directory, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte("corrupted"), 100)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	report, err := Repair(directory)
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.RemoveAll(report.DirectoryPath)
+	}()
+	assert.True(t, report.SkippedSize > 0)
+	assert.Equal(t, 800-1+1+1, report.KeyNum)
+
+	// the original directory is left untouched
+	_, err = Repair(directory)
+	assert.Equal(t, ErrRepairDirectoryExists, err)
+
+	options.DirectoryPath = report.DirectoryPath
+	repairedDB, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		_ = repairedDB.Close()
+	}()
+
+	assert.Equal(t, report.KeyNum, len(repairedDB.ListKeys()))
+	for i := 101; i < 900; i++ {
+		val, err := repairedDB.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	for _, i := range []int{0, 99, 100, 900, 999} {
+		_, err := repairedDB.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+
+	val, err := repairedDB.Get([]byte("batch"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("committed"), val)
+	_, err = repairedDB.Get([]byte("txn"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	ttl, err := repairedDB.TTL([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 50*time.Minute)
+}
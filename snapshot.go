@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/index"
+	"sync"
+)
+
+// Snapshot is a read-only point-in-time view of the database
+//
+// it pins the transaction sequence number, a snapshot of the memory index
+// and the data files referenced by the index until it is released
+type Snapshot struct {
+	db *Database
+	mu *sync.RWMutex
+
+	// seqNo is the transaction sequence number when the snapshot is taken
+	seqNo uint64
+
+	// index is the point-in-time view of the memory index
+	index index.Indexer
+
+	// dataFiles are the data files pinned by the snapshot
+	dataFiles map[uint32]*data.DataFile
+
+	// released indicates whether the snapshot has been released
+	released bool
+}
+
+// NewSnapshot takes a snapshot of the current database
+// the snapshot must be released after use, otherwise the pinned data files cannot be freed
+func (db *Database) NewSnapshot() *Snapshot {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.newSnapshot()
+}
+
+// newSnapshot takes a snapshot of the current database
+// must hold a mutex lock before accessing this method
+func (db *Database) newSnapshot() *Snapshot {
+	return &Snapshot{
+		db:        db,
+		mu:        new(sync.RWMutex),
+		seqNo:     db.seqNo,
+		index:     db.index.Snapshot(),
+		dataFiles: db.pinDataFiles(),
+	}
+}
+
+// pinDataFiles pins the current data files, so that they stay open until they are unpinned
+// even if they are replaced by a compaction in the meantime
+// must hold a mutex lock before accessing this method
+func (db *Database) pinDataFiles() map[uint32]*data.DataFile {
+	dataFiles := make(map[uint32]*data.DataFile, len(db.olderFiles)+1)
+	for fileID, dataFile := range db.olderFiles {
+		dataFiles[fileID] = dataFile
+	}
+	if db.activeFile != nil {
+		dataFiles[db.activeFile.FileID] = db.activeFile
+	}
+
+	for _, dataFile := range dataFiles {
+		db.pinnedFiles[dataFile]++
+	}
+
+	return dataFiles
+}
+
+// unpinDataFiles unpins the data files and closes the retired ones that are no longer used
+// must hold a mutex lock before accessing this method
+func (db *Database) unpinDataFiles(dataFiles map[uint32]*data.DataFile) {
+	for _, dataFile := range dataFiles {
+		if db.pinnedFiles[dataFile]--; db.pinnedFiles[dataFile] > 0 {
+			continue
+		}
+		delete(db.pinnedFiles, dataFile)
+
+		if _, ok := db.retiredFiles[dataFile]; ok {
+			delete(db.retiredFiles, dataFile)
+			_ = dataFile.Close()
+		}
+	}
+}
+
+// retireDataFile closes a data file that has been replaced
+// the file is kept open until it is unpinned if some readers still use it
+// must hold a mutex lock before accessing this method
+func (db *Database) retireDataFile(dataFile *data.DataFile) error {
+	if db.pinnedFiles[dataFile] > 0 {
+		db.retiredFiles[dataFile] = struct{}{}
+		return nil
+	}
+
+	return dataFile.Close()
+}
+
+// SeqNo returns the transaction sequence number that the snapshot is pinned at
+func (s *Snapshot) SeqNo() uint64 {
+	return s.seqNo
+}
+
+// Get obtains the data of the key in the snapshot
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	logRecordPos := s.index.Get(key)
+	if logRecordPos == nil || isExpired(logRecordPos.Expire) {
+		return nil, ErrKeyNotFound
+	}
+
+	return s.getValueByPosition(logRecordPos)
+}
+
+// ListKeys lists all the keys within the snapshot
+func (s *Snapshot) ListKeys() [][]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.released {
+		return nil
+	}
+
+	iterator := s.index.Iterator(false)
+	defer iterator.Close()
+
+	keys := make([][]byte, 0, s.index.Size())
+
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		// skip the expired keys
+		if isExpired(iterator.Value().Expire) {
+			continue
+		}
+		keys = append(keys, iterator.Key())
+	}
+
+	return keys
+}
+
+// Fold obtains all data in the snapshot and performs the operations specified by the user
+// the traversal is terminated when the function returns false
+func (s *Snapshot) Fold(fn func(key []byte, value []byte) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.released {
+		return ErrSnapshotReleased
+	}
+
+	iterator := s.index.Iterator(false)
+	defer iterator.Close() // remember to close the iterator
+
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		logRecordPos := iterator.Value()
+		if isExpired(logRecordPos.Expire) {
+			continue
+		}
+
+		value, err := s.getValueByPosition(logRecordPos)
+		if err != nil {
+			return err
+		}
+
+		if !fn(iterator.Key(), value) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Release unpins the data files and frees the index snapshot
+// it is safe to call Release more than once
+func (s *Snapshot) Release() {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	_ = s.release()
+}
+
+// release is the same as Release, but the database lock must be held
+func (s *Snapshot) release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.released {
+		return nil
+	}
+	s.released = true
+	s.db.unpinDataFiles(s.dataFiles)
+
+	return s.index.Close()
+}
+
+// getValueByPosition gets the corresponding value from the data files pinned by the snapshot
+func (s *Snapshot) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	return s.db.readValueFromFile(s.dataFiles[logRecordPos.Fid], logRecordPos)
+}
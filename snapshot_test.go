@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"github.com/LiuShuoJiang/betadb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDatabase_NewSnapshot(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-snapshot")
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	snapshot := db.NewSnapshot()
+	assert.Equal(t, len(db.olderFiles)+1, len(db.pinnedFiles))
+
+	// the writes after the snapshot are invisible to it
+	for i := 0; i < 50; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 100; i < 200; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Put(utils.GetTestKey(99), utils.RandomValue(128))
+	assert.Nil(t, err)
+
+	value1, err := snapshot.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(0), value1)
+	value2, err := snapshot.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(99), value2)
+	_, err = snapshot.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Equal(t, 100, len(snapshot.ListKeys()))
+	assert.Equal(t, 150, len(db.ListKeys()))
+
+	var count int
+	err = snapshot.Fold(func(key []byte, value []byte) bool {
+		assert.Equal(t, key, value)
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, count)
+
+	// iterate over the snapshot
+	iteratorOptions := DefaultIteratorOptions
+	iteratorOptions.Snapshot = snapshot
+	iterator := db.NewIterator(iteratorOptions)
+	count = 0
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		value, err := iterator.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, iterator.Key(), value)
+		count++
+	}
+	iterator.Close()
+	assert.Equal(t, 100, count)
+
+	// release the snapshot
+	snapshot.Release()
+	snapshot.Release()
+	assert.Equal(t, 0, len(db.pinnedFiles))
+	_, err = snapshot.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrSnapshotReleased, err)
+}
+
+func TestDatabase_FoldWithWrites(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-snapshot")
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	// writing inside the traversal neither blocks nor changes what is traversed
+	var count int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		err := db.Delete(key)
+		assert.Nil(t, err)
+		err = db.Put(append([]byte("new-"), key...), value)
+		assert.Nil(t, err)
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, count)
+	assert.Equal(t, 100, len(db.ListKeys()))
+
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
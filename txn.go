@@ -0,0 +1,303 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"github.com/LiuShuoJiang/betadb/data"
+	"sort"
+	"sync"
+)
+
+// txnWriteLogSize is the number of writes kept for the conflict detection of the active transactions
+// a transaction that began before the oldest write kept conflicts if it has read any key
+const txnWriteLogSize = 1 << 16
+
+// txnWrite is a write kept in the write log of the database
+type txnWrite struct {
+	version uint64
+	key     string
+}
+
+// Txn is an optimistic transaction
+//
+// the reads are served from a snapshot pinned at the sequence number when the transaction begins,
+// and the commit fails with ErrTxnConflict if any key read by the transaction has been overwritten since then
+type Txn struct {
+	db       *Database
+	mu       *sync.Mutex
+	readOnly bool
+
+	// snapshot is the read view of the transaction
+	snapshot *Snapshot
+
+	// reads records the keys read by the transaction
+	reads map[string]struct{}
+
+	// beginVersion is the write version of the database when the transaction began
+	// the writes with a later version in the write log of the database conflict with the reads
+	beginVersion uint64
+
+	// pendingWrites temporarily stores the user-written data
+	pendingWrites map[string]*data.LogRecord
+
+	// finished indicates whether the transaction has been committed or discarded
+	finished bool
+}
+
+// Begin starts a new transaction
+// a read-only transaction never conflicts, but it cannot write any data
+func (db *Database) Begin(readOnly bool) *Txn {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	txn := &Txn{
+		db:            db,
+		mu:            new(sync.Mutex),
+		readOnly:      readOnly,
+		snapshot:      db.newSnapshot(),
+		reads:         make(map[string]struct{}),
+		beginVersion:  db.writeVersion,
+		pendingWrites: make(map[string]*data.LogRecord),
+	}
+
+	// only the read-write transactions need to know about the conflicting writes
+	if !readOnly {
+		db.activeTxns[txn] = struct{}{}
+	}
+
+	return txn
+}
+
+// ReadSeqNo returns the transaction sequence number that the read snapshot is pinned at
+func (txn *Txn) ReadSeqNo() uint64 {
+	return txn.snapshot.SeqNo()
+}
+
+// Get obtains data by the key
+// the pending writes of the transaction itself are visible
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return nil, ErrTxnClosed
+	}
+
+	// read your own writes
+	if record := txn.pendingWrites[string(key)]; record != nil {
+		if record.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	txn.trackReadLocked(key)
+
+	return txn.snapshot.Get(key)
+}
+
+// Put writes the data in the transaction
+func (txn *Txn) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return ErrTxnClosed
+	}
+	if txn.readOnly {
+		return ErrTxnReadOnly
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:   key,
+		Value: value,
+	}
+
+	return nil
+}
+
+// Delete deletes the data in the transaction
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return ErrTxnClosed
+	}
+	if txn.readOnly {
+		return ErrTxnReadOnly
+	}
+
+	// the delete is written even if the key is missing from the snapshot,
+	// since the key may have been written after the snapshot, and the others must see it as a conflicting write
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:  key,
+		Type: data.LogRecordDeleted,
+	}
+
+	return nil
+}
+
+// Iterator creates an iterator over the read snapshot of the transaction
+// the pending writes of the transaction are not visible to the iterator,
+// and the values read through the iterator are tracked for conflict detection
+func (txn *Txn) Iterator(opts IteratorOptions) *Iterator {
+	opts.Snapshot = txn.snapshot
+	iterator := txn.db.NewIterator(opts)
+	iterator.txn = txn
+
+	return iterator
+}
+
+// Commit writes the pending data atomically
+// returns ErrTxnConflict if a key read by the transaction has been overwritten after the snapshot
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return ErrTxnClosed
+	}
+
+	// locking ensures transaction serialization
+	txn.db.mu.Lock()
+	defer txn.db.mu.Unlock()
+
+	// the write log is checked before releasing the transaction, which drops the writes it no longer needs
+	conflicted := txn.conflicts()
+	if err := txn.release(); err != nil {
+		return err
+	}
+
+	if txn.readOnly || len(txn.pendingWrites) == 0 {
+		return nil
+	}
+
+	if conflicted {
+		return ErrTxnConflict
+	}
+
+	return txn.db.writeTransaction(txn.pendingWrites, false)
+}
+
+// Discard drops the pending data and releases the snapshot
+// it is safe to call Discard after Commit
+func (txn *Txn) Discard() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return
+	}
+
+	txn.db.mu.Lock()
+	defer txn.db.mu.Unlock()
+
+	_ = txn.release()
+}
+
+// trackRead records the key read by the transaction for conflict detection
+func (txn *Txn) trackRead(key []byte) {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	txn.trackReadLocked(key)
+}
+
+// trackReadLocked is the same as trackRead, but the transaction lock must be held
+func (txn *Txn) trackReadLocked(key []byte) {
+	if !txn.readOnly {
+		txn.reads[string(key)] = struct{}{}
+	}
+}
+
+// conflicts checks whether any key read by the transaction has been written since it began
+// must hold both the transaction lock and the database lock before accessing this method
+func (txn *Txn) conflicts() bool {
+	if len(txn.reads) == 0 {
+		return false
+	}
+
+	// the writes dropped from the write log may have overwritten any key
+	if txn.beginVersion < txn.db.writeLogFloor {
+		return true
+	}
+
+	writeLog := txn.db.writeLog
+	start := sort.Search(len(writeLog), func(i int) bool {
+		return writeLog[i].version > txn.beginVersion
+	})
+	for _, write := range writeLog[start:] {
+		if _, ok := txn.reads[write.key]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// release unregisters the transaction and closes its snapshot
+// must hold both the transaction lock and the database lock before accessing this method
+func (txn *Txn) release() error {
+	txn.finished = true
+	delete(txn.db.activeTxns, txn)
+	txn.db.trimWriteLog()
+
+	return txn.snapshot.release()
+}
+
+// trackWrite logs the write of the key for the conflict detection of the active transactions
+// must hold a mutex lock before accessing this method
+func (db *Database) trackWrite(key []byte) {
+	db.writeVersion++
+	if len(db.activeTxns) == 0 {
+		return
+	}
+
+	// the oldest half of a full write log is dropped, the transactions that began before then conflict at commit
+	if len(db.writeLog) == txnWriteLogSize {
+		db.writeLogFloor = db.writeLog[txnWriteLogSize/2-1].version
+		db.writeLog = append(db.writeLog[:0], db.writeLog[txnWriteLogSize/2:]...)
+	}
+	db.writeLog = append(db.writeLog, txnWrite{version: db.writeVersion, key: string(key)})
+}
+
+// trimWriteLog drops the writes that no active transaction began before
+// must hold a mutex lock before accessing this method
+func (db *Database) trimWriteLog() {
+	if len(db.activeTxns) == 0 {
+		db.writeLog = nil
+		return
+	}
+
+	oldestVersion := db.writeVersion
+	for txn := range db.activeTxns {
+		oldestVersion = min(oldestVersion, txn.beginVersion)
+	}
+	start := sort.Search(len(db.writeLog), func(i int) bool {
+		return db.writeLog[i].version > oldestVersion
+	})
+	db.writeLog = append(db.writeLog[:0], db.writeLog[start:]...)
+}
@@ -0,0 +1,295 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"github.com/LiuShuoJiang/betadb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestTxn_Commit(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-txn")
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(128))
+	assert.Nil(t, err)
+
+	txn := db.Begin(false)
+	err = txn.Put(utils.GetTestKey(2), utils.RandomValue(128))
+	assert.Nil(t, err)
+	err = txn.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	// read your own writes
+	value1, err := txn.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.NotNil(t, value1)
+	_, err = txn.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// the pending writes are invisible to others before commit
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+
+	value2, err := db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, value1, value2)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// the transaction cannot be used after commit
+	err = txn.Put(utils.GetTestKey(3), utils.RandomValue(128))
+	assert.Equal(t, ErrTxnClosed, err)
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnClosed, err)
+
+	// restart database
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(options)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+
+	value3, err := db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, value1, value3)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, uint64(1), db2.seqNo)
+}
+
+func TestTxn_Snapshot(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-txn")
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	value1 := utils.RandomValue(128)
+	err = db.Put(utils.GetTestKey(1), value1)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(128))
+	assert.Nil(t, err)
+
+	txn := db.Begin(true)
+	defer txn.Discard()
+
+	// the writes after the transaction begins are invisible
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(128))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(128))
+	assert.Nil(t, err)
+
+	value2, err := txn.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value1, value2)
+	_, err = txn.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	_, err = txn.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	iterator := txn.Iterator(DefaultIteratorOptions)
+	var keys [][]byte
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys = append(keys, iterator.Key())
+	}
+	iterator.Close()
+	assert.Equal(t, [][]byte{utils.GetTestKey(1), utils.GetTestKey(2)}, keys)
+
+	// a read-only transaction cannot write
+	err = txn.Put(utils.GetTestKey(4), utils.RandomValue(128))
+	assert.Equal(t, ErrTxnReadOnly, err)
+	err = txn.Commit()
+	assert.Nil(t, err)
+}
+
+func TestTxn_Conflict(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-txn")
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(128))
+	assert.Nil(t, err)
+
+	// (1) test for the key read being overwritten by a normal write
+	txn1 := db.Begin(false)
+	_, err = txn1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn1.Put(utils.GetTestKey(2), utils.RandomValue(128))
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(128))
+	assert.Nil(t, err)
+
+	err = txn1.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// (2) test for the key read being overwritten by another transaction
+	txn2 := db.Begin(false)
+	txn3 := db.Begin(false)
+	_, err = txn2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn2.Put(utils.GetTestKey(1), utils.RandomValue(128))
+	assert.Nil(t, err)
+	_, err = txn3.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn3.Put(utils.GetTestKey(1), utils.RandomValue(128))
+	assert.Nil(t, err)
+
+	err = txn2.Commit()
+	assert.Nil(t, err)
+	err = txn3.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	// (3) test for blind writes, which never conflict
+	txn4 := db.Begin(false)
+	err = txn4.Put(utils.GetTestKey(1), utils.RandomValue(128))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(128))
+	assert.Nil(t, err)
+	err = txn4.Commit()
+	assert.Nil(t, err)
+
+	// (4) test for the values read through the iterator
+	txn5 := db.Begin(false)
+	iterator := txn5.Iterator(DefaultIteratorOptions)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		_, err := iterator.Value()
+		assert.Nil(t, err)
+	}
+	iterator.Close()
+	err = txn5.Put(utils.GetTestKey(3), utils.RandomValue(128))
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(utils.GetTestKey(1), utils.RandomValue(128))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	err = txn5.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	assert.Equal(t, 0, len(db.activeTxns))
+	assert.Empty(t, db.writeLog)
+}
+
+func TestTxn_DeleteMissingKey(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-txn")
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// the key is written after the snapshot of the transaction deleting it
+	txn1 := db.Begin(false)
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(128))
+	assert.Nil(t, err)
+	txn2 := db.Begin(false)
+
+	err = txn1.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn1.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// the delete conflicts with the transaction that read the key
+	_, err = txn2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn2.Put(utils.GetTestKey(2), utils.RandomValue(128))
+	assert.Nil(t, err)
+
+	err = txn1.Commit()
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = txn2.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+}
+
+func TestTxn_WriteLog(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-txn")
+	options.DirectoryPath = directory
+
+	db, err := Open(options)
+	defer destroyDB(db)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(0), utils.RandomValue(8))
+	assert.Nil(t, err)
+
+	// the writes are only logged while there are active transactions
+	for i := 1; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(8))
+		assert.Nil(t, err)
+	}
+	assert.Empty(t, db.writeLog)
+
+	txn1 := db.Begin(false)
+	_, err = txn1.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	err = txn1.Put(utils.GetTestKey(1), utils.RandomValue(8))
+	assert.Nil(t, err)
+
+	// the write log stays bounded, and the dropped writes conflict with the older transactions
+	for i := 0; i <= txnWriteLogSize; i++ {
+		err := db.Put(utils.GetTestKey(i%100+1), utils.RandomValue(8))
+		assert.Nil(t, err)
+	}
+	assert.LessOrEqual(t, len(db.writeLog), txnWriteLogSize)
+
+	txn2 := db.Begin(false)
+	_, err = txn2.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	err = txn2.Put(utils.GetTestKey(2), utils.RandomValue(8))
+	assert.Nil(t, err)
+
+	err = txn1.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	err = txn2.Commit()
+	assert.Nil(t, err)
+	assert.Empty(t, db.writeLog)
+}
@@ -0,0 +1,352 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"errors"
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/fileio"
+	"github.com/LiuShuoJiang/betadb/index"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	upgradeDirectoryName    = "-upgrade"
+	upgradeFinishedFileName = "upgrade-finished"
+)
+
+// UpgradeReport reports the result of Upgrade
+type UpgradeReport struct {
+	// FileNum is the number of data files rewritten in the current format
+	FileNum int
+}
+
+// Upgrade rewrites the data files of a closed data directory written in the earlier format, which have no header
+//
+// every record moves by the size of the header, so the hint files, the B+ tree index and the manifest are rewritten too.
+// the upgraded files are written next to the data directory and moved into it once they are all complete,
+// so Upgrade can be run again to complete an upgrade interrupted by a crash
+func Upgrade(directoryPath string) (*UpgradeReport, error) {
+	fileLock, err := lockDirectory(directoryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = fileLock.Unlock()
+	}()
+
+	report := &UpgradeReport{}
+
+	// a merge waiting to be installed by the next Open is upgraded as well
+	for _, path := range []string{directoryPath, siblingDirectoryPath(directoryPath, mergeDirectoryName)} {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		fileNum, err := upgradeDirectory(path)
+		if err != nil {
+			return nil, err
+		}
+		report.FileNum += fileNum
+	}
+
+	return report, nil
+}
+
+// upgradeDirectory upgrades the data files of a directory and the files pointing into them
+func upgradeDirectory(directoryPath string) (int, error) {
+	upgradePath := siblingDirectoryPath(directoryPath, upgradeDirectoryName)
+
+	// complete the previous upgrade if all its files have been written, otherwise start over
+	if _, err := os.Stat(filepath.Join(upgradePath, upgradeFinishedFileName)); err == nil {
+		if err := installUpgradeFiles(directoryPath, upgradePath); err != nil {
+			return 0, err
+		}
+	}
+	if err := os.RemoveAll(upgradePath); err != nil {
+		return 0, err
+	}
+
+	fileIDs, err := listDataFileIDs(directoryPath)
+	if err != nil {
+		return 0, err
+	}
+
+	legacyFileIDs := make(map[uint32]struct{})
+	for _, fileID := range fileIDs {
+		legacy, err := isLegacyDataFile(data.GetDataFileName(directoryPath, fileID))
+		if err != nil {
+			return 0, err
+		}
+		if legacy {
+			legacyFileIDs[fileID] = struct{}{}
+		}
+	}
+	if len(legacyFileIDs) == 0 {
+		return 0, nil
+	}
+
+	if err := os.MkdirAll(upgradePath, os.ModePerm); err != nil {
+		return 0, err
+	}
+
+	// the positions into the upgraded files move by the size of the header
+	shiftPos := func(pos *data.LogRecordPos) *data.LogRecordPos {
+		if _, ok := legacyFileIDs[pos.Fid]; ok {
+			pos.Offset += data.DataFileHeaderSize
+		}
+		return pos
+	}
+
+	for fileID := range legacyFileIDs {
+		if err := upgradeDataFile(directoryPath, upgradePath, fileID); err != nil {
+			return 0, err
+		}
+	}
+
+	directoryEntries, err := os.ReadDir(directoryPath)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range directoryEntries {
+		if !strings.HasSuffix(entry.Name(), data.HintFileNameSuffix) && entry.Name() != data.HintFileName {
+			continue
+		}
+
+		err := upgradeHintFile(filepath.Join(directoryPath, entry.Name()), filepath.Join(upgradePath, entry.Name()), shiftPos)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := upgradeBPlusTreeIndex(directoryPath, upgradePath, shiftPos); err != nil {
+		return 0, err
+	}
+	if err := upgradeManifest(directoryPath, upgradePath, legacyFileIDs); err != nil {
+		return 0, err
+	}
+
+	// from now on, the upgrade is completed by the next Upgrade even if the installation is interrupted
+	finishedFile, err := os.Create(filepath.Join(upgradePath, upgradeFinishedFileName))
+	if err != nil {
+		return 0, err
+	}
+	if err := finishedFile.Sync(); err != nil {
+		_ = finishedFile.Close()
+		return 0, err
+	}
+	if err := finishedFile.Close(); err != nil {
+		return 0, err
+	}
+
+	if err := installUpgradeFiles(directoryPath, upgradePath); err != nil {
+		return 0, err
+	}
+	return len(legacyFileIDs), os.RemoveAll(upgradePath)
+}
+
+// isLegacyDataFile checks whether a data file is written in the earlier format without a header
+// an empty data file gets its header when it is opened
+func isLegacyDataFile(fileName string) (bool, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	buffer := make([]byte, data.DataFileHeaderSize)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	_, err = data.DecodeDataFileHeader(buffer[:n])
+	if errors.Is(err, data.ErrDataFileHeaderMissing) {
+		return true, nil
+	}
+	return false, err
+}
+
+// upgradeDataFile writes a data file of the earlier format with a header in front of its records
+func upgradeDataFile(directoryPath, upgradePath string, fileID uint32) error {
+	srcFile, err := os.Open(data.GetDataFileName(directoryPath, fileID))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = srcFile.Close()
+	}()
+
+	destFile, err := os.Create(data.GetDataFileName(upgradePath, fileID))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = destFile.Close()
+	}()
+
+	// the records keep their IEEE CRC32 checksums
+	if _, err := destFile.Write(data.EncodeDataFileHeader(data.NewDataFileHeader(fileID, 0))); err != nil {
+		return err
+	}
+	if _, err := io.Copy(destFile, srcFile); err != nil {
+		return err
+	}
+
+	return destFile.Sync()
+}
+
+// upgradeHintFile rewrites a hint file with the positions moved into the upgraded data files
+func upgradeHintFile(srcName, destName string, shiftPos func(pos *data.LogRecordPos) *data.LogRecordPos) error {
+	srcFile, err := openRecordFile(srcName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = srcFile.Close()
+	}()
+
+	destFile, err := openRecordFile(destName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = destFile.Close()
+	}()
+
+	var offset int64 = 0
+	for {
+		record, size, err := srcFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		record.Value = data.EncodeLogRecordPos(shiftPos(data.DecodeLogRecordPos(record.Value)))
+		encRecord, _ := data.EncodeLogRecord(record)
+		if err := destFile.Write(encRecord); err != nil {
+			return err
+		}
+		offset += size
+	}
+
+	return destFile.Sync()
+}
+
+// upgradeBPlusTreeIndex rewrites the B+ tree index with the positions moved into the upgraded data files
+func upgradeBPlusTreeIndex(directoryPath, upgradePath string,
+	shiftPos func(pos *data.LogRecordPos) *data.LogRecordPos) error {
+	var keys [][]byte
+	var positions []*data.LogRecordPos
+	err := index.ForEachBPlusTreeEntry(directoryPath, func(key []byte, pos *data.LogRecordPos) bool {
+		keys = append(keys, append([]byte(nil), key...))
+		positions = append(positions, shiftPos(pos))
+		return true
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the index is synced once it is complete rather than after every entry
+	tree := index.NewBPlusTree(upgradePath, false)
+	for i, key := range keys {
+		tree.Put(key, positions[i])
+	}
+	if err := tree.Close(); err != nil {
+		return err
+	}
+
+	indexFile, err := os.Open(filepath.Join(upgradePath, index.BPlusTreeIndexFileName))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = indexFile.Close()
+	}()
+	return indexFile.Sync()
+}
+
+// upgradeManifest rewrites the manifest with the position of the sequence number moved into the upgraded data files
+func upgradeManifest(directoryPath, upgradePath string, legacyFileIDs map[uint32]struct{}) error {
+	m, err := openManifest(directoryPath)
+	if err != nil || m == nil {
+		return err
+	}
+	if err := m.close(); err != nil {
+		return err
+	}
+
+	if _, ok := legacyFileIDs[m.seqNoFileID]; ok {
+		m.seqNoOffset += data.DataFileHeaderSize
+	}
+
+	m, err = writeManifest(upgradePath, m.snapshot())
+	if err != nil {
+		return err
+	}
+	return m.close()
+}
+
+// installUpgradeFiles moves the upgraded files into the directory in place of the original ones
+//
+// every step can be repeated, so that an installation interrupted by a crash is completed by the next Upgrade.
+// the data files are moved last, so Open keeps failing on the remaining ones until the installation completes
+func installUpgradeFiles(directoryPath, upgradePath string) error {
+	directoryEntries, err := os.ReadDir(upgradePath)
+	if err != nil {
+		return err
+	}
+
+	var fileNames, dataFileNames []string
+	for _, entry := range directoryEntries {
+		if entry.Name() == upgradeFinishedFileName {
+			continue
+		}
+
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			dataFileNames = append(dataFileNames, entry.Name())
+		} else {
+			fileNames = append(fileNames, entry.Name())
+		}
+	}
+
+	for _, fileName := range append(fileNames, dataFileNames...) {
+		if err := os.Rename(filepath.Join(upgradePath, fileName), filepath.Join(directoryPath, fileName)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// openRecordFile opens a file made of log records, such as a hint file
+func openRecordFile(fileName string) (*data.DataFile, error) {
+	ioManager, err := fileio.NewIOManager(fileName, fileio.StandardFileIO)
+	if err != nil {
+		return nil, err
+	}
+	return &data.DataFile{IoManager: ioManager}, nil
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/index"
+	"github.com/LiuShuoJiang/betadb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// writeLegacyDataFile writes the records into a data file of the earlier format, and returns their positions
+func writeLegacyDataFile(t *testing.T, directory string, fileID uint32, records []*data.LogRecord) []*data.LogRecordPos {
+	dataFile, err := data.OpenLegacyDataFile(directory, fileID)
+	assert.Nil(t, err)
+	defer func() {
+		_ = dataFile.Close()
+	}()
+
+	var positions []*data.LogRecordPos
+	for _, record := range records {
+		encRecord, size := data.EncodeLogRecord(&data.LogRecord{
+			Key:   logRecordKeyWithSeq(record.Key, nonTransactionSeqNo),
+			Value: record.Value,
+			Type:  record.Type,
+		})
+		positions = append(positions, &data.LogRecordPos{Fid: fileID, Offset: dataFile.WriteOffset, Size: uint32(size)})
+		assert.Nil(t, dataFile.Write(encRecord))
+	}
+	return positions
+}
+
+// TestUpgrade tests for upgrading a data directory of the earlier format
+func TestUpgrade(t *testing.T) {
+	directory, _ := os.MkdirTemp("", "betadb-upgrade")
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	var records []*data.LogRecord
+	for i := 0; i < 100; i++ {
+		records = append(records, &data.LogRecord{Key: utils.GetTestKey(i), Value: utils.RandomValue(24)})
+	}
+	positions := writeLegacyDataFile(t, directory, 0, records)
+	writeLegacyDataFile(t, directory, 1, []*data.LogRecord{
+		{Key: utils.GetTestKey(0), Type: data.LogRecordDeleted},
+		{Key: utils.GetTestKey(100), Value: []byte("new")},
+	})
+
+	// the immutable file is loaded from its hint file, whose positions must be moved as well
+	hintFile, err := data.OpenDataHintFile(directory, 0)
+	assert.Nil(t, err)
+	for i, record := range records {
+		hintRecord := &data.LogRecord{Key: logRecordKeyWithSeq(record.Key, nonTransactionSeqNo)}
+		assert.Nil(t, hintFile.WriteDataHintRecord(hintRecord, positions[i]))
+	}
+	assert.Nil(t, hintFile.Close())
+
+	report, err := Check(directory)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(report.Issues))
+	for _, issue := range report.Issues {
+		assert.Equal(t, UnsupportedFormat, issue.Type)
+	}
+
+	upgradeReport, err := Upgrade(directory)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, upgradeReport.FileNum)
+	_, err = os.Stat(siblingDirectoryPath(directory, upgradeDirectoryName))
+	assert.True(t, os.IsNotExist(err))
+
+	report, err = Check(directory)
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy(), "%v", report.Issues)
+
+	options := DefaultOptions
+	options.DirectoryPath = directory
+	db, err := Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, data.DataFileVersion, db.activeFile.Header.Version)
+	assert.Equal(t, 100, len(db.ListKeys()))
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for i := 1; i < 100; i++ {
+		value, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, records[i].Value, value)
+	}
+	value, err := db.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), value)
+	assert.Nil(t, db.Close())
+
+	// nothing is left to upgrade
+	upgradeReport, err = Upgrade(directory)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, upgradeReport.FileNum)
+}
+
+// TestUpgrade_BPlusTree tests for upgrading the positions persisted in the B+ tree index
+func TestUpgrade_BPlusTree(t *testing.T) {
+	directory, _ := os.MkdirTemp("", "betadb-upgrade")
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	var records []*data.LogRecord
+	for i := 0; i < 10; i++ {
+		records = append(records, &data.LogRecord{Key: utils.GetTestKey(i), Value: utils.RandomValue(24)})
+	}
+	positions := writeLegacyDataFile(t, directory, 0, records)
+
+	tree := index.NewBPlusTree(directory, false)
+	for i, record := range records {
+		tree.Put(record.Key, positions[i])
+	}
+	assert.Nil(t, tree.Close())
+
+	upgradeReport, err := Upgrade(directory)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, upgradeReport.FileNum)
+
+	options := DefaultOptions
+	options.DirectoryPath = directory
+	options.IndexType = BPlusTree
+	db, err := Open(options)
+	assert.Nil(t, err)
+	for _, record := range records {
+		value, err := db.Get(record.Key)
+		assert.Nil(t, err)
+		assert.Equal(t, record.Value, value)
+	}
+	assert.Nil(t, db.Close())
+}
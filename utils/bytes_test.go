@@ -0,0 +1,25 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("ab"), PrefixEnd([]byte("aa")))
+	assert.Equal(t, []byte("b"), PrefixEnd([]byte{'a', 0xff}))
+	assert.Nil(t, PrefixEnd([]byte{0xff, 0xff}))
+	assert.Nil(t, PrefixEnd(nil))
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"container/list"
+	"github.com/LiuShuoJiang/betadb/data"
+	"sync"
+	"sync/atomic"
+)
+
+// valueCacheShardNum is the number of shards of the value cache, each locked on its own
+const valueCacheShardNum = 16
+
+// valueCacheEntryOverhead is the approximate number of bytes used by an entry besides its value
+const valueCacheEntryOverhead = 96
+
+// valueCache is a sharded LRU cache of the values read from the data files, keyed by their position
+//
+// the position of a record is reused once its data file is replaced by a merge or a compaction,
+// so every entry keeps the data file it was read from, and only matches the reads of the same file
+type valueCache struct {
+	shards [valueCacheShardNum]valueCacheShard
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type valueCacheShard struct {
+	// capacity is the maximum number of bytes of the entries in the shard
+	capacity int64
+
+	// size is the number of bytes of the entries in the shard
+	size int64
+
+	entries map[valueCacheKey]*list.Element
+
+	// lru lists the entries from the most recently used to the least recently used one
+	lru *list.List
+
+	mu sync.Mutex
+}
+
+type valueCacheKey struct {
+	fid    uint32
+	offset int64
+}
+
+type valueCacheEntry struct {
+	key      valueCacheKey
+	dataFile *data.DataFile
+	value    []byte
+}
+
+// newValueCache creates a value cache holding up to capacity bytes
+func newValueCache(capacity int64) *valueCache {
+	cache := &valueCache{}
+	for i := range cache.shards {
+		cache.shards[i] = valueCacheShard{
+			capacity: capacity / valueCacheShardNum,
+			entries:  make(map[valueCacheKey]*list.Element),
+			lru:      list.New(),
+		}
+	}
+
+	return cache
+}
+
+// shard gets the shard of the position
+func (c *valueCache) shard(key valueCacheKey) *valueCacheShard {
+	hash := (uint64(key.fid)<<32 ^ uint64(key.offset)) * 0x9e3779b97f4a7c15
+	return &c.shards[hash>>60]
+}
+
+// get gets a copy of the value read from the data file at the position
+func (c *valueCache) get(dataFile *data.DataFile, pos *data.LogRecordPos) ([]byte, bool) {
+	key := valueCacheKey{fid: pos.Fid, offset: pos.Offset}
+	shard := c.shard(key)
+
+	shard.mu.Lock()
+	element, ok := shard.entries[key]
+	if !ok || element.Value.(*valueCacheEntry).dataFile != dataFile {
+		shard.mu.Unlock()
+		c.misses.Add(1)
+		return nil, false
+	}
+	shard.lru.MoveToFront(element)
+	value := element.Value.(*valueCacheEntry).value
+	shard.mu.Unlock()
+
+	c.hits.Add(1)
+	// the caller may modify the value returned
+	return append([]byte{}, value...), true
+}
+
+// put caches a copy of the value read from the data file at the position,
+// evicting the least recently used entries of the shard beyond its capacity
+func (c *valueCache) put(dataFile *data.DataFile, pos *data.LogRecordPos, value []byte) {
+	entrySize := int64(len(value)) + valueCacheEntryOverhead
+	key := valueCacheKey{fid: pos.Fid, offset: pos.Offset}
+	shard := c.shard(key)
+	if entrySize > shard.capacity {
+		return
+	}
+	value = append([]byte{}, value...)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if element, ok := shard.entries[key]; ok {
+		entry := element.Value.(*valueCacheEntry)
+		shard.size += int64(len(value) - len(entry.value))
+		entry.dataFile, entry.value = dataFile, value
+		shard.lru.MoveToFront(element)
+	} else {
+		shard.entries[key] = shard.lru.PushFront(&valueCacheEntry{key: key, dataFile: dataFile, value: value})
+		shard.size += entrySize
+	}
+
+	for shard.size > shard.capacity {
+		shard.remove(shard.lru.Back())
+	}
+}
+
+// invalidate drops the values of the data files matching the file ids, which are being replaced
+func (c *valueCache) invalidate(match func(fid uint32) bool) {
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.mu.Lock()
+		for key, element := range shard.entries {
+			if match(key.fid) {
+				shard.remove(element)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// remove removes the entry from the shard
+// must hold a mutex lock before accessing this method
+func (s *valueCacheShard) remove(element *list.Element) {
+	entry := s.lru.Remove(element).(*valueCacheEntry)
+	delete(s.entries, entry.key)
+	s.size -= int64(len(entry.value)) + valueCacheEntryOverhead
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2024. Shuojiang Liu.
+ * Licensed under the MIT License (the "License");
+ * you may not use this file except in compliance with the License.
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package betadb
+
+import (
+	"github.com/LiuShuoJiang/betadb/data"
+	"github.com/LiuShuoJiang/betadb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestValueCache(t *testing.T) {
+	cache := newValueCache(valueCacheShardNum * (2*valueCacheEntryOverhead + 16))
+	dataFile, newFile := &data.DataFile{FileID: 1}, &data.DataFile{FileID: 1}
+	pos := &data.LogRecordPos{Fid: 1, Offset: 32}
+
+	_, ok := cache.get(dataFile, pos)
+	assert.False(t, ok)
+
+	value := []byte("betadb")
+	cache.put(dataFile, pos, value)
+	cached, ok := cache.get(dataFile, pos)
+	assert.True(t, ok)
+	assert.Equal(t, value, cached)
+
+	// the values are copied in and out of the cache
+	value[0], cached[1] = 'B', 'E'
+	cached, ok = cache.get(dataFile, pos)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("betadb"), cached)
+
+	// the same position of another file does not match
+	_, ok = cache.get(newFile, pos)
+	assert.False(t, ok)
+	cache.put(newFile, pos, []byte("new-value"))
+	cached, ok = cache.get(newFile, pos)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("new-value"), cached)
+	_, ok = cache.get(dataFile, pos)
+	assert.False(t, ok)
+
+	// the least recently used entries of a shard are evicted beyond its capacity
+	shard := cache.shard(valueCacheKey{fid: pos.Fid, offset: pos.Offset})
+	var positions []*data.LogRecordPos
+	for offset := int64(0); len(positions) < 3; offset++ {
+		if cache.shard(valueCacheKey{fid: 2, offset: offset}) == shard {
+			positions = append(positions, &data.LogRecordPos{Fid: 2, Offset: offset})
+		}
+	}
+	for _, p := range positions {
+		cache.put(dataFile, p, []byte("value"))
+	}
+	assert.Equal(t, 2, len(shard.entries))
+	assert.LessOrEqual(t, shard.size, shard.capacity)
+	_, ok = cache.get(newFile, pos)
+	assert.False(t, ok)
+	_, ok = cache.get(dataFile, positions[0])
+	assert.False(t, ok)
+	_, ok = cache.get(dataFile, positions[2])
+	assert.True(t, ok)
+
+	// a value larger than a shard is not cached
+	cache.put(dataFile, pos, make([]byte, shard.capacity))
+	_, ok = cache.get(dataFile, pos)
+	assert.False(t, ok)
+
+	// the values of the invalidated files are dropped
+	cache.invalidate(func(fid uint32) bool {
+		return fid == 2
+	})
+	_, ok = cache.get(dataFile, positions[2])
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), shard.size)
+	assert.Equal(t, 0, shard.lru.Len())
+
+	assert.Equal(t, uint64(4), cache.hits.Load())
+	assert.Equal(t, uint64(7), cache.misses.Load())
+}
+
+func TestDatabase_ValueCache(t *testing.T) {
+	options := DefaultOptions
+	directory, _ := os.MkdirTemp("", "betadb-value-cache")
+	options.DirectoryPath = directory
+	options.DataFileSize = 64 * 1024
+	options.DataFileMergeRatio = 0
+	options.ValueCacheSize = 4 * 1024 * 1024
+
+	db, err := Open(options)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 5000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	check := func(get func([]byte) ([]byte, error), newValueNum int) {
+		for i := 0; i < 5000; i++ {
+			value, err := get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			if i < newValueNum {
+				assert.Equal(t, []byte("new-value"), value)
+			} else {
+				assert.Equal(t, utils.GetTestKey(i), value)
+			}
+		}
+	}
+
+	// the values are read from the data files once
+	check(db.Get, 0)
+	stat := db.Stat()
+	assert.Equal(t, uint64(0), stat.ValueCacheHits)
+	assert.Equal(t, uint64(5000), stat.ValueCacheMisses)
+	check(db.Get, 0)
+	stat = db.Stat()
+	assert.Equal(t, uint64(5000), stat.ValueCacheHits)
+	assert.Equal(t, uint64(5000), stat.ValueCacheMisses)
+
+	// the value returned can be modified
+	value, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	value[0] = 'x'
+	check(db.Get, 0)
+
+	// the positions reused by the merged files and the compacted ones do not return the cached values
+	snapshot := db.NewSnapshot()
+	defer snapshot.Release()
+	for i := 0; i < 2500; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("new-value"))
+		assert.Nil(t, err)
+	}
+	check(db.Get, 2500)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	check(db.Get, 2500)
+	check(snapshot.Get, 0)
+
+	for i := 0; i < 2500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Compact(0)
+	assert.Nil(t, err)
+	for i := 2500; i < 5000; i++ {
+		value, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), value)
+	}
+	check(snapshot.Get, 0)
+
+	// the value cache is disabled by default
+	assert.Nil(t, db.Close())
+	options.ValueCacheSize = 0
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.valueCache)
+	_, err = db.Get(utils.GetTestKey(4999))
+	assert.Nil(t, err)
+	stat = db.Stat()
+	assert.Equal(t, uint64(0), stat.ValueCacheHits+stat.ValueCacheMisses)
+}